@@ -1,15 +1,26 @@
 package main
 
 import (
+	"crypto/rsa"
+	"crypto/tls"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"time"
 
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/agent"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/config/env"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/encryption"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/logger"
+	pb "github.com/funkymotions/go-ya-practicum-metrics/internal/proto"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/spool"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tlsconfig"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
@@ -17,21 +28,93 @@ func main() {
 	if err != nil {
 		log.Fatal("failed to create logger")
 	}
-	maxRetrySendCount := 3
 	options := env.ParseAgentOptions()
+	collectors, err := agent.ParseCollectorConfigs(*options.DisabledCollectors, *options.CollectorIntervals)
+	if err != nil {
+		log.Fatalf("failed to parse collectors config: %v", err)
+	}
+	var batchSpool *spool.Spool
+	if *options.SpoolDir != "" {
+		batchSpool, err = spool.Open(spool.Config{
+			Dir:      *options.SpoolDir,
+			MaxBytes: *options.SpoolMaxBytes,
+			MaxAge:   time.Duration(*options.SpoolMaxAge) * time.Second,
+			Logger:   l,
+		})
+		if err != nil {
+			log.Fatalf("failed to open spool: %v", err)
+		}
+		defer batchSpool.Close()
+	}
+	var publicKey *rsa.PublicKey
+	if *options.CryptoKey != "" {
+		publicKey, err = encryption.LoadPublicKey(*options.CryptoKey)
+		if err != nil {
+			log.Fatalf("failed to load public key: %v", err)
+		}
+	}
+	if *options.TenantID != "" && !tenant.Valid(*options.TenantID) {
+		log.Fatalf("invalid tenant: %s", *options.TenantID)
+	}
+	scheme := "http"
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	var tlsClientConfig *tls.Config
+	if *options.TLSCAFile != "" || *options.TLSCertFile != "" {
+		tlsConfig := options.TLSConfig()
+		tlsConfig.Logger = l
+		reloader, err := tlsconfig.NewReloader(tlsConfig)
+		if err != nil {
+			log.Fatalf("failed to load TLS files: %v", err)
+		}
+		stopCh, doneCh := make(chan struct{}), make(chan struct{})
+		go reloader.Run(stopCh, doneCh)
+		defer func() {
+			close(stopCh)
+			<-doneCh
+		}()
+		// server certificate must be issued for the host agent dials
+		endpoint := *options.Endpoint
+		if *options.GRPCAddress != "" {
+			endpoint = *options.GRPCAddress
+		}
+		host, _, err := net.SplitHostPort(endpoint)
+		if err != nil {
+			host = endpoint
+		}
+		tlsClientConfig = reloader.ClientConfig(host)
+		transport.TLSClientConfig = tlsClientConfig
+		scheme = "https"
+	}
+	var grpcClient pb.MetricsServiceClient
+	if *options.GRPCAddress != "" {
+		if publicKey != nil {
+			log.Fatal("crypto key is not supported by gRPC, use TLS instead")
+		}
+		creds := insecure.NewCredentials()
+		if tlsClientConfig != nil {
+			creds = credentials.NewTLS(tlsClientConfig)
+		}
+		conn, err := grpc.NewClient(*options.GRPCAddress, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			log.Fatalf("failed to create gRPC client: %v", err)
+		}
+		defer conn.Close()
+		grpcClient = pb.NewMetricsServiceClient(conn)
+	}
 	agent := agent.NewAgent(&agent.Config{
 		Logger: l,
 		MetricURL: url.URL{
-			Scheme: "http",
+			Scheme: scheme,
 			Host:   *options.Endpoint,
 			Path:   "/updates/",
 		},
 		Client: &http.Client{
-			Timeout: 200 * time.Millisecond,
+			Timeout:   200 * time.Millisecond,
+			Transport: transport,
 		},
 		PollInterval:   time.Duration(*options.PollInterval) * time.Second,
 		ReportInterval: time.Duration(*options.ReportInterval) * time.Second,
-		MaxRetries:     maxRetrySendCount,
+		Retry:          options.RetryPolicy(),
 		RateLimit:      *options.RateLimit,
 		Hashing: struct {
 			Key        *string
@@ -40,6 +123,15 @@ func main() {
 			Key:        options.Key,
 			HeaderName: "hashsha256",
 		},
+		Collectors:      collectors,
+		ProcRoot:        *options.ProcRoot,
+		Spool:           batchSpool,
+		CompressMinSize: *options.CompressMinSize,
+		PublicKey:       publicKey,
+		APIKey:          *options.APIKey,
+		Tenant:          *options.TenantID,
+		GRPCClient:      grpcClient,
+		GRPCAddress:     *options.GRPCAddress,
 	})
 	agent.Launch()
 }
@@ -0,0 +1,190 @@
+package access
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+)
+
+const (
+	// RealIPHeader carries agent address on its outbound interface
+	RealIPHeader = "X-Real-IP"
+	APIKeyHeader = "X-API-Key"
+)
+
+type Scope string
+
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	// admin scope grants every other scope
+	ScopeAdmin Scope = "admin"
+)
+
+type Key struct {
+	Name   string  `json:"name"`
+	Key    string  `json:"key"`
+	Scopes []Scope `json:"scopes"`
+	// tenant every request with the key belongs to, empty lets
+	// request choose tenant by header
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// TenantLimits overrides server wide limits for tenant
+type TenantLimits struct {
+	// zero means server default
+	MaxSeries int `json:"maxSeries"`
+}
+
+// Policy restricts ingestion to trusted subnets and requires API keys
+// with matching scope, empty lists disable the respective check
+type Policy struct {
+	TrustedSubnets []string                `json:"trustedSubnets"`
+	Keys           []Key                   `json:"keys"`
+	Tenants        map[string]TenantLimits `json:"tenants"`
+	subnets        []*net.IPNet
+}
+
+// Denied explains why request was rejected
+type Denied struct {
+	Reason string
+}
+
+func (e *Denied) Error() string {
+	return "access denied: " + e.Reason
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to decode access policy %s: %v", path, err)
+	}
+	if err := p.compile(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (p *Policy) compile() error {
+	p.subnets = make([]*net.IPNet, 0, len(p.TrustedSubnets))
+	for _, cidr := range p.TrustedSubnets {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted subnet %q: %v", cidr, err)
+		}
+		p.subnets = append(p.subnets, subnet)
+	}
+	names := make(map[string]struct{}, len(p.Keys))
+	keys := make(map[string]struct{}, len(p.Keys))
+	for _, k := range p.Keys {
+		if k.Name == "" || k.Key == "" {
+			return fmt.Errorf("API key must have name and key")
+		}
+		if _, exists := names[k.Name]; exists {
+			return fmt.Errorf("duplicate API key name: %s", k.Name)
+		}
+		if _, exists := keys[k.Key]; exists {
+			return fmt.Errorf("duplicate API key of %s", k.Name)
+		}
+		names[k.Name] = struct{}{}
+		keys[k.Key] = struct{}{}
+		if k.Tenant != "" && !tenant.Valid(k.Tenant) {
+			return fmt.Errorf("invalid tenant %q of API key %s", k.Tenant, k.Name)
+		}
+		for _, s := range k.Scopes {
+			switch s {
+			case ScopeRead, ScopeWrite, ScopeAdmin:
+			default:
+				return fmt.Errorf("unknown scope %q of API key %s", s, k.Name)
+			}
+		}
+	}
+	for id, limits := range p.Tenants {
+		if !tenant.Valid(id) {
+			return fmt.Errorf("invalid tenant %q", id)
+		}
+		if limits.MaxSeries < 0 {
+			return fmt.Errorf("negative series quota of tenant %s", id)
+		}
+	}
+	return nil
+}
+
+// KeyTenant returns tenant bound to API key
+func (p *Policy) KeyTenant(apiKey string) (string, bool) {
+	key := p.lookup(apiKey)
+	if key == nil || key.Tenant == "" {
+		return "", false
+	}
+	return key.Tenant, true
+}
+
+// MaxSeries returns series quota of tenant set by policy
+func (p *Policy) MaxSeries(id string) (int, bool) {
+	limits, ok := p.Tenants[id]
+	if !ok || limits.MaxSeries == 0 {
+		return 0, false
+	}
+	return limits.MaxSeries, true
+}
+
+// Check authorizes request needing scope, subnets guard writes only as
+// readers are not agents and do not report their address
+func (p *Policy) Check(realIP, apiKey string, scope Scope) error {
+	if scope == ScopeWrite && len(p.subnets) > 0 {
+		if err := p.checkSubnet(realIP); err != nil {
+			return err
+		}
+	}
+	if len(p.Keys) == 0 {
+		return nil
+	}
+	if apiKey == "" {
+		return &Denied{Reason: "missing " + APIKeyHeader + " header"}
+	}
+	key := p.lookup(apiKey)
+	if key == nil {
+		return &Denied{Reason: "unknown API key"}
+	}
+	if !slices.Contains(key.Scopes, scope) && !slices.Contains(key.Scopes, ScopeAdmin) {
+		return &Denied{Reason: fmt.Sprintf("API key %s lacks %s scope", key.Name, scope)}
+	}
+	return nil
+}
+
+func (p *Policy) checkSubnet(realIP string) error {
+	if realIP == "" {
+		return &Denied{Reason: "missing " + RealIPHeader + " header"}
+	}
+	ip := net.ParseIP(realIP)
+	if ip == nil {
+		return &Denied{Reason: "invalid " + RealIPHeader + " header"}
+	}
+	for _, subnet := range p.subnets {
+		if subnet.Contains(ip) {
+			return nil
+		}
+	}
+	return &Denied{Reason: fmt.Sprintf("%s is not in trusted subnets", ip)}
+}
+
+// lookup compares every key in constant time, so timing does not reveal
+// how much of the key matched
+func (p *Policy) lookup(apiKey string) *Key {
+	var found *Key
+	for i := range p.Keys {
+		if subtle.ConstantTimeCompare([]byte(p.Keys[i].Key), []byte(apiKey)) == 1 {
+			found = &p.Keys[i]
+		}
+	}
+	return found
+}
@@ -0,0 +1,188 @@
+package access
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testPolicy = `{
+	"trustedSubnets": ["10.0.0.0/8", "fd00::/8"],
+	"keys": [
+		{"name": "agent", "key": "agent-key", "scopes": ["write"]},
+		{"name": "grafana", "key": "grafana-key", "scopes": ["read"]},
+		{"name": "ops", "key": "ops-key", "scopes": ["admin"]}
+	]
+}`
+
+func writePolicy(t *testing.T, dir, data string) string {
+	t.Helper()
+	path := filepath.Join(dir, "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, t.TempDir(), testPolicy))
+	require.NoError(t, err)
+	tests := []struct {
+		name       string
+		realIP     string
+		apiKey     string
+		scope      Scope
+		wantReason string
+	}{
+		{name: "should allow agent from trusted subnet", realIP: "10.1.2.3", apiKey: "agent-key", scope: ScopeWrite},
+		{name: "should allow agent from trusted IPv6 subnet", realIP: "fd00::1", apiKey: "agent-key", scope: ScopeWrite},
+		{name: "should allow reader without address", apiKey: "grafana-key", scope: ScopeRead},
+		{name: "should allow admin every scope", realIP: "10.0.0.1", apiKey: "ops-key", scope: ScopeWrite},
+		{name: "should allow admin scope", apiKey: "ops-key", scope: ScopeAdmin},
+		{
+			name:       "should reject write without address",
+			apiKey:     "agent-key",
+			scope:      ScopeWrite,
+			wantReason: "missing X-Real-IP header",
+		},
+		{
+			name:       "should reject malformed address",
+			realIP:     "10.0.0",
+			apiKey:     "agent-key",
+			scope:      ScopeWrite,
+			wantReason: "invalid X-Real-IP header",
+		},
+		{
+			name:       "should reject untrusted address",
+			realIP:     "192.168.0.1",
+			apiKey:     "agent-key",
+			scope:      ScopeWrite,
+			wantReason: "192.168.0.1 is not in trusted subnets",
+		},
+		{
+			name:       "should reject missing key",
+			scope:      ScopeRead,
+			wantReason: "missing X-API-Key header",
+		},
+		{
+			name:       "should reject unknown key",
+			apiKey:     "agent-ke",
+			scope:      ScopeRead,
+			wantReason: "unknown API key",
+		},
+		{
+			name:       "should reject write-only key reading",
+			apiKey:     "agent-key",
+			scope:      ScopeRead,
+			wantReason: "API key agent lacks read scope",
+		},
+		{
+			name:       "should reject read-only key writing",
+			realIP:     "10.0.0.1",
+			apiKey:     "grafana-key",
+			scope:      ScopeWrite,
+			wantReason: "API key grafana lacks write scope",
+		},
+		{
+			name:       "should reject non-admin key on admin route",
+			apiKey:     "grafana-key",
+			scope:      ScopeAdmin,
+			wantReason: "API key grafana lacks admin scope",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.realIP, tt.apiKey, tt.scope)
+			if tt.wantReason == "" {
+				require.NoError(t, err)
+				return
+			}
+			var denied *Denied
+			require.True(t, errors.As(err, &denied))
+			require.Equal(t, tt.wantReason, denied.Reason)
+		})
+	}
+}
+
+func TestPolicy_CheckEmpty(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, t.TempDir(), `{}`))
+	require.NoError(t, err)
+	require.NoError(t, policy.Check("", "", ScopeWrite), "empty policy should allow everything")
+}
+
+func TestPolicy_Tenants(t *testing.T) {
+	policy, err := LoadPolicy(writePolicy(t, t.TempDir(), `{
+		"keys": [
+			{"name": "a", "key": "a-key", "scopes": ["write"], "tenant": "team-a"},
+			{"name": "ops", "key": "ops-key", "scopes": ["admin"]}
+		],
+		"tenants": {"team-a": {"maxSeries": 100}, "team-b": {}}
+	}`))
+	require.NoError(t, err)
+	id, ok := policy.KeyTenant("a-key")
+	require.True(t, ok)
+	require.Equal(t, "team-a", id)
+	_, ok = policy.KeyTenant("ops-key")
+	require.False(t, ok, "unbound key should not imply tenant")
+	_, ok = policy.KeyTenant("unknown")
+	require.False(t, ok)
+	limit, ok := policy.MaxSeries("team-a")
+	require.True(t, ok)
+	require.Equal(t, 100, limit)
+	_, ok = policy.MaxSeries("team-b")
+	require.False(t, ok, "zero quota should fall back to server default")
+}
+
+func TestLoadPolicy_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{name: "should reject malformed JSON", data: `{`},
+		{name: "should reject invalid subnet", data: `{"trustedSubnets": ["10.0.0.1"]}`},
+		{name: "should reject key without name", data: `{"keys": [{"key": "k", "scopes": ["read"]}]}`},
+		{name: "should reject unknown scope", data: `{"keys": [{"name": "a", "key": "k", "scopes": ["root"]}]}`},
+		{name: "should reject invalid key tenant", data: `{"keys": [{"name": "a", "key": "k", "tenant": "a b"}]}`},
+		{name: "should reject negative quota", data: `{"tenants": {"team-a": {"maxSeries": -1}}}`},
+		{
+			name: "should reject duplicate key",
+			data: `{"keys": [{"name": "a", "key": "k", "scopes": ["read"]}, {"name": "b", "key": "k", "scopes": ["read"]}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadPolicy(writePolicy(t, t.TempDir(), tt.data))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestReloader_reload(t *testing.T) {
+	path := writePolicy(t, t.TempDir(), `{"keys": [{"name": "a", "key": "old", "scopes": ["read"]}]}`)
+	r, err := NewReloader(path, time.Second, zap.NewNop())
+	require.NoError(t, err)
+	require.NoError(t, r.Policy().Check("", "old", ScopeRead))
+
+	reloaded, err := r.reload()
+	require.NoError(t, err)
+	require.False(t, reloaded, "unchanged file should not be reloaded")
+
+	later := time.Now().Add(time.Minute)
+	writePolicy(t, filepath.Dir(path), `{"keys": [`)
+	require.NoError(t, os.Chtimes(path, later, later))
+	_, err = r.reload()
+	require.Error(t, err)
+	require.NoError(t, r.Policy().Check("", "old", ScopeRead), "broken file should keep previous policy")
+
+	writePolicy(t, filepath.Dir(path), `{"keys": [{"name": "a", "key": "new", "scopes": ["read"]}]}`)
+	later = later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+	reloaded, err = r.reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Error(t, r.Policy().Check("", "old", ScopeRead))
+	require.NoError(t, r.Policy().Check("", "new", ScopeRead))
+}
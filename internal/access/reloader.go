@@ -0,0 +1,77 @@
+package access
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Reloader serves policy loaded from file and reloads it once file changes,
+// broken file keeps previous policy in use
+type Reloader struct {
+	path     string
+	interval time.Duration
+	logger   *zap.Logger
+	policy   atomic.Pointer[Policy]
+	modTime  time.Time
+	size     int64
+}
+
+func NewReloader(path string, interval time.Duration, logger *zap.Logger) (*Reloader, error) {
+	r := &Reloader{
+		path:     path,
+		interval: interval,
+		logger:   logger,
+	}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Policy returns policy currently in use
+func (r *Reloader) Policy() *Policy {
+	return r.policy.Load()
+}
+
+func (r *Reloader) Run(stopCh chan struct{}, doneCh chan struct{}) {
+	defer close(doneCh)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := r.reload()
+			if err != nil {
+				r.logger.Error("Failed to reload access policy", zap.Error(err))
+				continue
+			}
+			if reloaded {
+				r.logger.Info("Access policy reloaded")
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// reload loads file when it changed since the last load
+func (r *Reloader) reload() (bool, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, err
+	}
+	if r.policy.Load() != nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return false, nil
+	}
+	policy, err := LoadPolicy(r.path)
+	if err != nil {
+		return false, err
+	}
+	r.policy.Store(policy)
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+	return true, nil
+}
@@ -2,12 +2,14 @@ package agent
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
+	"compress/gzip"
+	"context"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -16,51 +18,23 @@ import (
 	"sync"
 	"time"
 
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/encryption"
 	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	pb "github.com/funkymotions/go-ya-practicum-metrics/internal/proto"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/spool"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
-	"github.com/shirou/gopsutil/cpu"
-	"github.com/shirou/gopsutil/mem"
 	"go.uber.org/zap"
 )
 
-type getter func(runtime.MemStats) float64
-
 const contentType = "application/json"
 
-var getters = map[string]getter{
-	"Alloc":         func(stats runtime.MemStats) float64 { return float64(stats.Alloc) },
-	"BuckHashSys":   func(stats runtime.MemStats) float64 { return float64(stats.BuckHashSys) },
-	"Frees":         func(stats runtime.MemStats) float64 { return float64(stats.Frees) },
-	"GCCPUFraction": func(stats runtime.MemStats) float64 { return float64(stats.GCCPUFraction) },
-	"GCSys":         func(stats runtime.MemStats) float64 { return float64(stats.GCSys) },
-	"HeapAlloc":     func(stats runtime.MemStats) float64 { return float64(stats.HeapAlloc) },
-	"HeapIdle":      func(stats runtime.MemStats) float64 { return float64(stats.HeapIdle) },
-	"HeapInuse":     func(stats runtime.MemStats) float64 { return float64(stats.HeapInuse) },
-	"HeapObjects":   func(stats runtime.MemStats) float64 { return float64(stats.HeapObjects) },
-	"HeapReleased":  func(stats runtime.MemStats) float64 { return float64(stats.HeapReleased) },
-	"HeapSys":       func(stats runtime.MemStats) float64 { return float64(stats.HeapSys) },
-	"LastGC":        func(stats runtime.MemStats) float64 { return float64(stats.LastGC) },
-	"Lookups":       func(stats runtime.MemStats) float64 { return float64(stats.Lookups) },
-	"MCacheInuse":   func(stats runtime.MemStats) float64 { return float64(stats.MCacheInuse) },
-	"MCacheSys":     func(stats runtime.MemStats) float64 { return float64(stats.MCacheSys) },
-	"Mallocs":       func(stats runtime.MemStats) float64 { return float64(stats.Mallocs) },
-	"NextGC":        func(stats runtime.MemStats) float64 { return float64(stats.NextGC) },
-	"NumForcedGC":   func(stats runtime.MemStats) float64 { return float64(stats.NumForcedGC) },
-	"OtherSys":      func(stats runtime.MemStats) float64 { return float64(stats.OtherSys) },
-	"PauseTotalNs":  func(stats runtime.MemStats) float64 { return float64(stats.PauseTotalNs) },
-	"MSpanInuse":    func(stats runtime.MemStats) float64 { return float64(stats.MSpanInuse) },
-	"MSpanSys":      func(stats runtime.MemStats) float64 { return float64(stats.MSpanSys) },
-	"StackInuse":    func(stats runtime.MemStats) float64 { return float64(stats.StackInuse) },
-	"StackSys":      func(stats runtime.MemStats) float64 { return float64(stats.StackSys) },
-	"Sys":           func(stats runtime.MemStats) float64 { return float64(stats.Sys) },
-	"TotalAlloc":    func(stats runtime.MemStats) float64 { return float64(stats.TotalAlloc) },
-	"NumGC":         func(stats runtime.MemStats) float64 { return float64(stats.NumGC) },
-}
-
 type agent struct {
-	config  *Config
-	metrics map[string]models.Metrics
-	mu      sync.Mutex
+	config     *Config
+	metrics    map[string]models.Metrics
+	mu         sync.Mutex
+	collectors []*registeredCollector
 }
 
 type Config struct {
@@ -70,11 +44,29 @@ type Config struct {
 	RateLimit      int
 	MetricURL      url.URL
 	Logger         *zap.Logger
-	MaxRetries     int
-	Hashing        struct {
+	// retries of delivery attempts, zero value sends once
+	Retry   utils.RetryPolicy
+	Hashing struct {
 		Key        *string
 		HeaderName string
 	}
+	// per collector settings by collector name
+	Collectors map[string]CollectorConfig
+	// procfs mount point read by platform collectors
+	ProcRoot string
+	// on-disk queue of undelivered batches, nil disables spooling
+	Spool *spool.Spool
+	// bodies of at least this size are sent gzipped, zero disables compression
+	CompressMinSize int
+	// server public key encrypting request bodies, nil sends them as is
+	PublicKey *rsa.PublicKey
+	// key identifying agent to server access control, empty sends none
+	APIKey string
+	// tenant owning reported metrics, empty sends none
+	Tenant string
+	// batches are sent over gRPC to server at GRPCAddress when client is set
+	GRPCClient  pb.MetricsServiceClient
+	GRPCAddress string
 }
 
 type retriableError struct {
@@ -93,6 +85,10 @@ func (r *retriableError) Unwrap() error {
 	return r.err
 }
 
+func (r *retriableError) IsRetriable() bool {
+	return true
+}
+
 func NewAgent(cfg *Config) *agent {
 	return &agent{
 		config:  cfg,
@@ -107,6 +103,9 @@ func (m *agent) Launch() {
 		zap.Duration("reportInterval", m.config.ReportInterval),
 		zap.Duration("pollInterval", m.config.PollInterval),
 	)
+	if len(m.collectors) == 0 {
+		m.registerDefaultCollectors()
+	}
 	stop := make(chan struct{})
 	defer close(stop)
 	fmt.Printf("Agent started with RateLimit = %d\n", m.config.RateLimit)
@@ -128,31 +127,27 @@ func (m *agent) Launch() {
 }
 
 func (m *agent) processMetricsByWorker(stopCh chan struct{}, jobs chan models.Metrics) {
+	ctx, cancel := stopContext(stopCh)
+	defer cancel()
 	for {
 		select {
 		case <-stopCh:
 			return
 		case job := <-jobs:
-			m.processMetric(job)
+			m.processMetric(ctx, job)
 		}
 	}
 }
 
-func (m *agent) processMetric(metric models.Metrics) error {
+func (m *agent) processMetric(ctx context.Context, metric models.Metrics) error {
 	fmt.Printf("sending HTTP request for metric ID: %s\n", metric.ID)
-	body, err := json.Marshal(metric)
-	if err != nil {
-		return err
-	}
-	req, err := http.NewRequest(http.MethodPost, m.config.MetricURL.String(), bytes.NewBuffer(body))
-	if err != nil {
-		return err
-	}
-	resp, err := m.config.Client.Do(req)
-	if err != nil {
+	url := m.config.MetricURL.String()
+	body := prepareRequestBody([]models.Metrics{metric})
+	if err := m.deliver(ctx, url, body); err != nil {
+		m.retryLater(body, err)
 		return err
 	}
-	defer resp.Body.Close()
+	m.replaySpool(ctx, url)
 	return nil
 }
 
@@ -162,9 +157,9 @@ func (m *agent) collectMetricsByWorker(stopCh chan struct{}, jobs chan models.Me
 	for {
 		select {
 		case <-ticker.C:
-			m.collectRuntimeMetrics()
+			m.collect()
 			// fill in the jobs channel
-			for _, metric := range m.metrics {
+			for _, metric := range m.takeMetrics() {
 				jobs <- metric
 			}
 		case <-stopCh:
@@ -176,39 +171,112 @@ func (m *agent) collectMetricsByWorker(stopCh chan struct{}, jobs chan models.Me
 
 func (m *agent) sendMetrics(stop chan struct{}) {
 	url := m.config.MetricURL.String()
+	ctx, cancel := stopContext(stop)
+	defer cancel()
 	ticker := time.NewTicker(m.config.ReportInterval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			utils.WithRetry(func() error {
-				return m.performRequest(url)
-			}, 0, m.config.MaxRetries)
+			m.report(ctx, url)
 		case <-stop:
 			return
 		}
 	}
 }
 
+// report sends spooled batches first to keep order, current snapshot
+// is spooled if server is still unreachable
+func (m *agent) report(ctx context.Context, url string) {
+	m.config.Logger.Info("Sending metrics to server...")
+	body := prepareRequestBody(m.takeMetrics())
+	if err := m.replaySpool(ctx, url); err != nil {
+		m.spoolBatch(body)
+		return
+	}
+	err := m.config.Retry.Do(ctx, func(ctx context.Context) error {
+		return m.deliver(ctx, url, body)
+	})
+	if err != nil {
+		m.retryLater(body, err)
+	}
+}
+
 func hashBodyByKey(key *string, body []byte) string {
-	hmac := hmac.New(sha256.New, []byte(*key))
-	hmac.Write(body)
-	return hex.EncodeToString(hmac.Sum(nil))
+	return utils.HashBody([]byte(*key), body)
 }
 
-func (m *agent) performRequest(url string) (err error) {
-	m.config.Logger.Info("Sending metrics to server...")
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	body := prepareRequestBody(m.metrics)
+// stopContext returns context cancelled once stopCh is closed
+func stopContext(stopCh chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// outboundIP returns address of interface routing to server,
+// dialing UDP sends no packets
+func outboundIP(u *url.URL) (net.IP, error) {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	conn, err := net.Dial("udp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+func (m *agent) performRequest(ctx context.Context, url string, body []byte) (err error) {
 	m.config.Logger.Info("Sending metrics", zap.ByteString("body", body))
-	r, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	payload, compressed, err := m.compressBody(body)
+	if err != nil {
+		m.config.Logger.Error("Error compressing request body", zap.Error(err))
+		return err
+	}
+	// payload is compressed first as ciphertext does not compress
+	if m.config.PublicKey != nil {
+		payload, err = encryption.Encrypt(m.config.PublicKey, payload)
+		if err != nil {
+			m.config.Logger.Error("Error encrypting request body", zap.Error(err))
+			return err
+		}
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(payload))
 	if err != nil {
 		m.config.Logger.Error("Error creating request", zap.Error(err))
 		return newRetriableError(err)
 	}
 	r.Header.Set("Content-Type", contentType)
 	r.Header.Set("Accept-Encoding", "gzip")
+	if compressed {
+		r.Header.Set("Content-Encoding", "gzip")
+	}
+	if m.config.PublicKey != nil {
+		r.Header.Set(encryption.Header, encryption.Scheme)
+	}
+	if ip, err := outboundIP(r.URL); err != nil {
+		m.config.Logger.Warn("Failed to resolve outbound address", zap.Error(err))
+	} else {
+		r.Header.Set(access.RealIPHeader, ip.String())
+	}
+	if m.config.APIKey != "" {
+		r.Header.Set(access.APIKeyHeader, m.config.APIKey)
+	}
+	if m.config.Tenant != "" {
+		r.Header.Set(tenant.Header, m.config.Tenant)
+	}
+	// hash is calculated over uncompressed body
 	if m.config.Hashing.Key != nil && *m.config.Hashing.Key != "" {
 		hValue := hashBodyByKey(m.config.Hashing.Key, body)
 		r.Header.Set(m.config.Hashing.HeaderName, hValue)
@@ -218,92 +286,102 @@ func (m *agent) performRequest(url string) (err error) {
 		m.config.Logger.Error("Error sending metrics", zap.Error(err))
 		return newRetriableError(err)
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		m.config.Logger.Error("Non-OK HTTP status", zap.Int("status", resp.StatusCode))
-		return newRetriableError(fmt.Errorf("non-OK HTTP status: %s", resp.Status))
+		m.config.Logger.Error("Non-OK HTTP status",
+			zap.Int("status", resp.StatusCode),
+			zap.ByteString("body", readErrorBody(resp)),
+		)
+		err := fmt.Errorf("non-OK HTTP status: %s", resp.Status)
+		// rejected request is going to be rejected again
+		if resp.StatusCode < http.StatusInternalServerError && resp.StatusCode != http.StatusTooManyRequests {
+			return err
+		}
+		return newRetriableError(err)
+	}
+	if m.config.Hashing.Key != nil && *m.config.Hashing.Key != "" {
+		return m.verifyResponse(resp)
 	}
-	resp.Body.Close()
 	return nil
 }
 
+// verifyResponse checks that response body is signed by shared key
+func (m *agent) verifyResponse(resp *http.Response) error {
+	reader := resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return newRetriableError(err)
+	}
+	signature := resp.Header.Get(m.config.Hashing.HeaderName)
+	if !utils.IsHashValid([]byte(signature), body, []byte(*m.config.Hashing.Key)) {
+		m.config.Logger.Error("Invalid response signature", zap.String("signature", signature))
+		return errors.New("invalid response signature")
+	}
+	return nil
+}
+
+// readErrorBody returns beginning of error description sent by server
+func readErrorBody(resp *http.Response) []byte {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return bytes.TrimSpace(body)
+}
+
+// compressBody gzips body exceeding configured threshold
+func (m *agent) compressBody(body []byte) ([]byte, bool, error) {
+	if m.config.CompressMinSize <= 0 || len(body) < m.config.CompressMinSize {
+		return body, false, nil
+	}
+	var buf bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := gz.Write(body); err != nil {
+		return nil, false, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
 func (m *agent) collectMetrics(stop chan struct{}) {
 	ticker := time.NewTicker(m.config.PollInterval)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
-			m.collectRuntimeMetrics()
+			m.collect()
 		case <-stop:
 			return
 		}
 	}
 }
 
-func (m *agent) collectRuntimeMetrics() {
-	var memStats runtime.MemStats
-	runtime.ReadMemStats(&memStats)
+// takeMetrics returns snapshot of collected metrics and resets counters,
+// so every counter delta is sent or spooled exactly once
+func (m *agent) takeMetrics() []models.Metrics {
 	m.mu.Lock()
-	m.config.Logger.Info("Collecting metrics...")
-	for key, getter := range getters {
-		m.metrics[key] = getGaugeMetricModel(key, memStats, getter)
-	}
-	randVal := float64(rand.Intn(1000))
-	m.metrics["RandomValue"] = models.Metrics{
-		ID:    "RandomValue",
-		MType: models.Gauge,
-		Value: &randVal,
-	}
-	vm, _ := mem.VirtualMemory()
-	memTotal := float64(vm.Total)
-	memFree := float64(vm.Free)
-	percentages, _ := cpu.Percent(0, true)
-	for i, percent := range percentages {
-		CPUid := fmt.Sprintf("CPUutilization%d", i+1)
-		m.metrics[CPUid] = models.Metrics{
-			ID:    CPUid,
-			MType: models.Gauge,
-			Value: &percent,
-		}
-	}
-	m.metrics["TotalMemory"] = models.Metrics{
-		ID:    "TotalMemory",
-		MType: models.Gauge,
-		Value: &memTotal,
-	}
-	m.metrics["FreeMemory"] = models.Metrics{
-		ID:    "FreeMemory",
-		MType: models.Gauge,
-		Value: &memFree,
-	}
-	pCount, ok := m.metrics["PollCount"]
-	if ok {
-		*pCount.Delta += 1
-		m.metrics["PollCount"] = pCount
-	} else {
-		var initVal int64 = 1
-		m.metrics["PollCount"] = models.Metrics{
-			ID:    "PollCount",
-			MType: models.Counter,
-			Delta: &initVal,
+	defer m.mu.Unlock()
+	metrics := make([]models.Metrics, 0, len(m.metrics))
+	for key, metric := range m.metrics {
+		metrics = append(metrics, metric)
+		if metric.MType == models.Counter {
+			delete(m.metrics, key)
 		}
 	}
-	m.mu.Unlock()
+	return metrics
 }
 
-func getGaugeMetricModel(name string, stats runtime.MemStats, g getter) models.Metrics {
-	value := g(stats)
-	return models.Metrics{
-		ID:    name,
-		MType: models.Gauge,
-		Value: &value,
-	}
-}
-
-func prepareRequestBody(m map[string]models.Metrics) []byte {
-	var metrics []models.Metrics
-	for _, metric := range m {
-		metrics = append(metrics, metric)
-	}
+func prepareRequestBody(metrics []models.Metrics) []byte {
 	jsonData, _ := json.Marshal(metrics)
 	return jsonData
 }
@@ -1,16 +1,29 @@
 package agent
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/config/env"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/encryption"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/middleware"
 	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/spool"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"go.uber.org/zap"
@@ -189,7 +202,6 @@ func Test_performRequestTestSuite(t *testing.T) {
 }
 
 func (s *performRequestTestSuite) SetupTest() {
-	retries := 3
 	s.agent = &agent{
 		config: &Config{
 			Logger: zap.NewNop(),
@@ -198,7 +210,7 @@ func (s *performRequestTestSuite) SetupTest() {
 			},
 			PollInterval:   50 * time.Millisecond,
 			ReportInterval: 100 * time.Millisecond,
-			MaxRetries:     retries,
+			Retry:          utils.RetryPolicy{MaxAttempts: 3},
 		},
 		metrics: map[string]models.Metrics{},
 	}
@@ -231,7 +243,7 @@ func (s *performRequestTestSuite) Test_performRequest() {
 	for _, test := range tests {
 		s.Run(test.name, func() {
 			ts := httptest.NewServer(http.HandlerFunc(test.handler))
-			err := s.agent.performRequest(ts.URL)
+			err := s.agent.performRequest(context.Background(), ts.URL, []byte("[]"))
 			if test.wantErr {
 				s.Require().Error(err)
 			} else {
@@ -241,3 +253,279 @@ func (s *performRequestTestSuite) Test_performRequest() {
 		})
 	}
 }
+
+func Test_agent_reportWithSpool(t *testing.T) {
+	var available atomic.Bool
+	var received atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []models.Metrics
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		for _, m := range batch {
+			if m.ID == "PollCount" {
+				received.Add(*m.Delta)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	s, err := spool.Open(spool.Config{Dir: t.TempDir()})
+	require.NoError(t, err)
+	defer s.Close()
+	m := NewAgent(&Config{
+		Logger: zap.NewNop(),
+		Client: &http.Client{},
+		Spool:  s,
+	})
+
+	m.collect()
+	m.report(context.Background(), ts.URL)
+	m.collect()
+	m.collect()
+	m.report(context.Background(), ts.URL)
+	require.Equal(t, 2, s.Stats().Batches, "undelivered batches should be spooled")
+
+	available.Store(true)
+	m.collect()
+	m.report(context.Background(), ts.URL)
+	require.Equal(t, 0, s.Stats().Batches)
+	require.Equal(t, int64(4), received.Load(), "every poll should be counted exactly once")
+}
+
+func Test_agent_reportDropsRejected(t *testing.T) {
+	var status atomic.Int64
+	status.Store(http.StatusServiceUnavailable)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer ts.Close()
+	s, err := spool.Open(spool.Config{Dir: t.TempDir()})
+	require.NoError(t, err)
+	defer s.Close()
+	m := NewAgent(&Config{
+		Logger: zap.NewNop(),
+		Client: &http.Client{},
+		Spool:  s,
+	})
+
+	m.collect()
+	m.report(context.Background(), ts.URL)
+	require.Equal(t, 1, s.Stats().Batches)
+
+	// neither spooled nor current batch is kept once server rejects them
+	status.Store(http.StatusBadRequest)
+	m.collect()
+	m.report(context.Background(), ts.URL)
+	require.Equal(t, spool.Stats{Dropped: 1}, s.Stats())
+}
+
+func Test_agent_performRequestCompressed(t *testing.T) {
+	key := "secret"
+	tests := []struct {
+		name           string
+		body           string
+		wantCompressed bool
+	}{
+		{
+			name: "should send small body as is",
+			body: `[]`,
+		},
+		{
+			name:           "should gzip body above threshold",
+			body:           `[{"id":"` + strings.Repeat("a", 64) + `","type":"gauge","value":1}]`,
+			wantCompressed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(middleware.DecompressHandler(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				require.Equal(t, tt.body, string(body))
+				require.True(t, utils.IsHashValid([]byte(r.Header.Get("HashSHA256")), body, []byte(key)),
+					"hash should be calculated over uncompressed body")
+				w.Header().Set("HashSHA256", utils.HashBody([]byte(key), []byte("{}")))
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("{}"))
+			})))
+			defer ts.Close()
+			var compressed atomic.Bool
+			client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				compressed.Store(r.Header.Get("Content-Encoding") == "gzip")
+				return http.DefaultTransport.RoundTrip(r)
+			})}
+			m := NewAgent(&Config{
+				Logger:          zap.NewNop(),
+				Client:          client,
+				CompressMinSize: 32,
+			})
+			m.config.Hashing.Key = &key
+			m.config.Hashing.HeaderName = "HashSHA256"
+			require.NoError(t, m.performRequest(context.Background(), ts.URL, []byte(tt.body)))
+			require.Equal(t, tt.wantCompressed, compressed.Load())
+		})
+	}
+}
+
+func Test_agent_performRequestEncrypted(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	body := `[{"id":"` + strings.Repeat("a", 64) + `","type":"gauge","value":1}]`
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, body, string(got))
+		w.WriteHeader(http.StatusOK)
+	})
+	ts := httptest.NewServer(middleware.DecryptHandler(privateKey, 1024)(middleware.DecompressHandler(1024)(handler)))
+	defer ts.Close()
+	var payload []byte
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		require.Equal(t, encryption.Scheme, r.Header.Get(encryption.Header))
+		payload, _ = io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(payload))
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	m := NewAgent(&Config{
+		Logger:          zap.NewNop(),
+		Client:          client,
+		CompressMinSize: 32,
+		PublicKey:       &privateKey.PublicKey,
+	})
+	require.NoError(t, m.performRequest(context.Background(), ts.URL, []byte(body)))
+	require.NotContains(t, string(payload), "gauge", "body should not travel in clear")
+}
+
+func Test_agent_performRequestIdentifies(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "127.0.0.1", r.Header.Get(access.RealIPHeader),
+			"address should be taken from interface routing to server")
+		require.Equal(t, "agent-key", r.Header.Get(access.APIKeyHeader))
+		require.Equal(t, "team-a", r.Header.Get(tenant.Header))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	m := NewAgent(&Config{
+		Logger: zap.NewNop(),
+		Client: ts.Client(),
+		APIKey: "agent-key",
+		Tenant: "team-a",
+	})
+	require.NoError(t, m.performRequest(context.Background(), ts.URL, []byte(`[]`)))
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// instantClock skips waiting between attempts
+type instantClock struct{}
+
+func (instantClock) After(time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+func Test_agent_reportRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantCalls int64
+	}{
+		{
+			name:      "should retry unavailable server",
+			statuses:  []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK},
+			wantCalls: 3,
+		},
+		{
+			name:      "should not retry rejected request",
+			statuses:  []int{http.StatusBadRequest, http.StatusOK},
+			wantCalls: 1,
+		},
+		{
+			name:      "should stop after max attempts",
+			statuses:  []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK},
+			wantCalls: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int64
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[calls.Add(1)-1])
+			}))
+			defer ts.Close()
+			m := NewAgent(&Config{
+				Logger: zap.NewNop(),
+				Client: &http.Client{},
+				Retry: utils.RetryPolicy{
+					MaxAttempts: 3,
+					BaseDelay:   time.Hour,
+					Clock:       instantClock{},
+				},
+			})
+			m.report(context.Background(), ts.URL)
+			require.Equal(t, tt.wantCalls, calls.Load())
+		})
+	}
+}
+
+func Test_agent_performRequestVerifiesResponse(t *testing.T) {
+	key := "secret"
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	})
+	tests := []struct {
+		name    string
+		handler http.Handler
+		wantErr bool
+	}{
+		{
+			name:    "should accept signed gzipped response",
+			handler: middleware.CompressHandler(middleware.HashHandler([]byte(key))(ok)),
+		},
+		{
+			name:    "should fail when server rejects signature",
+			handler: middleware.HashHandler([]byte("other"))(ok),
+			wantErr: true,
+		},
+		{
+			name: "should reject tampered response",
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("HashSHA256", utils.HashBody([]byte(key), []byte("{}")))
+				w.Write([]byte(`{"tampered":true}`))
+			}),
+			wantErr: true,
+		},
+		{
+			name:    "should reject unsigned response",
+			handler: ok,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(tt.handler)
+			defer ts.Close()
+			m := NewAgent(&Config{
+				Logger: zap.NewNop(),
+				Client: &http.Client{},
+			})
+			m.config.Hashing.Key = &key
+			m.config.Hashing.HeaderName = "HashSHA256"
+			err := m.performRequest(context.Background(), ts.URL, []byte("[]"))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
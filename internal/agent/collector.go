@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"go.uber.org/zap"
+)
+
+// Collector gathers a group of metrics, every collector is polled
+// on its own interval and its errors are reported separately
+type Collector interface {
+	Name() string
+	Collect() ([]models.Metrics, error)
+}
+
+type CollectorConfig struct {
+	Disabled bool
+	// zero interval means agent poll interval
+	Interval time.Duration
+}
+
+type registeredCollector struct {
+	collector Collector
+	interval  time.Duration
+	lastRun   time.Time
+}
+
+func (rc *registeredCollector) isDue(now time.Time) bool {
+	return rc.lastRun.IsZero() || now.Sub(rc.lastRun) >= rc.interval
+}
+
+// RegisterCollector adds collector polled every interval unless overridden
+// or disabled by agent config, must be called before Launch
+func (m *agent) RegisterCollector(c Collector, interval time.Duration) error {
+	for _, rc := range m.collectors {
+		if rc.collector.Name() == c.Name() {
+			return fmt.Errorf("collector already registered: %s", c.Name())
+		}
+	}
+	if cfg, ok := m.config.Collectors[c.Name()]; ok {
+		if cfg.Disabled {
+			m.config.Logger.Info("Collector disabled", zap.String("collector", c.Name()))
+			return nil
+		}
+		if cfg.Interval > 0 {
+			interval = cfg.Interval
+		}
+	}
+	m.collectors = append(m.collectors, &registeredCollector{
+		collector: c,
+		interval:  interval,
+	})
+	return nil
+}
+
+func (m *agent) registerDefaultCollectors() {
+	collectors := []Collector{
+		&runtimeCollector{},
+		&randomCollector{},
+		&memoryCollector{},
+		&cpuCollector{},
+		&hostCollector{},
+	}
+	collectors = append(collectors, platformCollectors(m.config.ProcRoot, m.config.Logger)...)
+	if m.config.Spool != nil {
+		collectors = append(collectors, &spoolCollector{spool: m.config.Spool})
+	}
+	for _, c := range collectors {
+		if err := m.RegisterCollector(c, 0); err != nil {
+			m.config.Logger.Error("Failed to register collector", zap.Error(err))
+		}
+	}
+}
+
+// collect polls due collectors and increments PollCount
+func (m *agent) collect() {
+	now := time.Now()
+	var collected []models.Metrics
+	for _, rc := range m.collectors {
+		if !rc.isDue(now) {
+			continue
+		}
+		rc.lastRun = now
+		metrics, err := rc.collector.Collect()
+		if err != nil {
+			m.config.Logger.Error("Collector failed",
+				zap.String("collector", rc.collector.Name()),
+				zap.Error(err),
+			)
+			collected = append(collected, newCounter(
+				"collector_errors",
+				models.Labels{"collector": rc.collector.Name()},
+				1,
+			))
+		}
+		collected = append(collected, metrics...)
+	}
+	collected = append(collected, newCounter("PollCount", nil, 1))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.config.Logger.Info("Collecting metrics...")
+	for _, metric := range collected {
+		m.store(metric)
+	}
+}
+
+// store replaces gauge value and accumulates counter delta, caller must hold lock
+func (m *agent) store(metric models.Metrics) {
+	key := metric.SeriesKey()
+	existing, ok := m.metrics[key]
+	if ok && metric.MType == models.Counter && existing.MType == models.Counter {
+		delta := *existing.Delta + *metric.Delta
+		metric.Delta = &delta
+	}
+	m.metrics[key] = metric
+}
+
+func newGauge(name string, labels models.Labels, value float64) models.Metrics {
+	return models.Metrics{
+		ID:     name,
+		MType:  models.Gauge,
+		Value:  &value,
+		Labels: labels,
+	}
+}
+
+func newCounter(name string, labels models.Labels, delta int64) models.Metrics {
+	return models.Metrics{
+		ID:     name,
+		MType:  models.Counter,
+		Delta:  &delta,
+		Labels: labels,
+	}
+}
+
+// ParseCollectorConfigs builds collectors config from comma separated list
+// of disabled collectors and list of intervals like "cpu=5,runtime=2" (seconds)
+func ParseCollectorConfigs(disabled string, intervals string) (map[string]CollectorConfig, error) {
+	configs := make(map[string]CollectorConfig)
+	for _, name := range strings.Split(disabled, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			configs[name] = CollectorConfig{Disabled: true}
+		}
+	}
+	for _, entry := range strings.Split(intervals, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		name, rawSeconds, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid collector interval: %s", entry)
+		}
+		seconds, err := strconv.ParseUint(rawSeconds, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid collector interval: %s: %v", entry, err)
+		}
+		cfg := configs[name]
+		cfg.Interval = time.Duration(seconds) * time.Second
+		configs[name] = cfg
+	}
+	return configs, nil
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type collectorStub struct {
+	name  string
+	calls int
+	err   error
+}
+
+func (c *collectorStub) Name() string {
+	return c.name
+}
+
+func (c *collectorStub) Collect() ([]models.Metrics, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return []models.Metrics{newGauge(c.name+"_value", nil, float64(c.calls))}, nil
+}
+
+func newTestAgent(collectors map[string]CollectorConfig) *agent {
+	return NewAgent(&Config{
+		Logger:     zap.NewNop(),
+		Collectors: collectors,
+	})
+}
+
+func Test_agent_RegisterCollector(t *testing.T) {
+	m := newTestAgent(map[string]CollectorConfig{
+		"disabled": {Disabled: true},
+		"slow":     {Interval: time.Minute},
+	})
+	require.NoError(t, m.RegisterCollector(&collectorStub{name: "fast"}, 0))
+	require.NoError(t, m.RegisterCollector(&collectorStub{name: "slow"}, time.Second))
+	require.NoError(t, m.RegisterCollector(&collectorStub{name: "disabled"}, 0))
+	require.Error(t, m.RegisterCollector(&collectorStub{name: "fast"}, 0), "duplicate name")
+
+	require.Len(t, m.collectors, 2)
+	require.Equal(t, time.Minute, m.collectors[1].interval, "config should override interval")
+}
+
+func Test_agent_collect(t *testing.T) {
+	m := newTestAgent(nil)
+	fast := &collectorStub{name: "fast"}
+	slow := &collectorStub{name: "slow"}
+	broken := &collectorStub{name: "broken", err: errors.New("no data")}
+	require.NoError(t, m.RegisterCollector(fast, 0))
+	require.NoError(t, m.RegisterCollector(slow, time.Hour))
+	require.NoError(t, m.RegisterCollector(broken, 0))
+
+	m.collect()
+	m.collect()
+
+	require.Equal(t, 2, fast.calls)
+	require.Equal(t, 1, slow.calls, "collector should be polled on its own interval")
+	require.Equal(t, 2.0, *m.metrics["fast_value"].Value)
+	require.Equal(t, int64(2), *m.metrics["PollCount"].Delta)
+	errorsKey := models.SeriesKey("collector_errors", models.Labels{"collector": "broken"})
+	require.Equal(t, int64(2), *m.metrics[errorsKey].Delta)
+}
+
+func TestParseCollectorConfigs(t *testing.T) {
+	configs, err := ParseCollectorConfigs("random, host", "cpu=5,runtime=2")
+	require.NoError(t, err)
+	require.Equal(t, map[string]CollectorConfig{
+		"random":  {Disabled: true},
+		"host":    {Disabled: true},
+		"cpu":     {Interval: 5 * time.Second},
+		"runtime": {Interval: 2 * time.Second},
+	}, configs)
+
+	_, err = ParseCollectorConfigs("", "cpu:5")
+	require.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/mem"
+)
+
+type getter func(runtime.MemStats) float64
+
+var getters = map[string]getter{
+	"Alloc":         func(stats runtime.MemStats) float64 { return float64(stats.Alloc) },
+	"BuckHashSys":   func(stats runtime.MemStats) float64 { return float64(stats.BuckHashSys) },
+	"Frees":         func(stats runtime.MemStats) float64 { return float64(stats.Frees) },
+	"GCCPUFraction": func(stats runtime.MemStats) float64 { return float64(stats.GCCPUFraction) },
+	"GCSys":         func(stats runtime.MemStats) float64 { return float64(stats.GCSys) },
+	"HeapAlloc":     func(stats runtime.MemStats) float64 { return float64(stats.HeapAlloc) },
+	"HeapIdle":      func(stats runtime.MemStats) float64 { return float64(stats.HeapIdle) },
+	"HeapInuse":     func(stats runtime.MemStats) float64 { return float64(stats.HeapInuse) },
+	"HeapObjects":   func(stats runtime.MemStats) float64 { return float64(stats.HeapObjects) },
+	"HeapReleased":  func(stats runtime.MemStats) float64 { return float64(stats.HeapReleased) },
+	"HeapSys":       func(stats runtime.MemStats) float64 { return float64(stats.HeapSys) },
+	"LastGC":        func(stats runtime.MemStats) float64 { return float64(stats.LastGC) },
+	"Lookups":       func(stats runtime.MemStats) float64 { return float64(stats.Lookups) },
+	"MCacheInuse":   func(stats runtime.MemStats) float64 { return float64(stats.MCacheInuse) },
+	"MCacheSys":     func(stats runtime.MemStats) float64 { return float64(stats.MCacheSys) },
+	"Mallocs":       func(stats runtime.MemStats) float64 { return float64(stats.Mallocs) },
+	"NextGC":        func(stats runtime.MemStats) float64 { return float64(stats.NextGC) },
+	"NumForcedGC":   func(stats runtime.MemStats) float64 { return float64(stats.NumForcedGC) },
+	"OtherSys":      func(stats runtime.MemStats) float64 { return float64(stats.OtherSys) },
+	"PauseTotalNs":  func(stats runtime.MemStats) float64 { return float64(stats.PauseTotalNs) },
+	"MSpanInuse":    func(stats runtime.MemStats) float64 { return float64(stats.MSpanInuse) },
+	"MSpanSys":      func(stats runtime.MemStats) float64 { return float64(stats.MSpanSys) },
+	"StackInuse":    func(stats runtime.MemStats) float64 { return float64(stats.StackInuse) },
+	"StackSys":      func(stats runtime.MemStats) float64 { return float64(stats.StackSys) },
+	"Sys":           func(stats runtime.MemStats) float64 { return float64(stats.Sys) },
+	"TotalAlloc":    func(stats runtime.MemStats) float64 { return float64(stats.TotalAlloc) },
+	"NumGC":         func(stats runtime.MemStats) float64 { return float64(stats.NumGC) },
+}
+
+// runtimeCollector reports Go runtime memory statistics
+type runtimeCollector struct{}
+
+func (c *runtimeCollector) Name() string {
+	return "runtime"
+}
+
+func (c *runtimeCollector) Collect() ([]models.Metrics, error) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	metrics := make([]models.Metrics, 0, len(getters))
+	for key, getter := range getters {
+		metrics = append(metrics, newGauge(key, nil, getter(memStats)))
+	}
+	return metrics, nil
+}
+
+type randomCollector struct{}
+
+func (c *randomCollector) Name() string {
+	return "random"
+}
+
+func (c *randomCollector) Collect() ([]models.Metrics, error) {
+	return []models.Metrics{
+		newGauge("RandomValue", nil, float64(rand.Intn(1000))),
+	}, nil
+}
+
+// memoryCollector reports system virtual memory
+type memoryCollector struct{}
+
+func (c *memoryCollector) Name() string {
+	return "memory"
+}
+
+func (c *memoryCollector) Collect() ([]models.Metrics, error) {
+	vm, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, err
+	}
+	return []models.Metrics{
+		newGauge("TotalMemory", nil, float64(vm.Total)),
+		newGauge("FreeMemory", nil, float64(vm.Free)),
+	}, nil
+}
+
+// cpuCollector reports utilization of every CPU
+type cpuCollector struct{}
+
+func (c *cpuCollector) Name() string {
+	return "cpu"
+}
+
+func (c *cpuCollector) Collect() ([]models.Metrics, error) {
+	percentages, err := cpu.Percent(0, true)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]models.Metrics, 0, len(percentages))
+	for i, percent := range percentages {
+		metrics = append(metrics, newGauge(
+			"cpu_utilization",
+			models.Labels{"cpu": strconv.Itoa(i + 1)},
+			percent,
+		))
+	}
+	return metrics, nil
+}
+
+// hostCollector reports info metric identifying the host agent is running on
+type hostCollector struct{}
+
+func (c *hostCollector) Name() string {
+	return "host"
+}
+
+func (c *hostCollector) Collect() ([]models.Metrics, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	return []models.Metrics{
+		newGauge("agent_info", models.Labels{"host": hostname}, 1),
+	}, nil
+}
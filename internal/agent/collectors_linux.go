@@ -0,0 +1,380 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultProcRoot = "/proc"
+	sectorSize      = 512
+)
+
+// file systems reported by disk usage collector
+var physicalFSTypes = map[string]struct{}{
+	"ext2": {}, "ext3": {}, "ext4": {}, "xfs": {}, "btrfs": {},
+	"zfs": {}, "vfat": {}, "exfat": {}, "ntfs": {}, "f2fs": {}, "overlay": {},
+}
+
+func platformCollectors(procRoot string, logger *zap.Logger) []Collector {
+	if procRoot == "" {
+		procRoot = defaultProcRoot
+	}
+	return []Collector{
+		&loadCollector{procRoot: procRoot},
+		&swapCollector{procRoot: procRoot},
+		&fdCollector{procRoot: procRoot},
+		&uptimeCollector{procRoot: procRoot},
+		&networkCollector{procRoot: procRoot, counters: newCounterTracker()},
+		&diskIOCollector{procRoot: procRoot, counters: newCounterTracker()},
+		&diskUsageCollector{procRoot: procRoot, statfs: statfs, logger: logger},
+	}
+}
+
+// counterTracker turns monotonically growing kernel counters into deltas,
+// first observation of series only establishes the baseline
+type counterTracker struct {
+	previous map[string]uint64
+}
+
+func newCounterTracker() *counterTracker {
+	return &counterTracker{previous: make(map[string]uint64)}
+}
+
+func (t *counterTracker) delta(m *[]models.Metrics, name string, labels models.Labels, current uint64) {
+	key := models.SeriesKey(name, labels)
+	previous, ok := t.previous[key]
+	t.previous[key] = current
+	if !ok {
+		return
+	}
+	delta := current - previous
+	// counter was reset, e.g. interface was recreated
+	if current < previous {
+		delta = current
+	}
+	*m = append(*m, newCounter(name, labels, int64(delta)))
+}
+
+func readFields(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+func parseFloats(fields []string, count int) ([]float64, error) {
+	if len(fields) < count {
+		return nil, fmt.Errorf("expected %d fields, got %d", count, len(fields))
+	}
+	values := make([]float64, count)
+	for i := 0; i < count; i++ {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// loadCollector reports load averages from /proc/loadavg
+type loadCollector struct {
+	procRoot string
+}
+
+func (c *loadCollector) Name() string {
+	return "load"
+}
+
+func (c *loadCollector) Collect() ([]models.Metrics, error) {
+	fields, err := readFields(filepath.Join(c.procRoot, "loadavg"))
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseFloats(fields, 3)
+	if err != nil {
+		return nil, fmt.Errorf("loadavg: %v", err)
+	}
+	return []models.Metrics{
+		newGauge("load1", nil, values[0]),
+		newGauge("load5", nil, values[1]),
+		newGauge("load15", nil, values[2]),
+	}, nil
+}
+
+// swapCollector reports swap usage from /proc/meminfo
+type swapCollector struct {
+	procRoot string
+}
+
+func (c *swapCollector) Name() string {
+	return "swap"
+}
+
+func (c *swapCollector) Collect() ([]models.Metrics, error) {
+	f, err := os.Open(filepath.Join(c.procRoot, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info := make(map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || (name != "SwapTotal" && name != "SwapFree") {
+			continue
+		}
+		fields := strings.Fields(rest)
+		values, err := parseFloats(fields, 1)
+		if err != nil {
+			return nil, fmt.Errorf("meminfo %s: %v", name, err)
+		}
+		// values are reported in kB
+		info[name] = values[0] * 1024
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	total, hasTotal := info["SwapTotal"]
+	free, hasFree := info["SwapFree"]
+	if !hasTotal || !hasFree {
+		return nil, fmt.Errorf("meminfo: swap statistics not found")
+	}
+	return []models.Metrics{
+		newGauge("swap_total_bytes", nil, total),
+		newGauge("swap_free_bytes", nil, free),
+		newGauge("swap_used_bytes", nil, total-free),
+	}, nil
+}
+
+// fdCollector reports system wide file descriptors from /proc/sys/fs/file-nr
+type fdCollector struct {
+	procRoot string
+}
+
+func (c *fdCollector) Name() string {
+	return "fd"
+}
+
+func (c *fdCollector) Collect() ([]models.Metrics, error) {
+	fields, err := readFields(filepath.Join(c.procRoot, "sys", "fs", "file-nr"))
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseFloats(fields, 3)
+	if err != nil {
+		return nil, fmt.Errorf("file-nr: %v", err)
+	}
+	return []models.Metrics{
+		newGauge("open_fds", nil, values[0]-values[1]),
+		newGauge("max_fds", nil, values[2]),
+	}, nil
+}
+
+// uptimeCollector reports system uptime from /proc/uptime
+type uptimeCollector struct {
+	procRoot string
+}
+
+func (c *uptimeCollector) Name() string {
+	return "uptime"
+}
+
+func (c *uptimeCollector) Collect() ([]models.Metrics, error) {
+	fields, err := readFields(filepath.Join(c.procRoot, "uptime"))
+	if err != nil {
+		return nil, err
+	}
+	values, err := parseFloats(fields, 1)
+	if err != nil {
+		return nil, fmt.Errorf("uptime: %v", err)
+	}
+	return []models.Metrics{newGauge("uptime_seconds", nil, values[0])}, nil
+}
+
+// networkCollector reports interface counters from /proc/net/dev
+type networkCollector struct {
+	procRoot string
+	counters *counterTracker
+}
+
+func (c *networkCollector) Name() string {
+	return "network"
+}
+
+func (c *networkCollector) Collect() ([]models.Metrics, error) {
+	f, err := os.Open(filepath.Join(c.procRoot, "net", "dev"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var metrics []models.Metrics
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		iface, rest, ok := strings.Cut(scanner.Text(), ":")
+		// skip two header lines
+		if !ok || strings.Contains(iface, "|") {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 16 {
+			return nil, fmt.Errorf("net/dev: unexpected format of %s", iface)
+		}
+		labels := models.Labels{"interface": strings.TrimSpace(iface)}
+		for name, idx := range map[string]int{
+			"network_receive_bytes":    0,
+			"network_receive_packets":  1,
+			"network_receive_errors":   2,
+			"network_transmit_bytes":   8,
+			"network_transmit_packets": 9,
+			"network_transmit_errors":  10,
+		} {
+			value, err := strconv.ParseUint(fields[idx], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("net/dev: %v", err)
+			}
+			c.counters.delta(&metrics, name, labels, value)
+		}
+	}
+	return metrics, scanner.Err()
+}
+
+// diskIOCollector reports block device counters from /proc/diskstats
+type diskIOCollector struct {
+	procRoot string
+	counters *counterTracker
+}
+
+func (c *diskIOCollector) Name() string {
+	return "diskio"
+}
+
+func (c *diskIOCollector) Collect() ([]models.Metrics, error) {
+	f, err := os.Open(filepath.Join(c.procRoot, "diskstats"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var metrics []models.Metrics
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 14 {
+			continue
+		}
+		device := fields[2]
+		if strings.HasPrefix(device, "loop") || strings.HasPrefix(device, "ram") {
+			continue
+		}
+		labels := models.Labels{"device": device}
+		for name, field := range map[string]struct {
+			idx        int
+			multiplier uint64
+		}{
+			"disk_reads_completed":  {idx: 3, multiplier: 1},
+			"disk_read_bytes":       {idx: 5, multiplier: sectorSize},
+			"disk_writes_completed": {idx: 7, multiplier: 1},
+			"disk_written_bytes":    {idx: 9, multiplier: sectorSize},
+		} {
+			value, err := strconv.ParseUint(fields[field.idx], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("diskstats: %v", err)
+			}
+			c.counters.delta(&metrics, name, labels, value*field.multiplier)
+		}
+	}
+	return metrics, scanner.Err()
+}
+
+// diskUsageCollector reports usage of every mounted physical file system,
+// mounts which can not be inspected, e.g. stale network ones, are skipped
+type diskUsageCollector struct {
+	procRoot string
+	// free is available to unprivileged users, used excludes all free
+	// blocks including ones reserved for root
+	statfs func(path string) (total, free, used uint64, err error)
+	logger *zap.Logger
+}
+
+func (c *diskUsageCollector) Name() string {
+	return "disk"
+}
+
+func (c *diskUsageCollector) Collect() ([]models.Metrics, error) {
+	f, err := os.Open(filepath.Join(c.procRoot, "mounts"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var metrics []models.Metrics
+	seen := make(map[string]struct{})
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		device, mountpoint, fsType := unescapeMountField(fields[0]), unescapeMountField(fields[1]), fields[2]
+		if _, ok := physicalFSTypes[fsType]; !ok {
+			continue
+		}
+		if _, ok := seen[mountpoint]; ok {
+			continue
+		}
+		seen[mountpoint] = struct{}{}
+		total, free, used, err := c.statfs(mountpoint)
+		if err != nil {
+			c.logger.Warn("Skipping mount point",
+				zap.String("mountpoint", mountpoint),
+				zap.Error(err),
+			)
+			continue
+		}
+		labels := models.Labels{"mountpoint": mountpoint, "device": device}
+		metrics = append(metrics,
+			newGauge("disk_total_bytes", labels, float64(total)),
+			newGauge("disk_free_bytes", labels, float64(free)),
+			newGauge("disk_used_bytes", labels, float64(used)),
+		)
+	}
+	return metrics, scanner.Err()
+}
+
+// unescapeMountField decodes octal escapes of space, tab, newline
+// and backslash which kernel uses in mount table fields
+func unescapeMountField(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		if field[i] == '\\' && i+3 < len(field) {
+			if code, err := strconv.ParseUint(field[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(code))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(field[i])
+	}
+	return b.String()
+}
+
+func statfs(path string) (uint64, uint64, uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, 0, err
+	}
+	size := uint64(stat.Bsize)
+	return stat.Blocks * size, stat.Bavail * size, (stat.Blocks - stat.Bfree) * size, nil
+}
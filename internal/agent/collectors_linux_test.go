@@ -0,0 +1,192 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+const fixturesProcRoot = "testdata/proc"
+
+func collectedBySeries(t *testing.T, c Collector) map[string]models.Metrics {
+	t.Helper()
+	metrics, err := c.Collect()
+	require.NoError(t, err)
+	result := make(map[string]models.Metrics, len(metrics))
+	for _, m := range metrics {
+		result[m.SeriesKey()] = m
+	}
+	return result
+}
+
+func Test_procGaugeCollectors(t *testing.T) {
+	tests := []struct {
+		name      string
+		collector Collector
+		want      map[string]float64
+	}{
+		{
+			name:      "should read load averages",
+			collector: &loadCollector{procRoot: fixturesProcRoot},
+			want:      map[string]float64{"load1": 0.52, "load5": 0.58, "load15": 0.59},
+		},
+		{
+			name:      "should read swap usage in bytes",
+			collector: &swapCollector{procRoot: fixturesProcRoot},
+			want: map[string]float64{
+				"swap_total_bytes": 2097148 * 1024,
+				"swap_free_bytes":  1048576 * 1024,
+				"swap_used_bytes":  1048572 * 1024,
+			},
+		},
+		{
+			name:      "should read file descriptors",
+			collector: &fdCollector{procRoot: fixturesProcRoot},
+			want:      map[string]float64{"open_fds": 1536, "max_fds": 9223372036854775807},
+		},
+		{
+			name:      "should read uptime",
+			collector: &uptimeCollector{procRoot: fixturesProcRoot},
+			want:      map[string]float64{"uptime_seconds": 3600.5},
+		},
+		{
+			name: "should report physical file systems once",
+			collector: &diskUsageCollector{
+				procRoot: fixturesProcRoot,
+				// 100 bytes are reserved for root
+				statfs: func(path string) (uint64, uint64, uint64, error) {
+					return 1000, 400, 500, nil
+				},
+			},
+			want: map[string]float64{
+				`disk_total_bytes{device="/dev/sda1",mountpoint="/"}`:     1000,
+				`disk_free_bytes{device="/dev/sda1",mountpoint="/"}`:      400,
+				`disk_used_bytes{device="/dev/sda1",mountpoint="/"}`:      500,
+				`disk_total_bytes{device="/dev/sda2",mountpoint="/data"}`: 1000,
+				`disk_free_bytes{device="/dev/sda2",mountpoint="/data"}`:  400,
+				`disk_used_bytes{device="/dev/sda2",mountpoint="/data"}`:  500,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := collectedBySeries(t, tt.collector)
+			require.Len(t, metrics, len(tt.want))
+			for key, value := range tt.want {
+				require.Contains(t, metrics, key)
+				require.Equal(t, models.Gauge, metrics[key].MType)
+				require.Equal(t, value, *metrics[key].Value, key)
+			}
+		})
+	}
+}
+
+func Test_diskUsageCollector_mountTable(t *testing.T) {
+	root := t.TempDir()
+	mounts := "/dev/sda1 / ext4 rw 0 0\n" +
+		"/dev/sdb1 /mnt/backup\\040disk ext4 rw 0 0\n" +
+		"/dev/sdc1 /mnt/stale xfs rw 0 0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(root, "mounts"), []byte(mounts), 0644))
+	core, logs := observer.New(zap.WarnLevel)
+	var inspected []string
+	c := &diskUsageCollector{
+		procRoot: root,
+		statfs: func(path string) (uint64, uint64, uint64, error) {
+			inspected = append(inspected, path)
+			if path == "/mnt/stale" {
+				return 0, 0, 0, errors.New("stale file handle")
+			}
+			return 1000, 400, 600, nil
+		},
+		logger: zap.New(core),
+	}
+	metrics := collectedBySeries(t, c)
+	require.Equal(t, []string{"/", "/mnt/backup disk", "/mnt/stale"}, inspected)
+	require.Len(t, metrics, 6, "mount failing statfs should be skipped")
+	require.Contains(t, metrics, `disk_total_bytes{device="/dev/sdb1",mountpoint="/mnt/backup disk"}`)
+	require.Len(t, logs.FilterMessage("Skipping mount point").All(), 1)
+}
+
+func Test_unescapeMountField(t *testing.T) {
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{field: "/mnt/data", want: "/mnt/data"},
+		{field: `/mnt/my\040disk`, want: "/mnt/my disk"},
+		{field: `/mnt/tab\011and\012newline`, want: "/mnt/tab\tand\nnewline"},
+		{field: `/mnt/back\134slash`, want: `/mnt/back\slash`},
+		{field: `/mnt/partial\04`, want: `/mnt/partial\04`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			require.Equal(t, tt.want, unescapeMountField(tt.field))
+		})
+	}
+}
+
+func Test_procCounterCollectors(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "net"), 0755))
+	copyFixture := func(name string) {
+		data, err := os.ReadFile(filepath.Join(fixturesProcRoot, name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(root, name), data, 0644))
+	}
+	copyFixture("net/dev")
+	copyFixture("diskstats")
+	network := &networkCollector{procRoot: root, counters: newCounterTracker()}
+	diskIO := &diskIOCollector{procRoot: root, counters: newCounterTracker()}
+
+	require.Empty(t, collectedBySeries(t, network), "first poll should only establish baseline")
+	require.Empty(t, collectedBySeries(t, diskIO), "first poll should only establish baseline")
+
+	netDev := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:    1500      15    0    0    0     0          0         0     1500      15    0    0    0     0       0          0
+  eth0:     100       5    1    0    0     0          0         0     3500      35    3    0    0     0       0          0
+`
+	require.NoError(t, os.WriteFile(filepath.Join(root, "net", "dev"), []byte(netDev), 0644))
+	diskstats := "   8       0 sda 110 0 2016 50 220 0 4040 90 0 120 140 0 0 0 0 0 0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(root, "diskstats"), []byte(diskstats), 0644))
+
+	netMetrics := collectedBySeries(t, network)
+	require.Len(t, netMetrics, 12)
+	for key, delta := range map[string]int64{
+		`network_receive_bytes{interface="lo"}`:      500,
+		`network_receive_bytes{interface="eth0"}`:    100,
+		`network_receive_errors{interface="eth0"}`:   0,
+		`network_transmit_errors{interface="eth0"}`:  1,
+		`network_transmit_packets{interface="eth0"}`: 5,
+	} {
+		require.Equal(t, models.Counter, netMetrics[key].MType)
+		require.Equal(t, delta, *netMetrics[key].Delta, key)
+	}
+
+	diskMetrics := collectedBySeries(t, diskIO)
+	require.Equal(t, map[string]int64{
+		`disk_reads_completed{device="sda"}`:  10,
+		`disk_read_bytes{device="sda"}`:       16 * sectorSize,
+		`disk_writes_completed{device="sda"}`: 20,
+		`disk_written_bytes{device="sda"}`:    40 * sectorSize,
+	}, func() map[string]int64 {
+		deltas := make(map[string]int64)
+		for key, m := range diskMetrics {
+			deltas[key] = *m.Delta
+		}
+		return deltas
+	}())
+}
+
+func Test_statfs(t *testing.T) {
+	total, free, used, err := statfs(t.TempDir())
+	require.NoError(t, err)
+	require.NotZero(t, total)
+	require.LessOrEqual(t, free+used, total, "reserved blocks are neither free nor used")
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package agent
+
+import "go.uber.org/zap"
+
+// platformCollectors reads procfs which is available on Linux only
+func platformCollectors(procRoot string, logger *zap.Logger) []Collector {
+	return nil
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	pb "github.com/funkymotions/go-ya-practicum-metrics/internal/proto"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// deliver sends batch over gRPC when agent is configured to, over HTTP otherwise
+func (m *agent) deliver(ctx context.Context, url string, body []byte) error {
+	if m.config.GRPCClient != nil {
+		return m.performGRPCRequest(ctx, body)
+	}
+	return m.performRequest(ctx, url, body)
+}
+
+// performGRPCRequest sends JSON encoded batch, kept in this form for
+// spooling, as gRPC call carrying the same headers in metadata
+func (m *agent) performGRPCRequest(ctx context.Context, body []byte) error {
+	m.config.Logger.Info("Sending metrics over gRPC", zap.ByteString("body", body))
+	var metrics []models.Metrics
+	if err := json.Unmarshal(body, &metrics); err != nil {
+		m.config.Logger.Error("Error decoding batch", zap.Error(err))
+		return err
+	}
+	req := &pb.UpdateMetricsRequest{Metrics: pb.FromModels(metrics)}
+	md := metadata.MD{}
+	if ip, err := outboundIP(&url.URL{Host: m.config.GRPCAddress}); err != nil {
+		m.config.Logger.Warn("Failed to resolve outbound address", zap.Error(err))
+	} else {
+		md.Set(access.RealIPHeader, ip.String())
+	}
+	if m.config.APIKey != "" {
+		md.Set(access.APIKeyHeader, m.config.APIKey)
+	}
+	if m.config.Tenant != "" {
+		md.Set(tenant.Header, m.config.Tenant)
+	}
+	if m.config.Hashing.Key != nil && *m.config.Hashing.Key != "" {
+		signature, err := pb.Sign([]byte(*m.config.Hashing.Key), req)
+		if err != nil {
+			return err
+		}
+		md.Set(m.config.Hashing.HeaderName, signature)
+	}
+	// calls are bounded by timeout of HTTP client as requests are
+	if m.config.Client != nil && m.config.Client.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.config.Client.Timeout)
+		defer cancel()
+	}
+	var opts []grpc.CallOption
+	if m.config.CompressMinSize > 0 && proto.Size(req) >= m.config.CompressMinSize {
+		opts = append(opts, grpc.UseCompressor(gzip.Name))
+	}
+	_, err := m.config.GRPCClient.UpdateMetrics(metadata.NewOutgoingContext(ctx, md), req, opts...)
+	if err != nil {
+		m.config.Logger.Error("Error sending metrics over gRPC", zap.Error(err))
+		// rejected call is going to be rejected again, so is batch
+		// over size limit of server
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted, codes.Internal:
+			return newRetriableError(err)
+		}
+		return err
+	}
+	return nil
+}
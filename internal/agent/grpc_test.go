@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	pb "github.com/funkymotions/go-ya-practicum-metrics/internal/proto"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// metricsServerStub records the last call and answers with err
+type metricsServerStub struct {
+	pb.UnimplementedMetricsServiceServer
+	md  metadata.MD
+	req *pb.UpdateMetricsRequest
+	err error
+}
+
+func (s *metricsServerStub) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	s.md, _ = metadata.FromIncomingContext(ctx)
+	s.req = req
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &pb.UpdateMetricsResponse{}, nil
+}
+
+func newBufconnClient(t *testing.T, srv pb.MetricsServiceServer) pb.MetricsServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	pb.RegisterMetricsServiceServer(s, srv)
+	go s.Serve(listener)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsServiceClient(conn)
+}
+
+func Test_agent_performGRPCRequest(t *testing.T) {
+	stub := &metricsServerStub{}
+	key := "secret"
+	m := NewAgent(&Config{
+		Logger:      zap.NewNop(),
+		GRPCClient:  newBufconnClient(t, stub),
+		GRPCAddress: "127.0.0.1:3200",
+		APIKey:      "agent-key",
+		Tenant:      "team-a",
+		Hashing: struct {
+			Key        *string
+			HeaderName string
+		}{Key: &key, HeaderName: "hashsha256"},
+	})
+	body := prepareRequestBody([]models.Metrics{
+		{ID: "Alloc", MType: models.Gauge, Value: float64Ptr(5)},
+		{ID: "PollCount", MType: models.Counter, Delta: int64Ptr(2), Labels: models.Labels{"cpu": "0"}},
+	})
+	require.NoError(t, m.deliver(context.Background(), "http://unused/updates/", body))
+	metrics, err := pb.ToModels(stub.req.GetMetrics())
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	require.Equal(t, 5.0, *metrics[0].Value)
+	require.Equal(t, int64(2), *metrics[1].Delta)
+	require.Equal(t, models.Labels{"cpu": "0"}, metrics[1].Labels)
+	require.Equal(t, []string{"127.0.0.1"}, stub.md.Get(access.RealIPHeader),
+		"address should be taken from interface routing to server")
+	require.Equal(t, []string{"agent-key"}, stub.md.Get(access.APIKeyHeader))
+	require.Equal(t, []string{"team-a"}, stub.md.Get(tenant.Header))
+	signature, err := pb.Sign([]byte(key), stub.req)
+	require.NoError(t, err)
+	require.Equal(t, []string{signature}, stub.md.Get("HashSHA256"))
+}
+
+func Test_agent_performGRPCRequestRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantRetriable bool
+	}{
+		{
+			name:          "should retry unavailable server",
+			err:           status.Error(codes.Unavailable, "down"),
+			wantRetriable: true,
+		},
+		{
+			name:          "should not retry rejected call",
+			err:           status.Error(codes.PermissionDenied, "access denied"),
+			wantRetriable: false,
+		},
+		{
+			name:          "should not retry batch over size limit",
+			err:           status.Error(codes.ResourceExhausted, "batch exceeds 64 bytes"),
+			wantRetriable: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewAgent(&Config{
+				Logger:     zap.NewNop(),
+				GRPCClient: newBufconnClient(t, &metricsServerStub{err: tt.err}),
+			})
+			err := m.performGRPCRequest(context.Background(), []byte(`[]`))
+			require.Error(t, err)
+			var retriable utils.RetriableError
+			require.Equal(t, tt.wantRetriable, errors.As(err, &retriable))
+			require.Equal(t, status.Code(tt.err), status.Code(err))
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/spool"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
+	"go.uber.org/zap"
+)
+
+// retryLater spools batch failed to be delivered, batch rejected by
+// server is dropped as resending it does not help
+func (m *agent) retryLater(body []byte, err error) {
+	if !utils.IsRetriableError(err) {
+		m.config.Logger.Warn("Batch is rejected by server, dropping it", zap.Error(err))
+		return
+	}
+	m.spoolBatch(body)
+}
+
+// spoolBatch keeps undelivered batch until server is reachable again
+func (m *agent) spoolBatch(body []byte) {
+	if m.config.Spool == nil {
+		m.config.Logger.Warn("Batch is lost, spool is disabled")
+		return
+	}
+	if err := m.config.Spool.Append(body); err != nil {
+		m.config.Logger.Error("Failed to spool batch", zap.Error(err))
+	}
+}
+
+// replaySpool delivers spooled batches in order, stops on first failure
+// which may pass on retry
+func (m *agent) replaySpool(ctx context.Context, url string) error {
+	if m.config.Spool == nil {
+		return nil
+	}
+	delivered, err := m.config.Spool.Replay(func(batch []byte) error {
+		err := m.config.Retry.Do(ctx, func(ctx context.Context) error {
+			return m.deliver(ctx, url, batch)
+		})
+		if err != nil && !utils.IsRetriableError(err) {
+			return fmt.Errorf("%w: %w", spool.ErrRejected, err)
+		}
+		return err
+	})
+	if delivered > 0 {
+		m.config.Logger.Info("Spooled batches delivered", zap.Int("batches", delivered))
+	}
+	return err
+}
+
+// spoolCollector reports spool depth
+type spoolCollector struct {
+	spool   *spool.Spool
+	dropped int
+}
+
+func (c *spoolCollector) Name() string {
+	return "spool"
+}
+
+func (c *spoolCollector) Collect() ([]models.Metrics, error) {
+	stats := c.spool.Stats()
+	dropped := stats.Dropped - c.dropped
+	c.dropped = stats.Dropped
+	return []models.Metrics{
+		newGauge("spool_batches", nil, float64(stats.Batches)),
+		newGauge("spool_bytes", nil, float64(stats.Bytes)),
+		newCounter("spool_dropped_batches", nil, int64(dropped)),
+	}, nil
+}
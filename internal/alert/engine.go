@@ -0,0 +1,286 @@
+package alert
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"go.uber.org/zap"
+)
+
+type State string
+
+const (
+	StateInactive State = "inactive"
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+type Alert struct {
+	Rule        string    `json:"rule"`
+	Expr        string    `json:"expr"`
+	Metric      string    `json:"metric"`
+	Description string    `json:"description,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	State       State     `json:"state"`
+	Value       float64   `json:"value"`
+	ActiveAt    time.Time `json:"activeAt"`
+	FiredAt     time.Time `json:"firedAt"`
+	ResolvedAt  time.Time `json:"resolvedAt"`
+}
+
+type metricReader interface {
+	GetMetric(ctx context.Context, name string, metricType string, labels models.Labels) (*models.Metrics, bool)
+}
+
+// Notifier receives firing and resolved alerts after each evaluation
+type Notifier interface {
+	Notify(ctx context.Context, alerts []Alert) error
+}
+
+// evaluation interval used when configured one is not positive
+const defaultInterval = 15 * time.Second
+
+type sample struct {
+	value float64
+	at    time.Time
+}
+
+type Engine struct {
+	rules     []*Rule
+	repo      metricReader
+	interval  time.Duration
+	logger    *zap.Logger
+	mu        sync.RWMutex
+	alerts    map[string]*Alert
+	samples   map[string]sample
+	notifiers []Notifier
+	now       func() time.Time
+}
+
+func NewEngine(
+	rules []*Rule,
+	repo metricReader,
+	interval time.Duration,
+	logger *zap.Logger,
+) *Engine {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	return &Engine{
+		rules:    rules,
+		repo:     repo,
+		interval: interval,
+		logger:   logger,
+		alerts:   make(map[string]*Alert),
+		samples:  make(map[string]sample),
+		now:      time.Now,
+	}
+}
+
+// Run evaluates rules periodically until stopCh is closed, notifiers are
+// called in background, so slow receiver does not delay evaluation
+func (e *Engine) Run(stopCh chan struct{}, doneCh chan struct{}) {
+	defer close(doneCh)
+	// cancelled on stop, so retried notifications do not delay shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	notifyCh := make(chan []Alert, 1)
+	notifyDoneCh := make(chan struct{})
+	go e.notifyLoop(ctx, notifyCh, notifyDoneCh)
+	defer func() {
+		cancel()
+		close(notifyCh)
+		<-notifyDoneCh
+	}()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			e.Evaluate(ctx)
+			if len(e.notifiers) == 0 {
+				continue
+			}
+			// alerts not sent yet are replaced by the latest ones, resolved
+			// alerts stay in both until they are reported
+			select {
+			case <-notifyCh:
+			default:
+			}
+			notifyCh <- e.Alerts(StateFiring, StateResolved)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (e *Engine) AddNotifier(n Notifier) {
+	e.notifiers = append(e.notifiers, n)
+}
+
+func (e *Engine) notifyLoop(ctx context.Context, notifyCh chan []Alert, doneCh chan struct{}) {
+	defer close(doneCh)
+	for alerts := range notifyCh {
+		if e.notify(ctx, alerts) {
+			e.forgetResolved(alerts)
+		}
+	}
+}
+
+// notify passes alerts to every notifier, returns true if all of them succeeded
+func (e *Engine) notify(ctx context.Context, alerts []Alert) bool {
+	ok := true
+	for _, n := range e.notifiers {
+		if err := n.Notify(ctx, alerts); err != nil {
+			e.logger.Error("Failed to notify about alerts", zap.Error(err))
+			ok = false
+		}
+	}
+	return ok
+}
+
+// forgetResolved drops reported resolved alerts unless they became active again
+func (e *Engine) forgetResolved(alerts []Alert) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, a := range alerts {
+		current, exists := e.alerts[a.Rule]
+		if a.State == StateResolved && exists &&
+			current.State == StateResolved && current.ResolvedAt.Equal(a.ResolvedAt) {
+			delete(e.alerts, a.Rule)
+		}
+	}
+}
+
+// reading is current value of rule metric, ok is false when there is no data
+type reading struct {
+	value float64
+	ok    bool
+}
+
+// Evaluate reads metrics of all rules first and locks alerts only to
+// update them, so readers of alerts do not wait for storage
+func (e *Engine) Evaluate(ctx context.Context) {
+	now := e.now()
+	readings := make([]reading, len(e.rules))
+	for i, r := range e.rules {
+		readings[i].value, readings[i].ok = e.read(ctx, r)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, r := range e.rules {
+		value, ok := readings[i].value, readings[i].ok
+		if ok && r.cond.fn == fnRate {
+			value, ok = e.rate(r, value, now)
+		}
+		a, exists := e.alerts[r.Name]
+		if !ok || !r.cond.holds(value) {
+			if !exists {
+				continue
+			}
+			switch a.State {
+			case StatePending:
+				delete(e.alerts, r.Name)
+			case StateFiring:
+				a.State = StateResolved
+				a.ResolvedAt = now
+				e.logger.Info("Alert resolved", zap.String("rule", r.Name))
+			case StateResolved:
+				// without notifiers resolved alert is listed for one interval,
+				// otherwise it is dropped once reported
+				if len(e.notifiers) == 0 {
+					delete(e.alerts, r.Name)
+				}
+			}
+			continue
+		}
+		if !exists || a.State == StateResolved {
+			a = &Alert{
+				Rule:        r.Name,
+				Expr:        r.Expr,
+				Metric:      r.Metric(),
+				Description: r.Description,
+				Tenant:      r.Tenant,
+				State:       StatePending,
+				ActiveAt:    now,
+			}
+			e.alerts[r.Name] = a
+		}
+		a.Value = value
+		if a.State == StatePending && now.Sub(a.ActiveAt) >= r.cond.duration {
+			a.State = StateFiring
+			a.FiredAt = now
+			e.logger.Warn("Alert firing",
+				zap.String("rule", r.Name),
+				zap.Float64("value", value),
+			)
+		}
+	}
+}
+
+// read resolves current value of rule metric, ok is false when there is no data
+func (e *Engine) read(ctx context.Context, r *Rule) (float64, bool) {
+	ctx = tenant.WithID(ctx, r.Tenant)
+	m, found := e.repo.GetMetric(ctx, r.cond.metric, models.Gauge, r.cond.labels)
+	if !found || m.Value == nil {
+		m, found = e.repo.GetMetric(ctx, r.cond.metric, models.Counter, r.cond.labels)
+		if !found || m.Delta == nil {
+			return 0, false
+		}
+	}
+	if m.Value != nil {
+		return *m.Value, true
+	}
+	return float64(*m.Delta), true
+}
+
+// rate converts current value of rule metric into per second increase
+// since the previous evaluation, caller must hold write lock
+func (e *Engine) rate(r *Rule, current float64, now time.Time) (float64, bool) {
+	prev, hasPrev := e.samples[r.Name]
+	e.samples[r.Name] = sample{value: current, at: now}
+	elapsed := now.Sub(prev.at).Seconds()
+	if !hasPrev || elapsed <= 0 {
+		return 0, false
+	}
+	increase := current - prev.value
+	// counter reset
+	if increase < 0 {
+		increase = current
+	}
+	return increase / elapsed, true
+}
+
+// Alerts returns all known alerts with given states, or all alerts if no state passed
+func (e *Engine) Alerts(states ...State) []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	result := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		if len(states) > 0 && !containsState(states, a.State) {
+			continue
+		}
+		result = append(result, *a)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Rule < result[j].Rule
+	})
+	return result
+}
+
+func (e *Engine) FiringAlerts() []Alert {
+	return e.Alerts(StateFiring)
+}
+
+func containsState(states []State, s State) bool {
+	for _, state := range states {
+		if state == s {
+			return true
+		}
+	}
+	return false
+}
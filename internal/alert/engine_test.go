@@ -0,0 +1,300 @@
+package alert
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type metricReaderStub struct {
+	mu      sync.Mutex
+	metrics map[string]models.Metrics
+}
+
+func (s *metricReaderStub) GetMetric(ctx context.Context, name string, metricType string, labels models.Labels) (*models.Metrics, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := metricType + ":" + models.SeriesKey(name, labels)
+	if id := tenant.FromContext(ctx); id != "" {
+		key = id + "/" + key
+	}
+	m, ok := s.metrics[key]
+	return &m, ok
+}
+
+func (s *metricReaderStub) setGauge(name string, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metrics[models.Gauge+":"+name] = models.Metrics{ID: name, MType: models.Gauge, Value: &value}
+}
+
+func (s *metricReaderStub) setCounter(name string, delta int64) {
+	s.metrics[models.Counter+":"+name] = models.Metrics{ID: name, MType: models.Counter, Delta: &delta}
+}
+
+func TestNewRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    condition
+		wantErr bool
+	}{
+		{
+			name: "should parse threshold with unit and duration",
+			expr: "HeapAlloc > 500MB for 2m",
+			want: condition{metric: "HeapAlloc", op: ">", threshold: 500 << 20, duration: 2 * time.Minute},
+		},
+		{
+			name: "should parse rate function",
+			expr: "rate(PollCount) == 0 for 1m",
+			want: condition{fn: fnRate, metric: "PollCount", op: "==", threshold: 0, duration: time.Minute},
+		},
+		{
+			name: "should parse expression without duration",
+			expr: "CPUutilization1>=90.5",
+			want: condition{metric: "CPUutilization1", op: ">=", threshold: 90.5},
+		},
+		{
+			name: "should parse label selector",
+			expr: `rate(cpu_utilization{cpu="1", host="web"}) > 0.5`,
+			want: condition{
+				fn:        fnRate,
+				metric:    "cpu_utilization",
+				labels:    models.Labels{"cpu": "1", "host": "web"},
+				op:        ">",
+				threshold: 0.5,
+			},
+		},
+		{
+			name:    "should fail on invalid label selector",
+			expr:    `cpu_utilization{cpu=1} > 90`,
+			wantErr: true,
+		},
+		{
+			name:    "should fail on unknown unit",
+			expr:    "HeapAlloc > 5PB",
+			wantErr: true,
+		},
+		{
+			name:    "should fail on invalid expression",
+			expr:    "HeapAlloc is big",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRule("test", tt.expr)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, *r.cond)
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	content := `[
+		{"name": "HighHeap", "expr": "HeapAlloc > 500MB for 2m"},
+		{"name": "AgentStalled", "expr": "rate(PollCount) == 0 for 1m"}
+	]`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	rules, err := LoadRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	require.Equal(t, "PollCount", rules[1].Metric())
+
+	duplicated := `[{"name": "A", "expr": "X > 1"}, {"name": "A", "expr": "Y > 1"}]`
+	require.NoError(t, os.WriteFile(path, []byte(duplicated), 0644))
+	_, err = LoadRules(path)
+	require.Error(t, err)
+
+	invalidTenant := `[{"name": "A", "expr": "X > 1", "tenant": "team a"}]`
+	require.NoError(t, os.WriteFile(path, []byte(invalidTenant), 0644))
+	_, err = LoadRules(path)
+	require.Error(t, err)
+}
+
+func TestEngine_EvaluateTenantRule(t *testing.T) {
+	repo := &metricReaderStub{metrics: make(map[string]models.Metrics)}
+	value := 600.0
+	repo.metrics["team-a/"+models.Gauge+":HeapAlloc"] = models.Metrics{ID: "HeapAlloc", MType: models.Gauge, Value: &value}
+	rule, _ := NewRule("TeamHighHeap", "HeapAlloc > 500")
+	rule.Tenant = "team-a"
+	defaultRule, _ := NewRule("HighHeap", "HeapAlloc > 500")
+	e := NewEngine([]*Rule{rule, defaultRule}, repo, time.Second, zap.NewNop())
+	e.Evaluate(context.Background())
+	alerts := e.FiringAlerts()
+	require.Len(t, alerts, 1, "series of tenant should not be visible to default tenant")
+	require.Equal(t, "TeamHighHeap", alerts[0].Rule)
+	require.Equal(t, "team-a", alerts[0].Tenant)
+}
+
+type blockingReader struct {
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *blockingReader) GetMetric(context.Context, string, string, models.Labels) (*models.Metrics, bool) {
+	r.once.Do(func() { close(r.started) })
+	<-r.release
+	return nil, false
+}
+
+func TestEngine_EvaluateDoesNotBlockReaders(t *testing.T) {
+	repo := &blockingReader{started: make(chan struct{}), release: make(chan struct{})}
+	rule, _ := NewRule("HighHeap", "HeapAlloc > 500")
+	e := NewEngine([]*Rule{rule}, repo, time.Second, zap.NewNop())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Evaluate(context.Background())
+	}()
+	<-repo.started
+	alertsCh := make(chan []Alert)
+	go func() { alertsCh <- e.Alerts() }()
+	select {
+	case alerts := <-alertsCh:
+		require.Empty(t, alerts)
+	case <-time.After(5 * time.Second):
+		t.Fatal("alerts should be readable while storage is queried")
+	}
+	close(repo.release)
+	<-done
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	repo := &metricReaderStub{metrics: make(map[string]models.Metrics)}
+	heapRule, _ := NewRule("HighHeap", "HeapAlloc > 500MB for 2m")
+	stalledRule, _ := NewRule("AgentStalled", "rate(PollCount) == 0 for 1m")
+	e := NewEngine([]*Rule{heapRule, stalledRule}, repo, time.Second, zap.NewNop())
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		e.Evaluate(context.Background())
+	}
+	state := func(rule string) State {
+		for _, a := range e.Alerts() {
+			if a.Rule == rule {
+				return a.State
+			}
+		}
+		return StateInactive
+	}
+
+	repo.setGauge("HeapAlloc", 600<<20)
+	repo.setCounter("PollCount", 10)
+	advance(0)
+	require.Equal(t, StatePending, state("HighHeap"))
+	require.Equal(t, StateInactive, state("AgentStalled"), "rate needs two samples")
+
+	advance(time.Minute)
+	require.Equal(t, StatePending, state("HighHeap"))
+	require.Equal(t, StatePending, state("AgentStalled"))
+
+	advance(time.Minute)
+	require.Equal(t, StateFiring, state("HighHeap"))
+	require.Equal(t, StateFiring, state("AgentStalled"))
+	require.Len(t, e.FiringAlerts(), 2)
+
+	repo.setGauge("HeapAlloc", 100)
+	repo.setCounter("PollCount", 20)
+	advance(time.Minute)
+	require.Equal(t, StateResolved, state("HighHeap"))
+	require.Equal(t, StateResolved, state("AgentStalled"))
+	require.Empty(t, e.FiringAlerts())
+
+	repo.setGauge("HeapAlloc", 600<<20)
+	advance(time.Second)
+	require.Equal(t, StatePending, state("HighHeap"))
+	repo.setGauge("HeapAlloc", 100)
+	advance(time.Second)
+	require.Equal(t, StateInactive, state("HighHeap"), "pending alert should be dropped")
+
+	repo.setGauge("HeapAlloc", 600<<20)
+	advance(0)
+	advance(2 * time.Minute)
+	require.Equal(t, StateFiring, state("HighHeap"))
+	repo.setGauge("HeapAlloc", 100)
+	advance(time.Second)
+	require.Equal(t, StateResolved, state("HighHeap"))
+	advance(time.Second)
+	require.Equal(t, StateInactive, state("HighHeap"), "listed resolved alert should be dropped")
+}
+
+// notifierStub blocks the first call until release is closed
+type notifierStub struct {
+	mu      sync.Mutex
+	release chan struct{}
+	calls   [][]Alert
+}
+
+func (n *notifierStub) Notify(_ context.Context, alerts []Alert) error {
+	n.mu.Lock()
+	first := len(n.calls) == 0
+	n.calls = append(n.calls, alerts)
+	n.mu.Unlock()
+	if first {
+		<-n.release
+	}
+	return nil
+}
+
+func (n *notifierStub) notified(state State) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, alerts := range n.calls {
+		for _, a := range alerts {
+			if a.State == state {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestEngine_Run(t *testing.T) {
+	repo := &metricReaderStub{metrics: make(map[string]models.Metrics)}
+	rule, _ := NewRule("HighHeap", "HeapAlloc > 500")
+	e := NewEngine([]*Rule{rule}, repo, 5*time.Millisecond, zap.NewNop())
+	n := &notifierStub{release: make(chan struct{})}
+	e.AddNotifier(n)
+	repo.setGauge("HeapAlloc", 600)
+	stopCh, doneCh := make(chan struct{}), make(chan struct{})
+	go e.Run(stopCh, doneCh)
+	defer func() {
+		close(stopCh)
+		<-doneCh
+	}()
+
+	require.Eventually(t, func() bool {
+		return n.notified(StateFiring)
+	}, 5*time.Second, time.Millisecond)
+	// rules are evaluated while notifier is blocked
+	repo.setGauge("HeapAlloc", 100)
+	require.Eventually(t, func() bool {
+		return len(e.Alerts(StateResolved)) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	close(n.release)
+	require.Eventually(t, func() bool {
+		return n.notified(StateResolved) && len(e.Alerts()) == 0
+	}, 5*time.Second, time.Millisecond, "reported resolved alert should be dropped")
+}
+
+func TestNewEngine_DefaultInterval(t *testing.T) {
+	e := NewEngine(nil, &metricReaderStub{}, 0, zap.NewNop())
+	require.Equal(t, defaultInterval, e.interval)
+}
@@ -0,0 +1,173 @@
+package alert
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+)
+
+const (
+	fnRate = "rate"
+)
+
+// expression grammar: [rate(]<metric>[{label="value",...}][)] <op> <number>[unit] [for <duration>]
+var exprRe = regexp.MustCompile(
+	`^\s*(?:(rate)\(\s*(\w+)\s*(\{[^}]*\})?\s*\)|(\w+)\s*(\{[^}]*\})?)\s*(>=|<=|==|!=|>|<)\s*(-?[0-9]*\.?[0-9]+)\s*([a-zA-Z%]*)\s*(?:for\s+(\S+))?\s*$`,
+)
+
+var labelMatcherRe = regexp.MustCompile(`^\s*([a-zA-Z_]\w*)\s*=\s*"([^"]*)"\s*$`)
+
+var units = map[string]float64{
+	"":   1,
+	"B":  1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+	"TB": 1 << 40,
+	"%":  1,
+}
+
+type Rule struct {
+	Name        string `json:"name"`
+	Expr        string `json:"expr"`
+	Description string `json:"description,omitempty"`
+	// tenant owning evaluated series, empty for default tenant
+	Tenant string `json:"tenant,omitempty"`
+	cond   *condition
+}
+
+type condition struct {
+	fn        string
+	metric    string
+	labels    models.Labels
+	op        string
+	threshold float64
+	duration  time.Duration
+}
+
+func (c *condition) holds(value float64) bool {
+	switch c.op {
+	case ">":
+		return value > c.threshold
+	case ">=":
+		return value >= c.threshold
+	case "<":
+		return value < c.threshold
+	case "<=":
+		return value <= c.threshold
+	case "==":
+		return value == c.threshold
+	case "!=":
+		return value != c.threshold
+	}
+	return false
+}
+
+// NewRule validates and compiles rule expression
+func NewRule(name, expr string) (*Rule, error) {
+	r := &Rule{Name: name, Expr: expr}
+	if err := r.compile(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule name is empty: %q", r.Expr)
+	}
+	if r.Tenant != "" && !tenant.Valid(r.Tenant) {
+		return fmt.Errorf("rule %s: invalid tenant: %q", r.Name, r.Tenant)
+	}
+	parts := exprRe.FindStringSubmatch(r.Expr)
+	if parts == nil {
+		return fmt.Errorf("rule %s: invalid expression: %q", r.Name, r.Expr)
+	}
+	c := &condition{
+		fn:     parts[1],
+		metric: parts[2],
+		op:     parts[6],
+	}
+	selector := parts[3]
+	if c.fn == "" {
+		c.metric = parts[4]
+		selector = parts[5]
+	}
+	labels, err := parseSelector(selector)
+	if err != nil {
+		return fmt.Errorf("rule %s: %v", r.Name, err)
+	}
+	c.labels = labels
+	threshold, err := strconv.ParseFloat(parts[7], 64)
+	if err != nil {
+		return fmt.Errorf("rule %s: invalid threshold: %v", r.Name, err)
+	}
+	multiplier, ok := units[strings.ToUpper(parts[8])]
+	if !ok {
+		return fmt.Errorf("rule %s: unknown unit: %s", r.Name, parts[8])
+	}
+	c.threshold = threshold * multiplier
+	if parts[9] != "" {
+		d, err := time.ParseDuration(parts[9])
+		if err != nil {
+			return fmt.Errorf("rule %s: invalid duration: %v", r.Name, err)
+		}
+		c.duration = d
+	}
+	r.cond = c
+	return nil
+}
+
+// parseSelector parses exact label matchers like {cpu="1",host="web"}
+func parseSelector(selector string) (models.Labels, error) {
+	selector = strings.TrimSpace(strings.Trim(selector, "{}"))
+	if selector == "" {
+		return nil, nil
+	}
+	labels := make(models.Labels)
+	for _, matcher := range strings.Split(selector, ",") {
+		parts := labelMatcherRe.FindStringSubmatch(matcher)
+		if parts == nil {
+			return nil, fmt.Errorf("invalid label matcher: %q", matcher)
+		}
+		labels[parts[1]] = parts[2]
+	}
+	return labels, nil
+}
+
+// Metric returns the series evaluated by rule
+func (r *Rule) Metric() string {
+	return models.SeriesKey(r.cond.metric, r.cond.labels)
+}
+
+// LoadRules reads JSON array of rules from file
+func LoadRules(path string) ([]*Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rules []*Rule
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("failed to decode rules file %s: %v", path, err)
+	}
+	names := make(map[string]struct{}, len(rules))
+	for _, r := range rules {
+		if err := r.compile(); err != nil {
+			return nil, err
+		}
+		if _, exists := names[r.Name]; exists {
+			return nil, fmt.Errorf("duplicate rule name: %s", r.Name)
+		}
+		names[r.Name] = struct{}{}
+	}
+	return rules, nil
+}
@@ -3,8 +3,11 @@ package env
 import (
 	"flag"
 	"log"
+	"time"
 
 	"github.com/caarlos0/env/v11"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tlsconfig"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
 )
 
 type Variables struct {
@@ -17,6 +20,54 @@ type Variables struct {
 	DatabaseDSN     *string `env:"DATABASE_DSN"`
 	Key             *string `env:"KEY"`
 	RateLimit       *int    `env:"RATE_LIMIT"`
+	AlertRulesPath  *string `env:"ALERT_RULES_FILE"`
+	AlertInterval   *uint   `env:"ALERT_EVAL_INTERVAL"`
+	AlertWebhooks   *string `env:"ALERT_WEBHOOK_URLS"`
+	AlertRepeat     *uint   `env:"ALERT_REPEAT_INTERVAL"`
+	PromPrefix      *string `env:"PROMETHEUS_PREFIX"`
+	MaxBodySize     *int64  `env:"MAX_BODY_SIZE"`
+	// server deadlines (milliseconds)
+	RequestTimeout *uint `env:"REQUEST_TIMEOUT"`
+	DBReadTimeout  *uint `env:"DB_READ_TIMEOUT"`
+	DBWriteTimeout *uint `env:"DB_WRITE_TIMEOUT"`
+	DBPingTimeout  *uint `env:"DB_PING_TIMEOUT"`
+	// PEM file with public key on agent and private key on server
+	CryptoKey *string `env:"CRYPTO_KEY"`
+	// PEM files of TLS, certificate is the server one on server and the
+	// client one on agent, CA verifies the peer
+	TLSCertFile *string `env:"TLS_CERT_FILE"`
+	TLSKeyFile  *string `env:"TLS_KEY_FILE"`
+	TLSCAFile   *string `env:"TLS_CA_FILE"`
+	// access control, policy file on server and key sent by agent
+	AccessPolicyPath *string `env:"ACCESS_POLICY_FILE"`
+	APIKey           *string `env:"API_KEY"`
+	TenantID         *string `env:"TENANT_ID"`
+	// default series quota of tenant, policy may override it per tenant
+	TenantMaxSeries *uint `env:"TENANT_MAX_SERIES"`
+	// gRPC address served by server and used by agent instead of HTTP
+	GRPCAddress *string `env:"GRPC_ADDRESS"`
+	// comma separated CIDRs of proxies allowed to pass client address to gRPC server
+	GRPCTrustedProxies *string `env:"GRPC_TRUSTED_PROXIES"`
+	// server StatsD listener (flush interval in seconds)
+	StatsDAddress       *string `env:"STATSD_ADDRESS"`
+	StatsDFlushInterval *uint   `env:"STATSD_FLUSH_INTERVAL"`
+	StatsDQueueSize     *int    `env:"STATSD_QUEUE_SIZE"`
+	// retries of deliveries and storage writes (delays in milliseconds)
+	RetryMaxAttempts *uint    `env:"RETRY_MAX_ATTEMPTS"`
+	RetryBaseDelay   *uint    `env:"RETRY_BASE_DELAY"`
+	RetryMaxDelay    *uint    `env:"RETRY_MAX_DELAY"`
+	RetryMultiplier  *float64 `env:"RETRY_MULTIPLIER"`
+	RetryJitter      *bool    `env:"RETRY_JITTER"`
+	// agent collectors
+	DisabledCollectors *string `env:"DISABLED_COLLECTORS"`
+	CollectorIntervals *string `env:"COLLECTOR_INTERVALS"`
+	ProcRoot           *string `env:"HOST_PROC"`
+	// agent spool
+	SpoolDir      *string `env:"SPOOL_DIR"`
+	SpoolMaxBytes *int64  `env:"SPOOL_MAX_BYTES"`
+	SpoolMaxAge   *uint   `env:"SPOOL_MAX_AGE"`
+	// agent request compression
+	CompressMinSize *int `env:"COMPRESS_MIN_SIZE"`
 }
 
 func ParseAgentOptions() *Variables {
@@ -26,16 +77,43 @@ func ParseAgentOptions() *Variables {
 	var pollInterval = new(uint)
 	var key = new(string)
 	var rateLimit = new(int)
+	var disabledCollectors = new(string)
+	var collectorIntervals = new(string)
+	var procRoot = new(string)
+	var spoolDir = new(string)
+	var spoolMaxBytes = new(int64)
+	var spoolMaxAge = new(uint)
+	var compressMinSize = new(int)
+	var cryptoKey = new(string)
+	var apiKey = new(string)
+	var tenantID = new(string)
+	var grpcAddress = new(string)
 	if err := env.Parse(&envVars); err != nil {
 		log.Fatal(err)
 	}
+	retry := registerRetryFlags()
+	tlsFiles := registerTLSFlags(
+		"set path to PEM encoded client certificate presented to server",
+		"set path to CA bundle verifying server certificate, empty means system roots",
+	)
 	flag.Var(endpointFlag, "a", "set endpoint (host:port)")
 	flag.UintVar(reportInterval, "r", 10, "set report interval (seconds)")
 	flag.UintVar(pollInterval, "p", 2, "set poll interval (seconds)")
 	flag.StringVar(key, "k", "", "set key used for hashing")
 	flag.IntVar(rateLimit, "l", 0, "set rate limit (requests per second), 0 means no limit")
+	flag.StringVar(disabledCollectors, "collectors-disabled", "", "set comma separated names of disabled collectors")
+	flag.StringVar(collectorIntervals, "collector-intervals", "", "set per collector poll intervals (seconds), e.g. cpu=5,runtime=2")
+	flag.StringVar(procRoot, "proc-root", "/proc", "set procfs mount point used by system collectors")
+	flag.StringVar(spoolDir, "spool-dir", "", "set directory of on-disk spool for undelivered batches, empty disables spooling")
+	flag.Int64Var(spoolMaxBytes, "spool-max-bytes", 64<<20, "set spool size limit (bytes), oldest batches are dropped first")
+	flag.UintVar(spoolMaxAge, "spool-max-age", 86400, "set max age of spooled batches (seconds), 0 means no limit")
+	flag.IntVar(compressMinSize, "compress-min-size", 1024, "set min request body size (bytes) sent gzipped, 0 disables compression")
+	flag.StringVar(cryptoKey, "crypto-key", "", "set path to PEM encoded server public key, empty disables encryption")
+	flag.StringVar(apiKey, "api-key", "", "set API key sent to server")
+	flag.StringVar(tenantID, "tenant", "", "set tenant of reported metrics, empty means tenant bound to API key or the default one")
+	flag.StringVar(grpcAddress, "grpc-address", "", "set server gRPC endpoint (host:port) used instead of HTTP, empty reports over HTTP")
 	flag.Parse()
-	return &Variables{
+	result := &Variables{
 		Endpoint: func() *string {
 			if envVars.Endpoint != nil {
 				return envVars.Endpoint
@@ -67,7 +145,76 @@ func ParseAgentOptions() *Variables {
 			}
 			return rateLimit
 		}(),
+		DisabledCollectors: func() *string {
+			if envVars.DisabledCollectors != nil {
+				return envVars.DisabledCollectors
+			}
+			return disabledCollectors
+		}(),
+		CollectorIntervals: func() *string {
+			if envVars.CollectorIntervals != nil {
+				return envVars.CollectorIntervals
+			}
+			return collectorIntervals
+		}(),
+		ProcRoot: func() *string {
+			if envVars.ProcRoot != nil {
+				return envVars.ProcRoot
+			}
+			return procRoot
+		}(),
+		SpoolDir: func() *string {
+			if envVars.SpoolDir != nil {
+				return envVars.SpoolDir
+			}
+			return spoolDir
+		}(),
+		SpoolMaxBytes: func() *int64 {
+			if envVars.SpoolMaxBytes != nil {
+				return envVars.SpoolMaxBytes
+			}
+			return spoolMaxBytes
+		}(),
+		SpoolMaxAge: func() *uint {
+			if envVars.SpoolMaxAge != nil {
+				return envVars.SpoolMaxAge
+			}
+			return spoolMaxAge
+		}(),
+		CompressMinSize: func() *int {
+			if envVars.CompressMinSize != nil {
+				return envVars.CompressMinSize
+			}
+			return compressMinSize
+		}(),
+		CryptoKey: func() *string {
+			if envVars.CryptoKey != nil {
+				return envVars.CryptoKey
+			}
+			return cryptoKey
+		}(),
+		APIKey: func() *string {
+			if envVars.APIKey != nil {
+				return envVars.APIKey
+			}
+			return apiKey
+		}(),
+		TenantID: func() *string {
+			if envVars.TenantID != nil {
+				return envVars.TenantID
+			}
+			return tenantID
+		}(),
+		GRPCAddress: func() *string {
+			if envVars.GRPCAddress != nil {
+				return envVars.GRPCAddress
+			}
+			return grpcAddress
+		}(),
 	}
+	retry.resolve(&envVars, result)
+	tlsFiles.resolve(&envVars, result)
+	return result
 }
 
 func ParseServerOptions() *Variables {
@@ -78,17 +225,58 @@ func ParseServerOptions() *Variables {
 	var restore = new(bool)
 	var dsn = new(string)
 	var key = new(string)
+	var alertRulesPath = new(string)
+	var alertInterval = new(uint)
+	var alertWebhooks = new(string)
+	var alertRepeat = new(uint)
+	var promPrefix = new(string)
+	var maxBodySize = new(int64)
+	var requestTimeout = new(uint)
+	var dbReadTimeout = new(uint)
+	var dbWriteTimeout = new(uint)
+	var dbPingTimeout = new(uint)
+	var cryptoKey = new(string)
+	var accessPolicyPath = new(string)
+	var tenantMaxSeries = new(uint)
+	var grpcAddress = new(string)
+	var grpcTrustedProxies = new(string)
+	var statsDAddress = new(string)
+	var statsDFlushInterval = new(uint)
+	var statsDQueueSize = new(int)
 	if err := env.Parse(&envVars); err != nil {
 		log.Fatal(err)
 	}
+	retry := registerRetryFlags()
+	tlsFiles := registerTLSFlags(
+		"set path to PEM encoded server certificate, empty serves plain HTTP",
+		"set path to CA bundle verifying client certificates, empty disables mutual TLS",
+	)
 	flag.UintVar(storeInterval, "i", 300, "set store interval (seconds)")
 	flag.StringVar(fileStoragePath, "f", "tmp/metrics-db.json", "set file storage path")
 	flag.BoolVar(restore, "r", false, "set restore")
 	flag.Var(endpointFlag, "a", "set endpoint (host:port)")
 	flag.StringVar(dsn, "d", "", "set database dsn")
 	flag.StringVar(key, "k", "", "set key used for hashing")
+	flag.StringVar(alertRulesPath, "alert-rules", "", "set alerting rules file path, empty disables alerting")
+	flag.UintVar(alertInterval, "alert-interval", 15, "set alerting rules evaluation interval (seconds)")
+	flag.StringVar(alertWebhooks, "alert-webhooks", "", "set comma separated webhook URLs for alert notifications")
+	flag.UintVar(alertRepeat, "alert-repeat", 300, "set firing alert notification repeat interval (seconds), 0 disables repeating")
+	flag.StringVar(promPrefix, "prom-prefix", "", "set prefix of metric names exposed on /metrics")
+	flag.Int64Var(maxBodySize, "max-body-size", 10<<20, "set max size of decompressed request body (bytes)")
+	flag.UintVar(requestTimeout, "request-timeout", 0, "set request deadline (milliseconds), 0 means no deadline")
+	flag.UintVar(dbReadTimeout, "db-read-timeout", 1000, "set database read timeout (milliseconds)")
+	flag.UintVar(dbWriteTimeout, "db-write-timeout", 10000, "set database write timeout (milliseconds)")
+	flag.UintVar(dbPingTimeout, "db-ping-timeout", 1000, "set database ping timeout (milliseconds)")
+	flag.StringVar(cryptoKey, "crypto-key", "", "set path to PEM encoded private key decrypting agent payloads")
+	flag.StringVar(accessPolicyPath, "access-policy", "", "set access policy file path with trusted subnets and API keys, empty disables access control")
+	flag.UintVar(tenantMaxSeries, "tenant-max-series", 0, "set max number of series per tenant, 0 means no limit")
+	flag.StringVar(grpcAddress, "grpc-address", "", "set gRPC endpoint (host:port), empty disables gRPC")
+	flag.StringVar(grpcTrustedProxies, "grpc-trusted-proxies", "", "set comma separated CIDRs of proxies whose x-real-ip metadata is trusted by gRPC server")
+	flag.StringVar(statsDAddress, "statsd-address", "", "set StatsD UDP endpoint (host:port), empty disables StatsD")
+	flag.UintVar(statsDFlushInterval, "statsd-flush-interval", 10, "set StatsD aggregation interval (seconds)")
+	flag.IntVar(statsDQueueSize, "statsd-queue-size", 1024, "set max number of StatsD packets waiting for parsing, packets over it are dropped")
 	flag.Parse()
-	return &Variables{
+	result := &Variables{
 		Endpoint: func() *string {
 			if envVars.Endpoint != nil {
 				return envVars.Endpoint
@@ -126,5 +314,220 @@ func ParseServerOptions() *Variables {
 			}
 			return key
 		}(),
+		AlertRulesPath: func() *string {
+			if envVars.AlertRulesPath != nil {
+				return envVars.AlertRulesPath
+			}
+			return alertRulesPath
+		}(),
+		AlertInterval: func() *uint {
+			if envVars.AlertInterval != nil {
+				return envVars.AlertInterval
+			}
+			return alertInterval
+		}(),
+		AlertWebhooks: func() *string {
+			if envVars.AlertWebhooks != nil {
+				return envVars.AlertWebhooks
+			}
+			return alertWebhooks
+		}(),
+		AlertRepeat: func() *uint {
+			if envVars.AlertRepeat != nil {
+				return envVars.AlertRepeat
+			}
+			return alertRepeat
+		}(),
+		PromPrefix: func() *string {
+			if envVars.PromPrefix != nil {
+				return envVars.PromPrefix
+			}
+			return promPrefix
+		}(),
+		MaxBodySize: func() *int64 {
+			if envVars.MaxBodySize != nil {
+				return envVars.MaxBodySize
+			}
+			return maxBodySize
+		}(),
+		RequestTimeout: func() *uint {
+			if envVars.RequestTimeout != nil {
+				return envVars.RequestTimeout
+			}
+			return requestTimeout
+		}(),
+		DBReadTimeout: func() *uint {
+			if envVars.DBReadTimeout != nil {
+				return envVars.DBReadTimeout
+			}
+			return dbReadTimeout
+		}(),
+		DBWriteTimeout: func() *uint {
+			if envVars.DBWriteTimeout != nil {
+				return envVars.DBWriteTimeout
+			}
+			return dbWriteTimeout
+		}(),
+		DBPingTimeout: func() *uint {
+			if envVars.DBPingTimeout != nil {
+				return envVars.DBPingTimeout
+			}
+			return dbPingTimeout
+		}(),
+		CryptoKey: func() *string {
+			if envVars.CryptoKey != nil {
+				return envVars.CryptoKey
+			}
+			return cryptoKey
+		}(),
+		AccessPolicyPath: func() *string {
+			if envVars.AccessPolicyPath != nil {
+				return envVars.AccessPolicyPath
+			}
+			return accessPolicyPath
+		}(),
+		TenantMaxSeries: func() *uint {
+			if envVars.TenantMaxSeries != nil {
+				return envVars.TenantMaxSeries
+			}
+			return tenantMaxSeries
+		}(),
+		GRPCAddress: func() *string {
+			if envVars.GRPCAddress != nil {
+				return envVars.GRPCAddress
+			}
+			return grpcAddress
+		}(),
+		GRPCTrustedProxies: func() *string {
+			if envVars.GRPCTrustedProxies != nil {
+				return envVars.GRPCTrustedProxies
+			}
+			return grpcTrustedProxies
+		}(),
+		StatsDAddress: func() *string {
+			if envVars.StatsDAddress != nil {
+				return envVars.StatsDAddress
+			}
+			return statsDAddress
+		}(),
+		StatsDFlushInterval: func() *uint {
+			if envVars.StatsDFlushInterval != nil {
+				return envVars.StatsDFlushInterval
+			}
+			return statsDFlushInterval
+		}(),
+		StatsDQueueSize: func() *int {
+			if envVars.StatsDQueueSize != nil {
+				return envVars.StatsDQueueSize
+			}
+			return statsDQueueSize
+		}(),
+	}
+	retry.resolve(&envVars, result)
+	tlsFiles.resolve(&envVars, result)
+	return result
+}
+
+// retryFlags holds retry policy flags shared by agent and server
+type retryFlags struct {
+	maxAttempts *uint
+	baseDelay   *uint
+	maxDelay    *uint
+	multiplier  *float64
+	jitter      *bool
+}
+
+func registerRetryFlags() *retryFlags {
+	f := &retryFlags{
+		maxAttempts: new(uint),
+		baseDelay:   new(uint),
+		maxDelay:    new(uint),
+		multiplier:  new(float64),
+		jitter:      new(bool),
+	}
+	flag.UintVar(f.maxAttempts, "retry-attempts", 3, "set max attempts of retriable operations, 1 disables retries")
+	flag.UintVar(f.baseDelay, "retry-base-delay", 1000, "set delay before the first retry (milliseconds)")
+	flag.UintVar(f.maxDelay, "retry-max-delay", 5000, "set max delay between retries (milliseconds), 0 means no limit")
+	flag.Float64Var(f.multiplier, "retry-multiplier", 2, "set growth factor of delay between retries")
+	flag.BoolVar(f.jitter, "retry-jitter", true, "set full jitter of delay between retries")
+	return f
+}
+
+// resolve puts flag values not overridden by environment into v
+func (f *retryFlags) resolve(envVars *Variables, v *Variables) {
+	v.RetryMaxAttempts = f.maxAttempts
+	if envVars.RetryMaxAttempts != nil {
+		v.RetryMaxAttempts = envVars.RetryMaxAttempts
+	}
+	v.RetryBaseDelay = f.baseDelay
+	if envVars.RetryBaseDelay != nil {
+		v.RetryBaseDelay = envVars.RetryBaseDelay
+	}
+	v.RetryMaxDelay = f.maxDelay
+	if envVars.RetryMaxDelay != nil {
+		v.RetryMaxDelay = envVars.RetryMaxDelay
+	}
+	v.RetryMultiplier = f.multiplier
+	if envVars.RetryMultiplier != nil {
+		v.RetryMultiplier = envVars.RetryMultiplier
+	}
+	v.RetryJitter = f.jitter
+	if envVars.RetryJitter != nil {
+		v.RetryJitter = envVars.RetryJitter
+	}
+}
+
+// tlsFlags holds TLS file flags shared by agent and server
+type tlsFlags struct {
+	certFile *string
+	keyFile  *string
+	caFile   *string
+}
+
+func registerTLSFlags(certUsage, caUsage string) *tlsFlags {
+	f := &tlsFlags{
+		certFile: new(string),
+		keyFile:  new(string),
+		caFile:   new(string),
+	}
+	flag.StringVar(f.certFile, "tls-cert", "", certUsage)
+	flag.StringVar(f.keyFile, "tls-key", "", "set path to PEM encoded private key of TLS certificate")
+	flag.StringVar(f.caFile, "tls-ca", "", caUsage)
+	return f
+}
+
+// resolve puts flag values not overridden by environment into v
+func (f *tlsFlags) resolve(envVars *Variables, v *Variables) {
+	v.TLSCertFile = f.certFile
+	if envVars.TLSCertFile != nil {
+		v.TLSCertFile = envVars.TLSCertFile
+	}
+	v.TLSKeyFile = f.keyFile
+	if envVars.TLSKeyFile != nil {
+		v.TLSKeyFile = envVars.TLSKeyFile
+	}
+	v.TLSCAFile = f.caFile
+	if envVars.TLSCAFile != nil {
+		v.TLSCAFile = envVars.TLSCAFile
+	}
+}
+
+// TLSConfig builds TLS files config from parsed options
+func (v *Variables) TLSConfig() tlsconfig.Config {
+	return tlsconfig.Config{
+		CertFile: *v.TLSCertFile,
+		KeyFile:  *v.TLSKeyFile,
+		CAFile:   *v.TLSCAFile,
+	}
+}
+
+// RetryPolicy builds retry policy from parsed options
+func (v *Variables) RetryPolicy() utils.RetryPolicy {
+	return utils.RetryPolicy{
+		MaxAttempts: int(*v.RetryMaxAttempts),
+		BaseDelay:   time.Millisecond * time.Duration(*v.RetryBaseDelay),
+		MaxDelay:    time.Millisecond * time.Duration(*v.RetryMaxDelay),
+		Multiplier:  *v.RetryMultiplier,
+		Jitter:      *v.RetryJitter,
 	}
 }
@@ -0,0 +1,122 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+	return path
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext []byte
+	}{
+		{name: "should round trip batch", plaintext: []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)},
+		{name: "should round trip empty body", plaintext: []byte{}},
+		{name: "should round trip large body", plaintext: []byte(strings.Repeat("x", 1<<20))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := Encrypt(&testKey.PublicKey, tt.plaintext)
+			require.NoError(t, err)
+			plaintext, err := Decrypt(testKey, payload)
+			require.NoError(t, err)
+			require.Equal(t, len(tt.plaintext), len(plaintext))
+			require.Equal(t, string(tt.plaintext), string(plaintext))
+		})
+	}
+}
+
+func TestDecrypt_Rejects(t *testing.T) {
+	payload, err := Encrypt(&testKey.PublicKey, []byte("secret metrics"))
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tampered := append([]byte(nil), payload...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name    string
+		key     *rsa.PrivateKey
+		payload []byte
+	}{
+		{name: "should reject empty payload", key: testKey, payload: nil},
+		{name: "should reject truncated key", key: testKey, payload: payload[:100]},
+		{name: "should reject truncated nonce", key: testKey, payload: payload[:2+256+4]},
+		{name: "should reject tampered ciphertext", key: testKey, payload: tampered},
+		{name: "should reject foreign key", key: otherKey, payload: payload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decrypt(tt.key, tt.payload)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(testKey)
+	require.NoError(t, err)
+	spki, err := x509.MarshalPKIXPublicKey(&testKey.PublicKey)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "metrics"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &testKey.PublicKey, testKey)
+	require.NoError(t, err)
+
+	for name, path := range map[string]string{
+		"pkcs1": writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(testKey)),
+		"pkcs8": writePEM(t, "PRIVATE KEY", pkcs8),
+	} {
+		t.Run("private "+name, func(t *testing.T) {
+			key, err := LoadPrivateKey(path)
+			require.NoError(t, err)
+			require.True(t, testKey.Equal(key))
+		})
+	}
+	for name, path := range map[string]string{
+		"pkcs1":       writePEM(t, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&testKey.PublicKey)),
+		"pkix":        writePEM(t, "PUBLIC KEY", spki),
+		"certificate": writePEM(t, "CERTIFICATE", cert),
+	} {
+		t.Run("public "+name, func(t *testing.T) {
+			key, err := LoadPublicKey(path)
+			require.NoError(t, err)
+			require.True(t, testKey.PublicKey.Equal(key))
+		})
+	}
+
+	_, err = LoadPrivateKey(writePEM(t, "PUBLIC KEY", spki))
+	require.Error(t, err, "public key is not private key")
+	_, err = LoadPublicKey(filepath.Join(t.TempDir(), "missing.pem"))
+	require.Error(t, err)
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/alert"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+)
+
+// GetAlerts lists alerts of rules evaluated for tenant of request
+func (h *metricHandler) GetAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	alerts := []alert.Alert{}
+	if h.alerts != nil {
+		switch state := alert.State(r.URL.Query().Get("state")); state {
+		case "":
+			alerts = h.alerts.Alerts(alert.StateFiring)
+		case "all":
+			alerts = h.alerts.Alerts()
+		case alert.StatePending, alert.StateFiring, alert.StateResolved:
+			alerts = h.alerts.Alerts(state)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		alerts = ownAlerts(alerts, tenant.FromContext(r.Context()))
+	}
+	if err := json.NewEncoder(w).Encode(alerts); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func ownAlerts(alerts []alert.Alert, id string) []alert.Alert {
+	result := make([]alert.Alert, 0, len(alerts))
+	for _, a := range alerts {
+		if a.Tenant == id {
+			result = append(result, a)
+		}
+	}
+	return result
+}
@@ -0,0 +1,20 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// GetBreaker reports state of storage circuit breaker, storages
+// without breaker are reported as not found
+func (h *metricHandler) GetBreaker(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if h.breaker == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(h.breaker.BreakerStatus()); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
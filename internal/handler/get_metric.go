@@ -13,7 +13,7 @@ import (
 func (h *metricHandler) GetMetric(w http.ResponseWriter, r *http.Request) {
 	metricName := strings.TrimSpace(chi.URLParam(r, "name"))
 	metricType := strings.TrimSpace(chi.URLParam(r, "type"))
-	metric, err := h.service.GetMetric(metricName, metricType)
+	metric, err := h.service.GetMetric(r.Context(), metricName, metricType)
 	w.Header().Set("Content-Type", "text/plain")
 	var metricErr *service.InvalidMetricError
 	if errors.As(err, &metricErr) {
@@ -22,7 +22,7 @@ func (h *metricHandler) GetMetricByJSON(w http.ResponseWriter, r *http.Request)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	m, err := h.service.GetMetricByModel(&metric)
+	m, err := h.service.GetMetricByModel(r.Context(), &metric)
 	var metricErr *service.InvalidMetricError
 	if errors.As(err, &metricErr) {
 		w.WriteHeader(metricErr.StatusCode)
@@ -0,0 +1,12 @@
+package handler
+
+import "net/http"
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+func (h *metricHandler) GetMetricsForPrometheus(w http.ResponseWriter, r *http.Request) {
+	metrics := h.service.GetAllMetricsForPrometheus(r.Context())
+	w.Header().Set("Content-Type", prometheusContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(metrics))
+}
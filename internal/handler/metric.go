@@ -1,26 +1,43 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/alert"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/middleware"
 	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/repository"
 	"github.com/go-chi/chi"
 )
 
+// metricService methods receive request context, so work of
+// disconnected clients is cancelled down to the storage
 type metricService interface {
-	SetCounter(name string, value string) error
-	SetGauge(name string, value string) error
-	SetMetricByModel([]byte) (*models.Metrics, error)
-	GetMetricByModel(m *models.Metrics) (*models.Metrics, error)
-	GetMetric(metricType, name string) (*models.Metrics, error)
-	GetAllMetricsForHTML() string
-	SetMetricBulk([]byte, []byte) error
-	Ping() error
+	SetCounter(ctx context.Context, name string, value string) error
+	SetGauge(ctx context.Context, name string, value string) error
+	SetMetricByModel(ctx context.Context, input []byte) (*models.Metrics, error)
+	GetMetricByModel(ctx context.Context, m *models.Metrics) (*models.Metrics, error)
+	GetMetric(ctx context.Context, metricType, name string) (*models.Metrics, error)
+	GetAllMetricsForHTML(ctx context.Context) string
+	GetAllMetricsForPrometheus(ctx context.Context) string
+	QueryRange(ctx context.Context, q *models.RangeQuery) (*models.RangeResult, error)
+	SetMetricBulk(ctx context.Context, input []byte) error
+	WriteLineProtocol(ctx context.Context, input []byte) (*models.WriteResult, error)
+	Ping(ctx context.Context) error
+}
+
+type alertProvider interface {
+	Alerts(states ...alert.State) []alert.Alert
 }
 
 type metricHandler struct {
 	service metricService
+	alerts  alertProvider
+	breaker repository.BreakerReporter
+	hashKey []byte
+	policy  func() *access.Policy
 }
 
 func NewMetricHandler(s metricService) *metricHandler {
@@ -29,19 +46,71 @@ func NewMetricHandler(s metricService) *metricHandler {
 	}
 }
 
+// WithAlerts enables alerts listing, otherwise empty list is served
+func (h *metricHandler) WithAlerts(a alertProvider) *metricHandler {
+	h.alerts = a
+	return h
+}
+
+// WithHashKey enables signature verification of write requests
+// and signing of their responses
+func (h *metricHandler) WithHashKey(key []byte) *metricHandler {
+	h.hashKey = key
+	return h
+}
+
+// WithBreaker enables reporting of storage circuit breaker state
+func (h *metricHandler) WithBreaker(b repository.BreakerReporter) *metricHandler {
+	h.breaker = b
+	return h
+}
+
+// WithAccessPolicy enables access control of routes by scope,
+// policy is requested per request so it may be reloaded
+func (h *metricHandler) WithAccessPolicy(policy func() *access.Policy) *metricHandler {
+	h.policy = policy
+	return h
+}
+
 func (h *metricHandler) Register(engine *chi.Mux) {
+	read := middleware.AccessHandler(h.policy, access.ScopeRead)
+	write := middleware.AccessHandler(h.policy, access.ScopeWrite)
+	admin := middleware.AccessHandler(h.policy, access.ScopeAdmin)
 	engine.Get("/ping", h.Ping)
 	engine.
-		With(middleware.CompressHandler).
+		With(read, middleware.CompressHandler).
 		Get("/", http.HandlerFunc(h.GetAllMetrics))
-	engine.Get("/value/{type}/{name}", http.HandlerFunc(h.GetMetric))
-	engine.Post("/update/{type}/{name}/{value}", http.HandlerFunc(h.SetMetric))
 	engine.
-		With(middleware.CompressHandler).
+		With(read, middleware.CompressHandler).
+		Get("/metrics", http.HandlerFunc(h.GetMetricsForPrometheus))
+	engine.
+		With(read).
+		Get("/value/{type}/{name}", http.HandlerFunc(h.GetMetric))
+	engine.
+		With(write, middleware.HashHandler(h.hashKey)).
+		Post("/update/{type}/{name}/{value}", http.HandlerFunc(h.SetMetric))
+	engine.
+		With(write, middleware.CompressHandler, middleware.HashHandler(h.hashKey)).
 		Post("/update/", http.HandlerFunc(h.SetMetricByJSON))
 	engine.
-		With(middleware.CompressHandler).
+		With(read, middleware.CompressHandler).
 		Post("/value/", http.HandlerFunc(h.GetMetricByJSON))
-	engine.With(middleware.CompressHandler).
+	engine.
+		With(write, middleware.CompressHandler, middleware.HashHandler(h.hashKey)).
 		Post("/updates/", http.HandlerFunc(h.SetMetricBulk))
+	engine.
+		With(write, middleware.CompressHandler, middleware.HashHandler(h.hashKey)).
+		Post("/write", http.HandlerFunc(h.WriteLineProtocol))
+	engine.
+		With(read, middleware.CompressHandler).
+		Get("/query_range", http.HandlerFunc(h.QueryRange))
+	engine.
+		With(read, middleware.CompressHandler).
+		Post("/query_range/", http.HandlerFunc(h.QueryRangeByJSON))
+	engine.
+		With(read, middleware.CompressHandler).
+		Get("/alerts", http.HandlerFunc(h.GetAlerts))
+	engine.
+		With(admin).
+		Get("/admin/breaker", http.HandlerFunc(h.GetBreaker))
 }
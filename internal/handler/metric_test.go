@@ -1,15 +1,22 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/alert"
 	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/repository"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/service"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
 	"github.com/go-chi/chi"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -19,46 +26,61 @@ type metricServiceStub struct {
 	mock.Mock
 }
 
-func (m *metricServiceStub) SetCounter(name string, value string) error {
+func (m *metricServiceStub) SetCounter(_ context.Context, name string, value string) error {
 	args := m.Called(name, value)
 	return args.Error(0)
 }
 
-func (m *metricServiceStub) SetGauge(name string, value string) error {
+func (m *metricServiceStub) SetGauge(_ context.Context, name string, value string) error {
 	args := m.Called(name, value)
 	return args.Error(0)
 }
 
-func (m *metricServiceStub) GetMetric(name string, metricType string) (*models.Metrics, error) {
+func (m *metricServiceStub) GetMetric(_ context.Context, name string, metricType string) (*models.Metrics, error) {
 	args := m.Called(name, metricType)
 	return args.Get(0).(*models.Metrics), args.Error(1)
 }
 
-func (m *metricServiceStub) GetAllMetricsForHTML() string {
+func (m *metricServiceStub) GetAllMetricsForHTML(_ context.Context) string {
 	args := m.Called()
 	return args.Get(0).(string)
 }
 
-func (m *metricServiceStub) SetMetricByModel(metric []byte) (*models.Metrics, error) {
+func (m *metricServiceStub) QueryRange(_ context.Context, q *models.RangeQuery) (*models.RangeResult, error) {
+	args := m.Called(q)
+	return args.Get(0).(*models.RangeResult), args.Error(1)
+}
+
+func (m *metricServiceStub) GetAllMetricsForPrometheus(_ context.Context) string {
+	args := m.Called()
+	return args.Get(0).(string)
+}
+
+func (m *metricServiceStub) SetMetricByModel(_ context.Context, metric []byte) (*models.Metrics, error) {
 	args := m.Called(metric)
 	return args.Get(0).(*models.Metrics), args.Error(1)
 }
 
-func (m *metricServiceStub) GetMetricByModel(metric *models.Metrics) (*models.Metrics, error) {
+func (m *metricServiceStub) GetMetricByModel(_ context.Context, metric *models.Metrics) (*models.Metrics, error) {
 	args := m.Called(metric)
 	return args.Get(0).(*models.Metrics), args.Error(1)
 }
 
-func (m *metricServiceStub) Ping() error {
+func (m *metricServiceStub) Ping(_ context.Context) error {
 	args := m.Called()
 	return args.Error(0)
 }
 
-func (m *metricServiceStub) SetMetricBulk(body []byte, signature []byte) error {
-	args := m.Called(body, signature)
+func (m *metricServiceStub) SetMetricBulk(_ context.Context, body []byte) error {
+	args := m.Called(body)
 	return args.Error(0)
 }
 
+func (m *metricServiceStub) WriteLineProtocol(_ context.Context, body []byte) (*models.WriteResult, error) {
+	args := m.Called(body)
+	return args.Get(0).(*models.WriteResult), args.Error(1)
+}
+
 func TestNewMetricHandler(t *testing.T) {
 	type args struct {
 		s metricService
@@ -367,3 +389,159 @@ func Test_metricHandler_GetMetric(t *testing.T) {
 		})
 	}
 }
+
+type breakerStub struct {
+	status repository.BreakerStatus
+}
+
+func (b *breakerStub) BreakerStatus() repository.BreakerStatus {
+	return b.status
+}
+
+func Test_metricHandler_GetBreaker(t *testing.T) {
+	changedAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		breaker    repository.BreakerReporter
+		statusCode int
+		body       string
+	}{
+		{
+			name:       "should report breaker state",
+			breaker:    &breakerStub{status: repository.BreakerStatus{State: repository.BreakerOpen, Failures: 3, ChangedAt: changedAt}},
+			statusCode: http.StatusOK,
+			body:       `{"state":"open","failures":3,"changedAt":"2025-01-01T00:00:00Z"}` + "\n",
+		},
+		{
+			name:       "should report missing breaker",
+			breaker:    nil,
+			statusCode: http.StatusNotFound,
+			body:       "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewMetricHandler(&metricServiceStub{})
+			if tt.breaker != nil {
+				h.WithBreaker(tt.breaker)
+			}
+			w := httptest.NewRecorder()
+			h.GetBreaker(w, httptest.NewRequest(http.MethodGet, "/admin/breaker", nil))
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.body, w.Body.String())
+		})
+	}
+}
+
+type alertProviderStub struct {
+	alerts []alert.Alert
+}
+
+func (a *alertProviderStub) Alerts(states ...alert.State) []alert.Alert {
+	return a.alerts
+}
+
+func Test_metricHandler_GetAlerts(t *testing.T) {
+	provider := &alertProviderStub{alerts: []alert.Alert{
+		{Rule: "HighHeap", State: alert.StateFiring},
+		{Rule: "TeamHighHeap", Tenant: "team-a", State: alert.StateFiring},
+	}}
+	tests := []struct {
+		name   string
+		tenant string
+		rules  []string
+	}{
+		{name: "should list alerts of default tenant", rules: []string{"HighHeap"}},
+		{name: "should list alerts of request tenant", tenant: "team-a", rules: []string{"TeamHighHeap"}},
+		{name: "should list nothing for tenant without rules", tenant: "team-b", rules: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewMetricHandler(&metricServiceStub{}).WithAlerts(provider)
+			r := httptest.NewRequest(http.MethodGet, "/alerts", nil)
+			r = r.WithContext(tenant.WithID(r.Context(), tt.tenant))
+			w := httptest.NewRecorder()
+			h.GetAlerts(w, r)
+			assert.Equal(t, http.StatusOK, w.Code)
+			var alerts []alert.Alert
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&alerts))
+			rules := []string{}
+			for _, a := range alerts {
+				rules = append(rules, a.Rule)
+			}
+			assert.Equal(t, tt.rules, rules)
+		})
+	}
+}
+
+func Test_metricHandler_WriteLineProtocol(t *testing.T) {
+	body := "cpu,host=a usage=0.5\ncpu usage=oops"
+	tests := []struct {
+		name       string
+		result     *models.WriteResult
+		err        error
+		statusCode int
+		body       string
+	}{
+		{
+			name:       "should report accepted lines",
+			result:     &models.WriteResult{Accepted: 2},
+			statusCode: http.StatusOK,
+			body:       `{"accepted":2}` + "\n",
+		},
+		{
+			name: "should report rejected lines",
+			result: &models.WriteResult{
+				Accepted: 1,
+				Errors:   []models.LineError{{Line: 2, Error: "invalid value of field usage"}},
+			},
+			statusCode: http.StatusBadRequest,
+			body:       `{"accepted":1,"errors":[{"line":2,"error":"invalid value of field usage"}]}` + "\n",
+		},
+		{
+			name:       "should report failed write",
+			result:     (*models.WriteResult)(nil),
+			err:        &service.InvalidMetricError{Message: "tenant \"team-a\" exceeded quota of 1 series", StatusCode: http.StatusForbidden},
+			statusCode: http.StatusForbidden,
+			body:       "tenant \"team-a\" exceeded quota of 1 series\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &metricServiceStub{}
+			s.On("WriteLineProtocol", []byte(body)).Return(tt.result, tt.err)
+			w := httptest.NewRecorder()
+			NewMetricHandler(s).WriteLineProtocol(w, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(body)))
+			assert.Equal(t, tt.statusCode, w.Code)
+			assert.Equal(t, tt.body, w.Body.String())
+		})
+	}
+}
+
+func Test_metricHandler_WriteLineProtocolTelegraf(t *testing.T) {
+	storage := repository.NewMemoryStorage()
+	h := NewMetricHandler(service.NewMetricService(storage))
+	// Telegraf output always carries timestamps
+	body := "cpu,host=a usage_idle=97.5 1700000000000000000\n" +
+		"net,iface=eth0 bytes_recv=1024i,bytes_sent=7u 1700000000000000000\n" +
+		"net,iface=eth0 bytes_recv=16i 1700000010000000000\n"
+	w := httptest.NewRecorder()
+	h.WriteLineProtocol(w, httptest.NewRequest(http.MethodPost, "/write", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `{"accepted":3}`+"\n", w.Body.String())
+
+	ctx := context.Background()
+	idle, ok := storage.GetMetric(ctx, "cpu_usage_idle", models.Gauge, models.Labels{"host": "a"})
+	if assert.True(t, ok) {
+		assert.Equal(t, 97.5, *idle.Value)
+	}
+	netLabels := models.Labels{"iface": "eth0"}
+	recv, ok := storage.GetMetric(ctx, "net_bytes_recv", models.Counter, netLabels)
+	if assert.True(t, ok) {
+		assert.Equal(t, int64(1040), *recv.Delta, "integer fields should be counter deltas")
+	}
+	sent, ok := storage.GetMetric(ctx, "net_bytes_sent", models.Counter, netLabels)
+	if assert.True(t, ok) {
+		assert.Equal(t, int64(7), *sent.Delta)
+	}
+}
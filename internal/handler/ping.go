@@ -3,7 +3,7 @@ package handler
 import "net/http"
 
 func (h metricHandler) Ping(w http.ResponseWriter, r *http.Request) {
-	if err := h.service.Ping(); err != nil {
+	if err := h.service.Ping(r.Context()); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
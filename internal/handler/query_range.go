@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/service"
+)
+
+func (h *metricHandler) QueryRange(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := &models.RangeQuery{
+		ID:    params.Get("id"),
+		MType: params.Get("type"),
+		Start: params.Get("start"),
+		End:   params.Get("end"),
+		Step:  params.Get("step"),
+		Agg:   params.Get("agg"),
+	}
+	// labels are passed as repeated label=name:value params
+	for _, label := range params["label"] {
+		name, value, ok := strings.Cut(label, ":")
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if q.Labels == nil {
+			q.Labels = make(models.Labels)
+		}
+		q.Labels[name] = value
+	}
+	h.writeRange(w, r, q)
+}
+
+func (h *metricHandler) QueryRangeByJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var q models.RangeQuery
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.writeRange(w, r, &q)
+}
+
+func (h *metricHandler) writeRange(w http.ResponseWriter, r *http.Request, q *models.RangeQuery) {
+	w.Header().Set("Content-Type", "application/json")
+	result, err := h.service.QueryRange(r.Context(), q)
+	var metricErr *service.InvalidMetricError
+	if errors.As(err, &metricErr) {
+		w.WriteHeader(metricErr.StatusCode)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
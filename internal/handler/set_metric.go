@@ -17,9 +17,9 @@ func (h *metricHandler) SetMetric(w http.ResponseWriter, r *http.Request) {
 	var err error
 	switch metricType {
 	case models.Gauge:
-		err = h.service.SetGauge(metricName, metricValue)
+		err = h.service.SetGauge(r.Context(), metricName, metricValue)
 	case models.Counter:
-		err = h.service.SetCounter(metricName, metricValue)
+		err = h.service.SetCounter(r.Context(), metricName, metricValue)
 	default:
 		log.Printf("Unknown metric type: %s\n", metricType)
 		w.WriteHeader(http.StatusBadRequest)
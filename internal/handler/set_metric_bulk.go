@@ -20,8 +20,7 @@ func (h *metricHandler) SetMetricBulk(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var metricErr *service.InvalidMetricError
-	hash := r.Header.Get("hashsha256")
-	err = h.service.SetMetricBulk(body, []byte(hash))
+	err = h.service.SetMetricBulk(r.Context(), body)
 	if errors.As(err, &metricErr) {
 		w.WriteHeader(metricErr.StatusCode)
 		return
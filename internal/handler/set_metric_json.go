@@ -22,7 +22,7 @@ func (h *metricHandler) SetMetricByJSON(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	var metricErr *service.InvalidMetricError
-	m, err := h.service.SetMetricByModel(body)
+	m, err := h.service.SetMetricByModel(r.Context(), body)
 	if errors.As(err, &metricErr) {
 		w.WriteHeader(metricErr.StatusCode)
 		return
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/service"
+)
+
+// WriteLineProtocol accepts InfluxDB line protocol, e.g. from Telegraf,
+// batch with rejected lines is answered with 400 and per line errors,
+// so clients do not resend lines which are already stored
+func (h *metricHandler) WriteLineProtocol(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	result, err := h.service.WriteLineProtocol(r.Context(), body)
+	var metricErr *service.InvalidMetricError
+	if errors.As(err, &metricErr) {
+		http.Error(w, metricErr.Message, metricErr.StatusCode)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if len(result.Errors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	json.NewEncoder(w).Encode(result)
+}
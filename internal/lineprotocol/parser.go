@@ -0,0 +1,146 @@
+package lineprotocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Point is single line of InfluxDB line protocol:
+// measurement[,tag=value...] field=value[,field=value...] [timestamp]
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	// nanoseconds since epoch, nil when line has no timestamp
+	Timestamp *int64
+}
+
+// Field value is float64, int64, uint64, string or bool
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Parse parses line which must not be empty or comment
+func Parse(line string) (*Point, error) {
+	sections := split(line, ' ', true)
+	if len(sections) < 2 {
+		return nil, errors.New("missing fields")
+	}
+	if len(sections) > 3 {
+		return nil, errors.New("unexpected text after timestamp")
+	}
+	keys := split(sections[0], ',', false)
+	p := &Point{Measurement: unescape(keys[0])}
+	if p.Measurement == "" {
+		return nil, errors.New("missing measurement")
+	}
+	for _, tag := range keys[1:] {
+		key, value, err := pair(tag)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag %q: %v", tag, err)
+		}
+		if p.Tags == nil {
+			p.Tags = make(map[string]string)
+		}
+		p.Tags[key] = value
+	}
+	for _, field := range split(sections[1], ',', true) {
+		key, raw, err := pair(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field %q: %v", field, err)
+		}
+		value, err := parseValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of field %s: %v", key, err)
+		}
+		p.Fields = append(p.Fields, Field{Key: key, Value: value})
+	}
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		p.Timestamp = &ts
+	}
+	return p, nil
+}
+
+// pair splits key=value at the first unescaped equal sign
+func pair(s string) (string, string, error) {
+	parts := split(s, '=', false)
+	if len(parts) < 2 {
+		return "", "", errors.New("missing value")
+	}
+	key := unescape(parts[0])
+	if key == "" {
+		return "", "", errors.New("empty key")
+	}
+	// value keeps the rest, string field values may contain equal signs
+	value := s[len(parts[0])+1:]
+	if value == "" {
+		return "", "", errors.New("empty value")
+	}
+	if !strings.HasPrefix(value, `"`) {
+		value = unescape(value)
+	}
+	return key, value, nil
+}
+
+func parseValue(raw string) (any, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		if len(raw) < 2 || !strings.HasSuffix(raw, `"`) {
+			return nil, errors.New("unterminated string")
+		}
+		return unescape(raw[1 : len(raw)-1]), nil
+	case strings.HasSuffix(raw, "i"):
+		return strconv.ParseInt(raw[:len(raw)-1], 10, 64)
+	case strings.HasSuffix(raw, "u"):
+		return strconv.ParseUint(raw[:len(raw)-1], 10, 64)
+	}
+	switch raw {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+	return strconv.ParseFloat(raw, 64)
+}
+
+// split splits s at unescaped sep, separators inside double quoted
+// strings are skipped when quoted is set
+func split(s string, sep byte, quoted bool) []string {
+	var parts []string
+	start := 0
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"' && quoted:
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescape removes backslashes escaping the next character
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,109 @@
+package lineprotocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	ts := int64(1700000000000000000)
+	tests := []struct {
+		name    string
+		line    string
+		want    *Point
+		wantErr bool
+	}{
+		{
+			name: "should parse measurement with single field",
+			line: "cpu usage=0.5",
+			want: &Point{Measurement: "cpu", Fields: []Field{{Key: "usage", Value: 0.5}}},
+		},
+		{
+			name: "should parse tags, typed fields and timestamp",
+			line: "net,host=a,iface=eth0 recv=10i,sent=7u,up=true,ratio=1e3 1700000000000000000",
+			want: &Point{
+				Measurement: "net",
+				Tags:        map[string]string{"host": "a", "iface": "eth0"},
+				Fields: []Field{
+					{Key: "recv", Value: int64(10)},
+					{Key: "sent", Value: uint64(7)},
+					{Key: "up", Value: true},
+					{Key: "ratio", Value: 1000.0},
+				},
+				Timestamp: &ts,
+			},
+		},
+		{
+			name: "should unescape names",
+			line: `disk\ io,path=C:\\dir,mount\,point=a\=b read\ ops=1`,
+			want: &Point{
+				Measurement: "disk io",
+				Tags:        map[string]string{"path": `C:\dir`, "mount,point": "a=b"},
+				Fields:      []Field{{Key: "read ops", Value: 1.0}},
+			},
+		},
+		{
+			name: "should keep separators inside string field",
+			line: `log msg="a b,c=d \"quoted\"",level=2i`,
+			want: &Point{
+				Measurement: "log",
+				Fields: []Field{
+					{Key: "msg", Value: `a b,c=d "quoted"`},
+					{Key: "level", Value: int64(2)},
+				},
+			},
+		},
+		{
+			name:    "should reject line without fields",
+			line:    "cpu,host=a",
+			wantErr: true,
+		},
+		{
+			name:    "should reject missing measurement",
+			line:    ",host=a usage=1",
+			wantErr: true,
+		},
+		{
+			name:    "should reject tag without value",
+			line:    "cpu,host usage=1",
+			wantErr: true,
+		},
+		{
+			name:    "should reject invalid field value",
+			line:    "cpu usage=oops",
+			wantErr: true,
+		},
+		{
+			name:    "should reject invalid integer",
+			line:    "cpu usage=1.5i",
+			wantErr: true,
+		},
+		{
+			name:    "should reject unterminated string",
+			line:    `log msg="open`,
+			wantErr: true,
+		},
+		{
+			name:    "should reject invalid timestamp",
+			line:    "cpu usage=1 yesterday",
+			wantErr: true,
+		},
+		{
+			name:    "should reject text after timestamp",
+			line:    "cpu usage=1 1 2",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.line)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+)
+
+// AccessHandler rejects requests not allowed to use scope by current policy
+// with 403 and the reason, nil policy source disables access control
+func AccessHandler(policy func() *access.Policy, scope access.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if policy == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := policy().Check(
+				r.Header.Get(access.RealIPHeader),
+				r.Header.Get(access.APIKeyHeader),
+				scope,
+			)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"trustedSubnets": ["10.0.0.0/8"],
+		"keys": [{"name": "agent", "key": "secret", "scopes": ["write"]}]
+	}`), 0600))
+	policy, err := access.LoadPolicy(path)
+	require.NoError(t, err)
+	source := func() *access.Policy { return policy }
+	tests := []struct {
+		name       string
+		source     func() *access.Policy
+		realIP     string
+		apiKey     string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "should pass allowed request",
+			source:     source,
+			realIP:     "10.0.0.7",
+			apiKey:     "secret",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "should reject untrusted address with reason",
+			source:     source,
+			realIP:     "172.16.0.1",
+			apiKey:     "secret",
+			wantStatus: http.StatusForbidden,
+			wantBody:   "access denied: 172.16.0.1 is not in trusted subnets\n",
+		},
+		{
+			name:       "should reject unknown key with reason",
+			source:     source,
+			realIP:     "10.0.0.7",
+			apiKey:     "guess",
+			wantStatus: http.StatusForbidden,
+			wantBody:   "access denied: unknown API key\n",
+		},
+		{
+			name:       "should pass everything without policy",
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			r := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+			if tt.realIP != "" {
+				r.Header.Set(access.RealIPHeader, tt.realIP)
+			}
+			if tt.apiKey != "" {
+				r.Header.Set(access.APIKeyHeader, tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			AccessHandler(tt.source, access.ScopeWrite)(next).ServeHTTP(w, r)
+			require.Equal(t, tt.wantStatus, w.Code)
+			require.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"bytes"
 	"compress/gzip"
+	"compress/zlib"
 	"io"
 	"net/http"
 	"strings"
@@ -41,3 +43,48 @@ func CompressHandler(next http.Handler) http.Handler {
 		}
 	})
 }
+
+// DecompressHandler transparently decodes gzip and deflate request bodies,
+// bodies exceeding maxSize after decompression are rejected, plain bodies
+// are limited to maxSize while handlers read them
+func DecompressHandler(maxSize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var reader io.ReadCloser
+			var err error
+			switch strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))) {
+			case "":
+				r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+				next.ServeHTTP(w, r)
+				return
+			case "gzip":
+				reader, err = gzip.NewReader(r.Body)
+			case "deflate":
+				reader, err = zlib.NewReader(r.Body)
+			default:
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			defer reader.Close()
+			// read one byte over the limit to detect oversized body
+			body, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > maxSize {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
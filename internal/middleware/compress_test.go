@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func deflated(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestDecompressHandler(t *testing.T) {
+	payload := `[{"id":"Alloc","type":"gauge","value":1}]`
+	tests := []struct {
+		name       string
+		encoding   string
+		body       []byte
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "should pass plain body",
+			body:       []byte(payload),
+			wantStatus: http.StatusOK,
+			wantBody:   payload,
+		},
+		{
+			name:       "should decompress gzip body",
+			encoding:   "gzip",
+			body:       gzipped(t, payload),
+			wantStatus: http.StatusOK,
+			wantBody:   payload,
+		},
+		{
+			name:       "should decompress deflate body",
+			encoding:   "deflate",
+			body:       deflated(t, payload),
+			wantStatus: http.StatusOK,
+			wantBody:   payload,
+		},
+		{
+			name:       "should reject body exceeding limit after decompression",
+			encoding:   "gzip",
+			body:       gzipped(t, strings.Repeat("0", 1024)),
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "should reject malformed gzip body",
+			encoding:   "gzip",
+			body:       []byte(payload),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "should reject unsupported encoding",
+			encoding:   "br",
+			body:       []byte(payload),
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Empty(t, r.Header.Get("Content-Encoding"))
+				body, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				got = string(body)
+			})
+			r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				r.Header.Set("Content-Encoding", tt.encoding)
+			}
+			w := httptest.NewRecorder()
+			DecompressHandler(512)(next).ServeHTTP(w, r)
+			require.Equal(t, tt.wantStatus, w.Code)
+			require.Equal(t, tt.wantBody, got)
+		})
+	}
+}
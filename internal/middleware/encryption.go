@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/encryption"
+)
+
+// DecryptHandler opens request bodies encrypted with server public key,
+// it must run before DecompressHandler as agents compress before
+// encrypting, nil key rejects encrypted requests
+func DecryptHandler(key *rsa.PrivateKey, maxSize int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scheme := r.Header.Get(encryption.Header)
+			if scheme == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if key == nil {
+				http.Error(w, "encrypted requests are not accepted", http.StatusBadRequest)
+				return
+			}
+			if scheme != encryption.Scheme {
+				http.Error(w, "unsupported encryption scheme: "+scheme, http.StatusBadRequest)
+				return
+			}
+			// envelope adds few hundred bytes to the body
+			payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSize+4096))
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			body, err := encryption.Decrypt(key, payload)
+			if err != nil {
+				http.Error(w, "failed to decrypt request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+			r.Header.Del(encryption.Header)
+			r.Header.Del("Content-Length")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
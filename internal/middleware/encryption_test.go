@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/encryption"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecryptHandler(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	encrypted, err := encryption.Encrypt(&key.PublicKey, body)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		key        *rsa.PrivateKey
+		scheme     string
+		payload    []byte
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "should decrypt encrypted request",
+			key:        key,
+			scheme:     encryption.Scheme,
+			payload:    encrypted,
+			wantStatus: http.StatusOK,
+			wantBody:   string(body),
+		},
+		{
+			name:       "should pass plain request",
+			key:        key,
+			payload:    body,
+			wantStatus: http.StatusOK,
+			wantBody:   string(body),
+		},
+		{
+			name:       "should reject encrypted request without key",
+			scheme:     encryption.Scheme,
+			payload:    encrypted,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "encrypted requests are not accepted\n",
+		},
+		{
+			name:       "should reject unknown scheme",
+			key:        key,
+			scheme:     "rot13",
+			payload:    encrypted,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "unsupported encryption scheme: rot13\n",
+		},
+		{
+			name:       "should reject garbage payload",
+			key:        key,
+			scheme:     encryption.Scheme,
+			payload:    body,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "failed to decrypt request body\n",
+		},
+		{
+			name:       "should reject body over limit",
+			key:        key,
+			scheme:     encryption.Scheme,
+			payload:    make([]byte, 1<<20+4097),
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantBody:   "request body too large\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Empty(t, r.Header.Get(encryption.Header))
+				got, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				w.Write(got)
+			})
+			r := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.payload))
+			if tt.scheme != "" {
+				r.Header.Set(encryption.Header, tt.scheme)
+			}
+			w := httptest.NewRecorder()
+			DecryptHandler(tt.key, 1<<20)(next).ServeHTTP(w, r)
+			require.Equal(t, tt.wantStatus, w.Code)
+			require.Equal(t, tt.wantBody, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
+)
+
+// HashHeader carries hex encoded HMAC-SHA256 of request and response bodies
+const HashHeader = "HashSHA256"
+
+// signingResponseWriter holds response until handler finishes,
+// so signature can be sent in header before body
+type signingResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *signingResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *signingResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// SigningPayload returns signed part of request, body-less request is
+// signed over method and request URI, otherwise signature of empty body
+// would be valid for any URL
+func SigningPayload(method, requestURI string, body []byte) []byte {
+	if len(body) > 0 {
+		return body
+	}
+	return []byte(method + " " + requestURI)
+}
+
+// HashHandler rejects requests without valid signature and signs
+// response bodies with the same key, empty key disables both, bodies
+// are signed uncompressed so it must run inside CompressHandler; body
+// over limit set by DecompressHandler is answered with 413
+func HashHandler(key []byte) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(key) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			signature := r.Header.Get(HashHeader)
+			if signature == "" {
+				http.Error(w, "missing "+HashHeader+" header", http.StatusBadRequest)
+				return
+			}
+			body, err := io.ReadAll(r.Body)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, "failed to read request body", http.StatusBadRequest)
+				return
+			}
+			payload := SigningPayload(r.Method, r.URL.RequestURI(), body)
+			if !utils.IsHashValid([]byte(signature), payload, key) {
+				http.Error(w, "invalid "+HashHeader+" signature", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			srw := &signingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(srw, r)
+			w.Header().Set(HashHeader, utils.HashBody(key, srw.body.Bytes()))
+			if srw.statusCode != 0 {
+				w.WriteHeader(srw.statusCode)
+			}
+			w.Write(srw.body.Bytes())
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashHandler(t *testing.T) {
+	key := []byte("secret")
+	body := `[{"id":"PollCount","type":"counter","delta":1}]`
+	tests := []struct {
+		name       string
+		key        []byte
+		signature  string
+		wantStatus int
+		wantBody   string
+		wantSigned bool
+	}{
+		{
+			name:       "should pass signed request and sign response",
+			key:        key,
+			signature:  utils.HashBody(key, []byte(body)),
+			wantStatus: http.StatusCreated,
+			wantBody:   "{}",
+			wantSigned: true,
+		},
+		{
+			name:       "should reject unsigned request",
+			key:        key,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "missing HashSHA256 header\n",
+		},
+		{
+			name:       "should reject mismatched signature",
+			key:        key,
+			signature:  utils.HashBody([]byte("other"), []byte(body)),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid HashSHA256 signature\n",
+		},
+		{
+			name:       "should reject malformed signature",
+			key:        key,
+			signature:  strings.Repeat("ab", 64),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid HashSHA256 signature\n",
+		},
+		{
+			name:       "should pass everything without key",
+			wantStatus: http.StatusCreated,
+			wantBody:   "{}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := HashHandler(tt.key)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("{}"))
+			}))
+			r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+			if tt.signature != "" {
+				r.Header.Set(HashHeader, tt.signature)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tt.wantStatus, w.Code)
+			require.Equal(t, tt.wantBody, w.Body.String())
+			signature := w.Header().Get(HashHeader)
+			if tt.wantSigned {
+				require.True(t, utils.IsHashValid([]byte(signature), w.Body.Bytes(), key))
+			} else {
+				require.Empty(t, signature)
+			}
+		})
+	}
+}
+
+func TestHashHandler_KeepsBody(t *testing.T) {
+	key := []byte("secret")
+	body := `{"id":"Alloc","type":"gauge","value":1}`
+	var received string
+	handler := HashHandler(key)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		received = string(data)
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+	r.Header.Set(HashHeader, utils.HashBody(key, []byte(body)))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, body, received, "verified body should reach handler")
+	require.True(t, utils.IsHashValid([]byte(w.Header().Get(HashHeader)), nil, key), "empty response should be signed too")
+}
+
+func TestHashHandler_BodylessRequest(t *testing.T) {
+	key := []byte("secret")
+	handler := HashHandler(key)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	uri := "/update/counter/PollCount/1"
+	signature := utils.HashBody(key, SigningPayload(http.MethodPost, uri, nil))
+	tests := []struct {
+		name       string
+		uri        string
+		signature  string
+		wantStatus int
+	}{
+		{
+			name:       "should pass request signed over its URL",
+			uri:        uri,
+			signature:  signature,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "should reject signature of another URL",
+			uri:        "/update/counter/PollCount/1000",
+			signature:  signature,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "should reject signature of empty body",
+			uri:        uri,
+			signature:  utils.HashBody(key, nil),
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.uri, nil)
+			r.Header.Set(HashHeader, tt.signature)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			require.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestHashHandler_BodyTooLarge(t *testing.T) {
+	key := []byte("secret")
+	body := strings.Repeat("0", 1024)
+	called := false
+	handler := DecompressHandler(512)(HashHandler(key)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})))
+	r := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+	r.Header.Set(HashHeader, utils.HashBody(key, []byte(body)))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	require.False(t, called, "oversized request should not reach handler")
+}
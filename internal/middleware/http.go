@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -49,3 +50,18 @@ func HTTPLogMiddleware(logger *zap.Logger) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// RequestTimeout bounds request context with deadline, so storage work of
+// slow requests is cancelled, zero timeout keeps requests unbounded
+func RequestTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+)
+
+// TenantHandler puts tenant of request into its context, tenant bound to
+// API key by policy wins over header which may not name another tenant,
+// requests naming no tenant belong to the default one
+func TenantHandler(policy func() *access.Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(tenant.Header)
+			if id != "" && !tenant.Valid(id) {
+				http.Error(w, "invalid "+tenant.Header+" header", http.StatusBadRequest)
+				return
+			}
+			if policy != nil {
+				bound, ok := policy().KeyTenant(r.Header.Get(access.APIKeyHeader))
+				if ok && id != "" && id != bound {
+					http.Error(w, "API key is bound to another tenant", http.StatusForbidden)
+					return
+				}
+				if ok {
+					id = bound
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(tenant.WithID(r.Context(), id)))
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"keys": [
+		{"name": "team-a-agent", "key": "a-key", "scopes": ["write"], "tenant": "team-a"},
+		{"name": "ops", "key": "ops-key", "scopes": ["admin"]}
+	]}`), 0600))
+	policy, err := access.LoadPolicy(path)
+	require.NoError(t, err)
+	source := func() *access.Policy { return policy }
+	tests := []struct {
+		name       string
+		source     func() *access.Policy
+		header     string
+		apiKey     string
+		wantStatus int
+		wantTenant string
+	}{
+		{name: "should use default tenant", source: source, wantStatus: http.StatusOK},
+		{name: "should use tenant of header", source: source, header: "team-b", wantStatus: http.StatusOK, wantTenant: "team-b"},
+		{name: "should use tenant bound to key", source: source, apiKey: "a-key", wantStatus: http.StatusOK, wantTenant: "team-a"},
+		{
+			name:       "should accept header matching key",
+			source:     source,
+			header:     "team-a",
+			apiKey:     "a-key",
+			wantStatus: http.StatusOK,
+			wantTenant: "team-a",
+		},
+		{
+			name:       "should let unbound key choose tenant",
+			source:     source,
+			header:     "team-b",
+			apiKey:     "ops-key",
+			wantStatus: http.StatusOK,
+			wantTenant: "team-b",
+		},
+		{name: "should reject header contradicting key", source: source, header: "team-b", apiKey: "a-key", wantStatus: http.StatusForbidden},
+		{name: "should reject invalid header", source: source, header: "team/b", wantStatus: http.StatusBadRequest},
+		{name: "should use header without policy", header: "team-b", apiKey: "a-key", wantStatus: http.StatusOK, wantTenant: "team-b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = tenant.FromContext(r.Context())
+			})
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tenant.Header, tt.header)
+			}
+			if tt.apiKey != "" {
+				r.Header.Set(access.APIKeyHeader, tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			TenantHandler(tt.source)(next).ServeHTTP(w, r)
+			require.Equal(t, tt.wantStatus, w.Code)
+			require.Equal(t, tt.wantTenant, got)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Labels are optional dimensions of metric, e.g. cpu="1"
+type Labels map[string]string
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// String renders labels sorted by name in Prometheus notation,
+// empty labels are rendered as empty string
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelValueEscaper.Replace(l[name]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// SeriesKey identifies series of metric name with labels
+func SeriesKey(name string, labels Labels) string {
+	return name + labels.String()
+}
+
+// SanitizeName replaces characters not allowed in metric names with
+// underscores, so names received by foreign protocols are valid
+func SanitizeName(name string) string {
+	return invalidNameChars.ReplaceAllString(strings.TrimSpace(name), "_")
+}
+
+// SanitizeLabelName is SanitizeName also prefixing names starting with
+// digit, empty name stays empty
+func SanitizeLabelName(name string) string {
+	name = SanitizeName(name)
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
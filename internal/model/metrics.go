@@ -22,6 +22,27 @@ type Metrics struct {
 	Delta   *int64   `json:"delta,omitempty"`
 	Value   *float64 `json:"value,omitempty"`
 	Hash    string   `json:"hash,omitempty"`
+	Labels  Labels   `json:"labels,omitempty"`
+	// Tenant owning series, set by storage from request context
+	Tenant string `json:"tenant,omitempty"`
+}
+
+func (m *Metrics) SeriesKey() string {
+	return SeriesKey(m.ID, m.Labels)
+}
+
+// StorageKey is unique across metric types and tenants
+func (m *Metrics) StorageKey() string {
+	return StorageKey(m.Tenant, m.MType, m.SeriesKey())
+}
+
+// StorageKey identifies series of metric type owned by tenant,
+// default tenant keys carry no tenant prefix
+func StorageKey(tenant, metricType, seriesKey string) string {
+	if tenant == "" {
+		return metricType + ":" + seriesKey
+	}
+	return tenant + "/" + metricType + ":" + seriesKey
 }
 
 func (m *Metrics) String() string {
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// Sample is a single timestamped value of metric series,
+// counters are stored as accumulated value
+type Sample struct {
+	Timestamp time.Time `json:"t"`
+	Value     float64   `json:"v"`
+}
+
+const (
+	AggAvg  = "avg"
+	AggMin  = "min"
+	AggMax  = "max"
+	AggLast = "last"
+)
+
+// RangeQuery describes history request, fields are kept raw
+// to share validation between query string and JSON inputs
+type RangeQuery struct {
+	ID     string `json:"id"`
+	MType  string `json:"type"`
+	Start  string `json:"start,omitempty"`
+	End    string `json:"end,omitempty"`
+	Step   string `json:"step,omitempty"`
+	Agg    string `json:"agg,omitempty"`
+	Labels Labels `json:"labels,omitempty"`
+}
+
+type RangeResult struct {
+	ID     string   `json:"id"`
+	MType  string   `json:"type"`
+	Labels Labels   `json:"labels,omitempty"`
+	Step   string   `json:"step,omitempty"`
+	Agg    string   `json:"agg,omitempty"`
+	Points []Sample `json:"points"`
+}
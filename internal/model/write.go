@@ -0,0 +1,15 @@
+package models
+
+// LineError explains why line of written batch was rejected,
+// lines are numbered from one
+type LineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// WriteResult reports batch of lines written at once,
+// accepted lines are stored even when other lines are rejected
+type WriteResult struct {
+	Accepted int         `json:"accepted"`
+	Errors   []LineError `json:"errors,omitempty"`
+}
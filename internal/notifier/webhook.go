@@ -0,0 +1,210 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/alert"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
+	"go.uber.org/zap"
+)
+
+const contentType = "application/json"
+
+type Config struct {
+	URLs           []string
+	Key            []byte
+	HeaderName     string
+	RepeatInterval time.Duration
+	Retry          utils.RetryPolicy
+	Client         *http.Client
+	Logger         *zap.Logger
+}
+
+// Payload is a group of alerts related to the same metric
+type Payload struct {
+	Metric string        `json:"metric"`
+	Status alert.State   `json:"status"`
+	Alerts []alert.Alert `json:"alerts"`
+}
+
+type deliveryError struct {
+	err       error
+	retriable bool
+}
+
+func (e *deliveryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *deliveryError) Unwrap() error {
+	return e.err
+}
+
+func (e *deliveryError) IsRetriable() bool {
+	return e.retriable
+}
+
+type notification struct {
+	state alert.State
+	at    time.Time
+}
+
+type webhookNotifier struct {
+	config *Config
+	mu     sync.Mutex
+	// notifications delivered to every URL by rule
+	sent map[string]map[string]notification
+	now  func() time.Time
+}
+
+func NewWebhookNotifier(cfg *Config) *webhookNotifier {
+	return &webhookNotifier{
+		config: cfg,
+		sent:   make(map[string]map[string]notification),
+		now:    time.Now,
+	}
+}
+
+// Notify delivers firing and resolved alerts which were not delivered yet,
+// firing alerts are repeated every RepeatInterval, every URL keeps its own
+// delivery state, so the failed one gets alerts on the next call
+func (n *webhookNotifier) Notify(ctx context.Context, alerts []alert.Alert) error {
+	now := n.now()
+	var errs []error
+	for _, url := range n.config.URLs {
+		if err := n.notifyURL(ctx, url, alerts, now); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// notifyURL sends alerts grouped by metric, lock is not held while sending
+func (n *webhookNotifier) notifyURL(ctx context.Context, url string, alerts []alert.Alert, now time.Time) error {
+	n.mu.Lock()
+	groups := make(map[string][]alert.Alert)
+	for _, a := range alerts {
+		if n.shouldSend(url, a, now) {
+			groups[a.Metric] = append(groups[a.Metric], a)
+		}
+	}
+	n.mu.Unlock()
+	metrics := make([]string, 0, len(groups))
+	for metric := range groups {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+	var errs []error
+	for _, metric := range metrics {
+		p := newPayload(metric, groups[metric])
+		if err := n.deliver(ctx, url, p); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n.markSent(url, p.Alerts, now)
+	}
+	return errors.Join(errs...)
+}
+
+// shouldSend reports whether alert is not delivered to URL yet,
+// caller must hold lock
+func (n *webhookNotifier) shouldSend(url string, a alert.Alert, now time.Time) bool {
+	last, exists := n.sent[url][a.Rule]
+	switch a.State {
+	case alert.StateFiring:
+		if !exists || last.state != alert.StateFiring {
+			return true
+		}
+		return n.config.RepeatInterval > 0 && now.Sub(last.at) >= n.config.RepeatInterval
+	case alert.StateResolved:
+		// resolved notification makes sense only for delivered firing alert
+		return exists && last.state == alert.StateFiring
+	}
+	return false
+}
+
+func (n *webhookNotifier) markSent(url string, alerts []alert.Alert, now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	sent, ok := n.sent[url]
+	if !ok {
+		sent = make(map[string]notification)
+		n.sent[url] = sent
+	}
+	for _, a := range alerts {
+		if a.State == alert.StateResolved {
+			delete(sent, a.Rule)
+			continue
+		}
+		sent[a.Rule] = notification{state: a.State, at: now}
+	}
+}
+
+// deliver posts payload to URL retrying until ctx is done
+func (n *webhookNotifier) deliver(ctx context.Context, url string, p *Payload) error {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	err = n.config.Retry.Do(ctx, func(ctx context.Context) error {
+		return n.post(ctx, url, body)
+	})
+	if err != nil {
+		n.config.Logger.Error("Failed to deliver alert notification",
+			zap.String("url", url),
+			zap.String("metric", p.Metric),
+			zap.Error(err),
+		)
+		return fmt.Errorf("%s: %w", url, err)
+	}
+	return nil
+}
+
+func (n *webhookNotifier) post(ctx context.Context, url string, body []byte) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", contentType)
+	if len(n.config.Key) > 0 {
+		r.Header.Set(n.config.HeaderName, utils.HashBody(n.config.Key, body))
+	}
+	resp, err := n.config.Client.Do(r)
+	if err != nil {
+		return &deliveryError{err: err, retriable: true}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return &deliveryError{
+			err:       fmt.Errorf("receiver responded with status: %s", resp.Status),
+			retriable: true,
+		}
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return &deliveryError{err: fmt.Errorf("receiver rejected notification: %s", resp.Status)}
+	}
+	return nil
+}
+
+func newPayload(metric string, alerts []alert.Alert) *Payload {
+	p := &Payload{
+		Metric: metric,
+		Status: alert.StateResolved,
+		Alerts: alerts,
+	}
+	for _, a := range alerts {
+		if a.State == alert.StateFiring {
+			p.Status = alert.StateFiring
+			break
+		}
+	}
+	return p
+}
@@ -0,0 +1,168 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/alert"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type receiver struct {
+	mu       sync.Mutex
+	payloads []Payload
+	failures atomic.Int32
+	key      []byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rc.failures.Load() > 0 {
+		rc.failures.Add(-1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	if !utils.IsHashValid([]byte(r.Header.Get("HashSHA256")), body, rc.key) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rc.mu.Lock()
+	rc.payloads = append(rc.payloads, p)
+	rc.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (rc *receiver) received() []Payload {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]Payload(nil), rc.payloads...)
+}
+
+func newTestNotifier(url string, key []byte) *webhookNotifier {
+	return NewWebhookNotifier(&Config{
+		URLs:           []string{url},
+		Key:            key,
+		HeaderName:     "HashSHA256",
+		RepeatInterval: time.Minute,
+		Retry:          utils.RetryPolicy{MaxAttempts: 3},
+		Client:         &http.Client{Timeout: time.Second},
+		Logger:         zap.NewNop(),
+	})
+}
+
+func TestWebhookNotifier_Notify(t *testing.T) {
+	key := []byte("secret")
+	rc := &receiver{key: key}
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+	n := newTestNotifier(ts.URL, key)
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	firing := []alert.Alert{
+		{Rule: "HighHeap", Metric: "HeapAlloc", State: alert.StateFiring},
+		{Rule: "VeryHighHeap", Metric: "HeapAlloc", State: alert.StateFiring},
+		{Rule: "AgentStalled", Metric: "PollCount", State: alert.StateFiring},
+	}
+	require.NoError(t, n.Notify(context.Background(), firing))
+	payloads := rc.received()
+	require.Len(t, payloads, 2, "alerts should be grouped by metric")
+	require.Equal(t, "HeapAlloc", payloads[0].Metric)
+	require.Len(t, payloads[0].Alerts, 2)
+	require.Equal(t, alert.StateFiring, payloads[0].Status)
+
+	now = now.Add(30 * time.Second)
+	require.NoError(t, n.Notify(context.Background(), firing))
+	require.Len(t, rc.received(), 2, "already delivered alerts should be deduplicated")
+
+	now = now.Add(time.Minute)
+	require.NoError(t, n.Notify(context.Background(), firing[2:]))
+	require.Len(t, rc.received(), 3, "firing alert should be repeated after interval")
+
+	resolved := []alert.Alert{
+		{Rule: "AgentStalled", Metric: "PollCount", State: alert.StateResolved},
+		{Rule: "NeverFired", Metric: "Other", State: alert.StateResolved},
+	}
+	require.NoError(t, n.Notify(context.Background(), resolved))
+	require.NoError(t, n.Notify(context.Background(), resolved))
+	payloads = rc.received()
+	require.Len(t, payloads, 4, "resolved alert should be delivered once and only if it was fired")
+	require.Equal(t, alert.StateResolved, payloads[3].Status)
+	require.Equal(t, "PollCount", payloads[3].Metric)
+}
+
+func TestWebhookNotifier_Retry(t *testing.T) {
+	key := []byte("secret")
+	rc := &receiver{key: key}
+	rc.failures.Store(1)
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+	n := newTestNotifier(ts.URL, key)
+
+	err := n.Notify(context.Background(), []alert.Alert{{Rule: "HighHeap", Metric: "HeapAlloc", State: alert.StateFiring}})
+	require.NoError(t, err)
+	require.Len(t, rc.received(), 1)
+}
+
+func TestWebhookNotifier_TracksEveryURL(t *testing.T) {
+	key := []byte("secret")
+	healthy := &receiver{key: key}
+	failing := &receiver{key: key}
+	// exhausts all attempts of the first notification
+	failing.failures.Store(3)
+	healthyServer := httptest.NewServer(healthy)
+	defer healthyServer.Close()
+	failingServer := httptest.NewServer(failing)
+	defer failingServer.Close()
+	n := newTestNotifier(healthyServer.URL, key)
+	n.config.URLs = append(n.config.URLs, failingServer.URL)
+
+	firing := []alert.Alert{{Rule: "HighHeap", Metric: "HeapAlloc", State: alert.StateFiring}}
+	require.Error(t, n.Notify(context.Background(), firing))
+	require.Len(t, healthy.received(), 1)
+	require.Empty(t, failing.received())
+
+	require.NoError(t, n.Notify(context.Background(), firing))
+	require.Len(t, healthy.received(), 1, "delivered alert should not be sent again")
+	require.Len(t, failing.received(), 1, "failed receiver should get alert on the next call")
+}
+
+func TestWebhookNotifier_Cancel(t *testing.T) {
+	key := []byte("secret")
+	rc := &receiver{key: key}
+	rc.failures.Store(100)
+	ts := httptest.NewServer(rc)
+	defer ts.Close()
+	n := newTestNotifier(ts.URL, key)
+	n.config.Retry = utils.RetryPolicy{MaxAttempts: 100, BaseDelay: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() {
+		errCh <- n.Notify(ctx, []alert.Alert{{Rule: "HighHeap", Metric: "HeapAlloc", State: alert.StateFiring}})
+	}()
+	require.Eventually(t, func() bool {
+		return rc.failures.Load() < 100
+	}, 5*time.Second, time.Millisecond)
+	cancel()
+	select {
+	case err := <-errCh:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification should stop retrying once context is cancelled")
+	}
+}
@@ -0,0 +1,56 @@
+package proto
+
+import (
+	"fmt"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+)
+
+// ToModels converts metrics of request, checking that every metric
+// carries value of its type
+func ToModels(metrics []*Metric) ([]models.Metrics, error) {
+	result := make([]models.Metrics, 0, len(metrics))
+	for _, m := range metrics {
+		metric := models.Metrics{
+			ID:     m.GetId(),
+			MType:  m.GetType(),
+			Labels: m.GetLabels(),
+		}
+		switch m.GetType() {
+		case models.Gauge:
+			if m.Value == nil {
+				return nil, fmt.Errorf("gauge %s has no value", m.GetId())
+			}
+			value := m.GetValue()
+			metric.Value = &value
+		case models.Counter:
+			if m.Delta == nil {
+				return nil, fmt.Errorf("counter %s has no delta", m.GetId())
+			}
+			delta := m.GetDelta()
+			metric.Delta = &delta
+		default:
+			return nil, fmt.Errorf("invalid metric type: %s", m.GetType())
+		}
+		result = append(result, metric)
+	}
+	return result, nil
+}
+
+func FromModel(m *models.Metrics) *Metric {
+	return &Metric{
+		Id:     m.ID,
+		Type:   m.MType,
+		Delta:  m.Delta,
+		Value:  m.Value,
+		Labels: m.Labels,
+	}
+}
+
+func FromModels(metrics []models.Metrics) []*Metric {
+	result := make([]*Metric, 0, len(metrics))
+	for i := range metrics {
+		result = append(result, FromModel(&metrics[i]))
+	}
+	return result
+}
@@ -0,0 +1,4 @@
+// Package proto holds gRPC API generated from proto/metrics.proto
+package proto
+
+//go:generate protoc -I ../../proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
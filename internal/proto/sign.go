@@ -0,0 +1,53 @@
+package proto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Signer accumulates signature of call messages, it is HMAC-SHA256 of
+// deterministic encodings of messages each prefixed by its length, so
+// both sides must be built from the same proto file
+type Signer struct {
+	hash hash.Hash
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{hash: hmac.New(sha256.New, key)}
+}
+
+// Add appends message sent or received by call
+func (s *Signer) Add(msg any) error {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return fmt.Errorf("unsupported message %T", msg)
+	}
+	b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	binary.Write(s.hash, binary.BigEndian, uint32(len(b)))
+	s.hash.Write(b)
+	return nil
+}
+
+func (s *Signer) Sum() []byte {
+	return s.hash.Sum(nil)
+}
+
+// Sign returns hex encoded signature of call sending msgs
+func Sign(key []byte, msgs ...proto.Message) (string, error) {
+	s := NewSigner(key)
+	for _, msg := range msgs {
+		if err := s.Add(msg); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(s.Sum()), nil
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+)
+
+// collapseBatch merges updates of the same series keeping order of first
+// appearance, counter deltas are summed and the last gauge value wins;
+// updates without value of their type are dropped
+func collapseBatch(batch []models.Metrics) []models.Metrics {
+	result := make([]models.Metrics, 0, len(batch))
+	index := make(map[string]int, len(batch))
+	for _, m := range batch {
+		if !hasValue(m) {
+			continue
+		}
+		key := m.StorageKey()
+		i, seen := index[key]
+		if !seen {
+			index[key] = len(result)
+			result = append(result, m)
+			continue
+		}
+		switch m.MType {
+		case models.Gauge:
+			result[i].Value = m.Value
+		case models.Counter:
+			delta := *result[i].Delta + *m.Delta
+			result[i].Delta = &delta
+		}
+	}
+	return result
+}
+
+// hasValue reports whether update carries value of its type, service
+// rejects other updates so storage only guards against misuse
+func hasValue(m models.Metrics) bool {
+	switch m.MType {
+	case models.Gauge:
+		return m.Value != nil
+	case models.Counter:
+		return m.Delta != nil
+	default:
+		return false
+	}
+}
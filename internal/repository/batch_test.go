@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_collapseBatch(t *testing.T) {
+	sda := models.Labels{"device": "sda"}
+	tests := []struct {
+		name  string
+		batch []models.Metrics
+		want  []models.Metrics
+	}{
+		{
+			name:  "should keep empty batch",
+			batch: []models.Metrics{},
+			want:  []models.Metrics{},
+		},
+		{
+			name: "should sum counters and keep last gauge",
+			batch: []models.Metrics{
+				counter("PollCount", 1),
+				gauge("Alloc", 1),
+				counter("PollCount", 2),
+				gauge("Alloc", 2),
+				counter("PollCount", 3),
+			},
+			want: []models.Metrics{
+				counter("PollCount", 6),
+				gauge("Alloc", 2),
+			},
+		},
+		{
+			name: "should keep series with different labels and types apart",
+			batch: []models.Metrics{
+				counter("reads", 1),
+				{ID: "reads", MType: models.Counter, Delta: int64Ptr(5), Labels: sda},
+				gauge("reads", 7),
+				counter("reads", 1),
+			},
+			want: []models.Metrics{
+				counter("reads", 2),
+				{ID: "reads", MType: models.Counter, Delta: int64Ptr(5), Labels: sda},
+				gauge("reads", 7),
+			},
+		},
+		{
+			name: "should drop updates without value",
+			batch: []models.Metrics{
+				counter("PollCount", 1),
+				{ID: "PollCount", MType: models.Counter},
+				{ID: "Alloc", MType: models.Gauge},
+			},
+			want: []models.Metrics{
+				counter("PollCount", 1),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, collapseBatch(tt.batch))
+		})
+	}
+}
+
+func Test_collapseBatch_KeepsInput(t *testing.T) {
+	batch := []models.Metrics{counter("PollCount", 1), counter("PollCount", 2)}
+	collapseBatch(batch)
+	require.Equal(t, int64(1), *batch[0].Delta)
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+var benchmarkBatchSizes = []int{10, 1000, 100000}
+
+// benchmarkBatch emulates agent reports: a quarter of updates are
+// repeated counters, the rest are distinct gauges
+func benchmarkBatch(size int) []models.Metrics {
+	batch := make([]models.Metrics, 0, size)
+	for i := 0; i < size; i++ {
+		if i%4 == 0 {
+			batch = append(batch, counter(fmt.Sprintf("counter_%d", i%100), 1))
+			continue
+		}
+		batch = append(batch, gauge(fmt.Sprintf("gauge_%d", i), float64(i)))
+	}
+	return batch
+}
+
+func BenchmarkCollapseBatch(b *testing.B) {
+	for _, size := range benchmarkBatchSizes {
+		batch := benchmarkBatch(size)
+		b.Run(fmt.Sprintf("batch=%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				collapseBatch(batch)
+			}
+			b.ReportMetric(float64(size*b.N)/b.Elapsed().Seconds(), "metrics/s")
+		})
+	}
+}
+
+func BenchmarkSetMetricBulk(b *testing.B) {
+	storages := map[string]func(b *testing.B) Storage{
+		"memory": func(b *testing.B) Storage {
+			return NewMemoryStorage()
+		},
+		"file": func(b *testing.B) Storage {
+			s, err := NewFileStorage(filepath.Join(b.TempDir(), "metrics.json"), false, time.Hour, zap.NewNop())
+			require.NoError(b, err)
+			return s
+		},
+		"postgres": func(b *testing.B) Storage {
+			return newTestPostgresStorage(b)
+		},
+	}
+	for name, newStorage := range storages {
+		for _, size := range benchmarkBatchSizes {
+			batch := benchmarkBatch(size)
+			b.Run(fmt.Sprintf("%s/batch=%d", name, size), func(b *testing.B) {
+				s := newStorage(b)
+				defer s.Close()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := s.SetMetricBulk(context.Background(), &batch); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(size*b.N)/b.Elapsed().Seconds(), "metrics/s")
+			})
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// consecutive connection failures opening the breaker
+	breakerThreshold = 3
+	// how often open breaker probes database
+	breakerProbeInterval = time.Second
+	// trial query of half-open breaker not recorded within the timeout,
+	// e.g. abandoned by caller, lets another one through
+	breakerTrialTimeout = 15 * time.Second
+)
+
+// ErrCircuitOpen is returned instead of querying unreachable database
+var ErrCircuitOpen = errors.New("database circuit breaker is open")
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerStatus is a snapshot of circuit breaker exposed to admins
+type BreakerStatus struct {
+	State     BreakerState `json:"state"`
+	Failures  int          `json:"failures"`
+	ChangedAt time.Time    `json:"changedAt"`
+}
+
+// BreakerReporter is implemented by storages guarded by circuit breaker
+type BreakerReporter interface {
+	BreakerStatus() BreakerStatus
+}
+
+// circuitBreaker stops sending queries to database once it looks unreachable,
+// open breaker is probed in background and becomes half-open when probe
+// succeeds, then a single trial query decides whether it closes or opens
+// again while other queries are still held back
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	changedAt time.Time
+	// start of trial query in flight, zero when there is none
+	trialAt   time.Time
+	threshold int
+	interval  time.Duration
+	ping      func(ctx context.Context) error
+	logger    *zap.Logger
+	now       func() time.Time
+}
+
+func newCircuitBreaker(
+	threshold int,
+	interval time.Duration,
+	ping func(ctx context.Context) error,
+	logger *zap.Logger,
+) *circuitBreaker {
+	return &circuitBreaker{
+		state:     BreakerClosed,
+		changedAt: time.Now(),
+		threshold: threshold,
+		interval:  interval,
+		ping:      ping,
+		logger:    logger,
+		now:       time.Now,
+	}
+}
+
+func (b *circuitBreaker) run(stopCh chan struct{}, doneCh chan struct{}) {
+	defer close(doneCh)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.probe(context.Background())
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// allow reports whether query may be sent to database, half-open
+// breaker lets through one trial query at a time
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		now := b.now()
+		if !b.trialAt.IsZero() && now.Sub(b.trialAt) < breakerTrialTimeout {
+			return false
+		}
+		b.trialAt = now
+	}
+	return true
+}
+
+// record accounts result of query sent on behalf of caller with ctx,
+// only connection errors and timeouts of storage itself are failures,
+// queries abandoned by caller or exceeding its deadline prove nothing
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialAt = time.Time{}
+	if err != nil && (ctx.Err() != nil || errors.Is(err, context.Canceled)) {
+		return
+	}
+	if err == nil || !isConnectionError(err) {
+		b.failures = 0
+		b.setState(BreakerClosed)
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.setState(BreakerOpen)
+	}
+}
+
+// probe pings database while breaker is open
+func (b *circuitBreaker) probe(ctx context.Context) {
+	b.mu.Lock()
+	state := b.state
+	b.mu.Unlock()
+	if state != BreakerOpen {
+		return
+	}
+	if err := b.ping(ctx); err != nil {
+		b.logger.Debug("Database probe failed", zap.Error(err))
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen {
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+// setState switches state, caller must hold lock
+func (b *circuitBreaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+	b.trialAt = time.Time{}
+	b.logger.Warn("Database circuit breaker state changed",
+		zap.String("from", string(b.state)),
+		zap.String("to", string(state)),
+		zap.Int("failures", b.failures),
+	)
+	b.state = state
+	b.changedAt = b.now()
+}
+
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return BreakerStatus{
+		State:     b.state,
+		Failures:  b.failures,
+		ChangedAt: b.changedAt,
+	}
+}
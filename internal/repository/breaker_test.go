@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func Test_circuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{}
+	b := newCircuitBreaker(3, time.Hour, db.ping, zap.NewNop())
+
+	// rejected queries do not mean database is unreachable
+	b.record(ctx, &pq.Error{Code: "23505"})
+	b.record(ctx, driver.ErrBadConn)
+	b.record(ctx, driver.ErrBadConn)
+	require.True(t, b.allow())
+	require.Equal(t, BreakerClosed, b.status().State)
+
+	b.record(ctx, &pq.Error{Code: "08006"})
+	require.False(t, b.allow(), "breaker should open after threshold")
+	require.Equal(t, BreakerOpen, b.status().State)
+	require.Equal(t, 3, b.status().Failures)
+
+	db.down = true
+	b.probe(context.Background())
+	require.Equal(t, BreakerOpen, b.status().State, "failed probe should keep breaker open")
+
+	db.down = false
+	b.probe(context.Background())
+	require.Equal(t, BreakerHalfOpen, b.status().State)
+	require.True(t, b.allow())
+	require.False(t, b.allow(), "half-open breaker should let through single trial query")
+
+	b.record(ctx, driver.ErrBadConn)
+	require.Equal(t, BreakerOpen, b.status().State, "half-open breaker should reopen on first failure")
+
+	b.probe(context.Background())
+	require.True(t, b.allow())
+	b.record(ctx, nil)
+	require.Equal(t, BreakerClosed, b.status().State)
+	require.Zero(t, b.status().Failures)
+}
+
+func Test_circuitBreaker_ignoresCaller(t *testing.T) {
+	b := newCircuitBreaker(1, time.Hour, nil, zap.NewNop())
+	expired, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	b.record(expired, context.DeadlineExceeded)
+	require.Equal(t, BreakerClosed, b.status().State, "deadline of caller should not open breaker")
+
+	b.record(context.Background(), context.DeadlineExceeded)
+	require.Equal(t, BreakerOpen, b.status().State, "timeout of storage should open breaker")
+	b.record(context.Background(), context.Canceled)
+	require.Equal(t, BreakerOpen, b.status().State, "abandoned query should not close breaker")
+}
+
+func Test_circuitBreaker_trialTimeout(t *testing.T) {
+	db := &fakeDB{}
+	b := newCircuitBreaker(1, time.Hour, db.ping, zap.NewNop())
+	now := time.Now()
+	b.now = func() time.Time { return now }
+	b.record(context.Background(), driver.ErrBadConn)
+	b.probe(context.Background())
+	require.True(t, b.allow())
+	require.False(t, b.allow())
+	now = now.Add(breakerTrialTimeout)
+	require.True(t, b.allow(), "unrecorded trial query should expire")
+}
@@ -0,0 +1,252 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"go.uber.org/zap"
+)
+
+// WAL is compacted into snapshot when it grows over this size
+// even if store interval has not elapsed yet
+const walCompactSize = 4 << 20
+
+// snapshot is compacted state of storage, Seq is the last WAL record
+// included, so records up to Seq are skipped on recovery
+type snapshot struct {
+	Seq     uint64           `json:"seq"`
+	Metrics []models.Metrics `json:"metrics"`
+}
+
+// fileStorage keeps metrics in memory, every update is appended to WAL
+// which is periodically compacted into snapshot file
+type fileStorage struct {
+	*memoryStorage
+	filePath      string
+	writeInterval time.Duration
+	logger        *zap.Logger
+	wal           *writeAheadLog
+	// serializes WAL appends with compaction
+	walMu  sync.Mutex
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func NewFileStorage(
+	filePath string,
+	isRestoreNeeded bool,
+	writeInterval time.Duration,
+	logger *zap.Logger,
+) (*fileStorage, error) {
+	wal, err := openWAL(filePath + ".wal")
+	if err != nil {
+		return nil, err
+	}
+	s := &fileStorage{
+		memoryStorage: NewMemoryStorage(),
+		filePath:      filePath,
+		writeInterval: writeInterval,
+		logger:        logger,
+		wal:           wal,
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+	}
+	if err := s.recover(isRestoreNeeded); err != nil {
+		wal.close()
+		return nil, err
+	}
+	if writeInterval > 0 {
+		go s.run()
+	} else {
+		close(s.doneCh)
+	}
+	return s, nil
+}
+
+// recover loads snapshot and replays WAL on top of it, without restore
+// stored data is only scanned to continue WAL sequence
+func (s *fileStorage) recover(isRestoreNeeded bool) error {
+	snap, err := s.readSnapshot()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.logger.Error("Failed to read metrics snapshot", zap.Error(err))
+	}
+	if snap == nil {
+		snap = &snapshot{}
+	}
+	if !isRestoreNeeded {
+		if _, err := s.wal.replay(snap.Seq, func([]models.Metrics) {}); err != nil {
+			return err
+		}
+		return s.wal.reset()
+	}
+	s.restore(snap.Metrics)
+	applied, err := s.wal.replay(snap.Seq, func(updates []models.Metrics) {
+		s.memoryStorage.apply(updates...)
+	})
+	if err != nil {
+		return err
+	}
+	s.logger.Info("Metrics restored",
+		zap.Int("snapshotMetrics", len(snap.Metrics)),
+		zap.Int("walRecords", applied),
+	)
+	return nil
+}
+
+func (s *fileStorage) run() {
+	defer close(s.doneCh)
+	ticker := time.NewTicker(s.writeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.walMu.Lock()
+			if err := s.compact(); err != nil {
+				s.logger.Error("Failed to compact metrics WAL", zap.Error(err))
+			}
+			s.walMu.Unlock()
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *fileStorage) SetGauge(ctx context.Context, name string, labels models.Labels, value float64) error {
+	return s.SetMetricBulk(ctx, &[]models.Metrics{{
+		ID:     name,
+		MType:  models.Gauge,
+		Value:  &value,
+		Labels: labels,
+	}})
+}
+
+func (s *fileStorage) SetCounter(ctx context.Context, name string, labels models.Labels, delta int64) error {
+	return s.SetMetricBulk(ctx, &[]models.Metrics{{
+		ID:     name,
+		MType:  models.Counter,
+		Delta:  &delta,
+		Labels: labels,
+	}})
+}
+
+// SetMetricBulk logs updates as one WAL record before applying them,
+// with zero store interval record is synced to disk before reply
+func (s *fileStorage) SetMetricBulk(ctx context.Context, m *[]models.Metrics) error {
+	updates := withTenant(ctx, *m)
+	s.walMu.Lock()
+	defer s.walMu.Unlock()
+	if err := s.wal.append(updates, s.writeInterval == 0); err != nil {
+		return err
+	}
+	s.memoryStorage.apply(updates...)
+	if s.wal.size >= walCompactSize {
+		if err := s.compact(); err != nil {
+			s.logger.Error("Failed to compact metrics WAL", zap.Error(err))
+		}
+	}
+	return nil
+}
+
+// Close stops periodic compaction and writes the final snapshot
+func (s *fileStorage) Close() error {
+	select {
+	case <-s.stopCh:
+		return nil
+	default:
+		close(s.stopCh)
+	}
+	<-s.doneCh
+	s.walMu.Lock()
+	defer s.walMu.Unlock()
+	if err := s.compact(); err != nil {
+		s.wal.close()
+		return err
+	}
+	return s.wal.close()
+}
+
+// compact writes snapshot and truncates WAL, caller must hold walMu
+func (s *fileStorage) compact() error {
+	if s.wal.size == 0 {
+		return nil
+	}
+	if err := s.wal.sync(); err != nil {
+		return err
+	}
+	snap := snapshot{
+		Seq:     s.wal.seq,
+		Metrics: s.memoryStorage.snapshot(),
+	}
+	if err := s.writeSnapshot(&snap); err != nil {
+		return err
+	}
+	return s.wal.reset()
+}
+
+// writeSnapshot atomically replaces snapshot file via temp file and rename
+func (s *fileStorage) writeSnapshot(snap *snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(s.filePath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.filePath); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes rename durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// readSnapshot reads snapshot, plain JSON array written by previous
+// versions is accepted as well
+func (s *fileStorage) readSnapshot() (*snapshot, error) {
+	data, err := os.ReadFile(s.filePath)
+	if err != nil {
+		return nil, err
+	}
+	var snap snapshot
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &snap.Metrics)
+	} else {
+		err = json.Unmarshal(data, &snap)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
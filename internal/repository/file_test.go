@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newTestFileStorage(t *testing.T, path string, restore bool, interval time.Duration) *fileStorage {
+	t.Helper()
+	s, err := NewFileStorage(path, restore, interval, zap.NewNop())
+	require.NoError(t, err)
+	return s
+}
+
+// crash simulates process exit without final snapshot
+func crash(t *testing.T, s *fileStorage) {
+	t.Helper()
+	close(s.stopCh)
+	<-s.doneCh
+	require.NoError(t, s.wal.close())
+}
+
+func fillStorage(t *testing.T, s Storage) {
+	t.Helper()
+	require.NoError(t, s.SetGauge(context.Background(), "Alloc", models.Labels{"host": "a"}, 1.5))
+	require.NoError(t, s.SetCounter(context.Background(), "PollCount", nil, 3))
+	require.NoError(t, s.SetCounter(context.Background(), "PollCount", nil, 4))
+	require.NoError(t, s.SetCounter(tenant.WithID(context.Background(), "team-a"), "PollCount", nil, 9))
+}
+
+func TestFileStorage_Restore(t *testing.T) {
+	tests := []struct {
+		name  string
+		close func(t *testing.T, s *fileStorage)
+	}{
+		{
+			name: "should restore from snapshot after graceful shutdown",
+			close: func(t *testing.T, s *fileStorage) {
+				require.NoError(t, s.Close())
+				info, err := os.Stat(s.filePath + ".wal")
+				require.NoError(t, err)
+				require.Zero(t, info.Size(), "WAL should be compacted")
+			},
+		},
+		{
+			name:  "should replay WAL after crash",
+			close: crash,
+		},
+		{
+			name: "should not apply WAL twice after crash during compaction",
+			close: func(t *testing.T, s *fileStorage) {
+				// snapshot is written but WAL is not truncated yet
+				require.NoError(t, s.writeSnapshot(&snapshot{
+					Seq:     s.wal.seq,
+					Metrics: s.snapshot(),
+				}))
+				crash(t, s)
+			},
+		},
+		{
+			name: "should skip torn tail record",
+			close: func(t *testing.T, s *fileStorage) {
+				require.NoError(t, s.SetGauge(context.Background(), "Torn", nil, 1))
+				crash(t, s)
+				path := s.filePath + ".wal"
+				info, err := os.Stat(path)
+				require.NoError(t, err)
+				require.NoError(t, os.Truncate(path, info.Size()-5))
+			},
+		},
+		{
+			name: "should skip record with valid checksum but invalid payload",
+			close: func(t *testing.T, s *fileStorage) {
+				seq := s.wal.seq
+				crash(t, s)
+				f, err := os.OpenFile(s.filePath+".wal", os.O_APPEND|os.O_WRONLY, 0666)
+				require.NoError(t, err)
+				defer f.Close()
+				_, err = f.Write(encodeWALRecord(seq+1, []byte(`{"id":`)))
+				require.NoError(t, err)
+				_, err = f.Write(encodeWALRecord(seq+2, []byte(`[{"id":"After","type":"gauge","value":1}]`)))
+				require.NoError(t, err)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "metrics.json")
+			s := newTestFileStorage(t, path, false, time.Hour)
+			fillStorage(t, s)
+			teamA := tenant.WithID(context.Background(), "team-a")
+			want := s.GetAllMetrics(context.Background())
+			wantTeamA := s.GetAllMetrics(teamA)
+			tt.close(t, s)
+
+			restored := newTestFileStorage(t, path, true, time.Hour)
+			defer restored.Close()
+			require.Equal(t, want, restored.GetAllMetrics(context.Background()))
+			require.Equal(t, wantTeamA, restored.GetAllMetrics(teamA), "tenants should be restored")
+
+			// log stays writable after recovery
+			require.NoError(t, restored.SetCounter(context.Background(), "PollCount", nil, 1))
+			crash(t, restored)
+			restored = newTestFileStorage(t, path, true, time.Hour)
+			defer restored.Close()
+			m, found := restored.GetMetric(context.Background(), "PollCount", models.Counter, nil)
+			require.True(t, found)
+			require.Equal(t, int64(8), *m.Delta)
+		})
+	}
+}
+
+func TestFileStorage_RestoreLegacySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	legacy := `[{"id":"PollCount","type":"counter","delta":5},{"id":"Alloc","type":"gauge","value":2.5}]`
+	require.NoError(t, os.WriteFile(path, []byte(legacy), 0666))
+	s := newTestFileStorage(t, path, true, 0)
+	defer s.Close()
+	m, found := s.GetMetric(context.Background(), "PollCount", models.Counter, nil)
+	require.True(t, found)
+	require.Equal(t, int64(5), *m.Delta)
+	m, found = s.GetMetric(context.Background(), "Alloc", models.Gauge, nil)
+	require.True(t, found)
+	require.Equal(t, 2.5, *m.Value)
+}
+
+func TestFileStorage_CompactsLargeWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s := newTestFileStorage(t, path, false, time.Hour)
+	defer s.Close()
+	for s.wal.seq == 0 || s.wal.size > 0 {
+		require.NoError(t, s.SetCounter(context.Background(), "PollCount", models.Labels{"padding": strings.Repeat("x", 4096)}, 1))
+	}
+	snap, err := s.readSnapshot()
+	require.NoError(t, err)
+	require.Equal(t, s.wal.seq, snap.Seq)
+}
+
+func TestFileStorage_CreatesMissingDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tmp", "nested", "metrics.json")
+	s := newTestFileStorage(t, path, true, time.Hour)
+	require.NoError(t, s.SetCounter(context.Background(), "PollCount", nil, 2))
+	require.NoError(t, s.Close())
+	_, err := os.Stat(path)
+	require.NoError(t, err, "snapshot should be written")
+}
+
+func TestFileStorage_RejectsBatchOverRecordLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	s := newTestFileStorage(t, path, false, time.Hour)
+	s.wal.maxRecordLen = 64
+	value := 1.0
+	batch := make([]models.Metrics, 0, 10)
+	for i := 0; i < 10; i++ {
+		batch = append(batch, models.Metrics{ID: "Alloc", MType: models.Gauge, Value: &value})
+	}
+	require.ErrorIs(t, s.SetMetricBulk(context.Background(), &batch), ErrBatchTooLarge)
+	_, found := s.GetMetric(context.Background(), "Alloc", models.Gauge, nil)
+	require.False(t, found, "rejected batch should not be applied")
+
+	require.NoError(t, s.SetGauge(context.Background(), "Alloc", nil, 1))
+	crash(t, s)
+	restored := newTestFileStorage(t, path, true, time.Hour)
+	defer restored.Close()
+	_, found = restored.GetMetric(context.Background(), "Alloc", models.Gauge, nil)
+	require.True(t, found, "records within limit should be replayed")
+}
@@ -0,0 +1,165 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+)
+
+type memoryStorage struct {
+	metrics map[string]models.Metrics
+	history map[string]*sampleRing
+	// limit of series with history
+	maxHistorySeries int
+	mu               sync.RWMutex
+}
+
+func NewMemoryStorage() *memoryStorage {
+	return &memoryStorage{
+		metrics:          make(map[string]models.Metrics),
+		history:          make(map[string]*sampleRing),
+		maxHistorySeries: maxHistorySeries,
+	}
+}
+
+func (s *memoryStorage) SetGauge(ctx context.Context, name string, labels models.Labels, value float64) error {
+	s.apply(models.Metrics{
+		ID:     name,
+		MType:  models.Gauge,
+		Value:  &value,
+		Labels: labels,
+		Tenant: tenant.FromContext(ctx),
+	})
+	return nil
+}
+
+func (s *memoryStorage) SetCounter(ctx context.Context, name string, labels models.Labels, delta int64) error {
+	s.apply(models.Metrics{
+		ID:     name,
+		MType:  models.Counter,
+		Delta:  &delta,
+		Labels: labels,
+		Tenant: tenant.FromContext(ctx),
+	})
+	return nil
+}
+
+// apply stores updates on behalf of tenants they carry,
+// updates without value are skipped
+func (s *memoryStorage) apply(updates ...models.Metrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range updates {
+		if !hasValue(m) {
+			continue
+		}
+		key := m.StorageKey()
+		switch m.MType {
+		case models.Gauge:
+			value := *m.Value
+			m.Value = &value
+			s.metrics[key] = m
+			s.recordSample(key, value)
+		case models.Counter:
+			delta := *m.Delta
+			if existing, ok := s.metrics[key]; ok && existing.Delta != nil {
+				delta += *existing.Delta
+			}
+			m.Delta = &delta
+			s.metrics[key] = m
+			s.recordSample(key, float64(delta))
+		}
+	}
+}
+
+func (s *memoryStorage) GetMetric(ctx context.Context, name string, metricType string, labels models.Labels) (*models.Metrics, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	m, exists := s.metrics[models.StorageKey(tenant.FromContext(ctx), metricType, models.SeriesKey(name, labels))]
+	if !exists {
+		return nil, false
+	}
+	return &m, true
+}
+
+// recordSample appends value to series history, series over limit
+// have no history, caller must hold write lock
+func (s *memoryStorage) recordSample(key string, value float64) {
+	ring, exists := s.history[key]
+	if !exists {
+		if len(s.history) >= s.maxHistorySeries {
+			return
+		}
+		ring = newSampleRing(historySize)
+		s.history[key] = ring
+	}
+	ring.push(models.Sample{Timestamp: time.Now(), Value: value})
+}
+
+// GetHistory returns samples of series within [from, to] in chronological order
+func (s *memoryStorage) GetHistory(
+	ctx context.Context,
+	name string,
+	metricType string,
+	labels models.Labels,
+	from time.Time,
+	to time.Time,
+) ([]models.Sample, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ring, exists := s.history[models.StorageKey(tenant.FromContext(ctx), metricType, models.SeriesKey(name, labels))]
+	if !exists {
+		return []models.Sample{}, nil
+	}
+	return ring.between(from, to), nil
+}
+
+// GetAllMetrics returns metrics of ctx tenant only
+func (s *memoryStorage) GetAllMetrics(ctx context.Context) map[string]models.Metrics {
+	id := tenant.FromContext(ctx)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make(map[string]models.Metrics)
+	for key, m := range s.metrics {
+		if m.Tenant == id {
+			result[key] = m
+		}
+	}
+	return result
+}
+
+// snapshot returns metrics of all tenants
+func (s *memoryStorage) snapshot() []models.Metrics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result := make([]models.Metrics, 0, len(s.metrics))
+	for _, m := range s.metrics {
+		result = append(result, m)
+	}
+	return result
+}
+
+func (s *memoryStorage) SetMetricBulk(ctx context.Context, m *[]models.Metrics) error {
+	s.apply(withTenant(ctx, *m)...)
+	return nil
+}
+
+// restore replaces stored metrics without recording history
+func (s *memoryStorage) restore(metrics []models.Metrics) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range metrics {
+		s.metrics[m.StorageKey()] = m
+	}
+}
+
+func (s *memoryStorage) Ping(_ context.Context) error {
+	return nil
+}
+
+func (s *memoryStorage) Close() error {
+	return nil
+}
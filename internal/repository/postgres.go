@@ -0,0 +1,475 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/driver"
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const migrationsSource = "file://migrations"
+
+// interval of deleting samples out of retention window
+const historyPruneInterval = 5 * time.Minute
+
+// upsertQuery writes collapsed batch passed as parallel arrays,
+// every upserted row is recorded to history as well
+const upsertQuery = `
+	WITH input AS (
+		SELECT * FROM unnest(
+			$1::varchar[], $2::int[], $3::jsonb[], $4::varchar[], $5::bigint[], $6::double precision[], $7::varchar[]
+		) AS t(id, metric_type_id, labels, series_key, delta, value, tenant_id)
+	), upserted AS (
+		INSERT INTO
+		metrics
+			(id, metric_type_id, labels, series_key, delta, value, tenant_id)
+		SELECT id, metric_type_id, labels, series_key, delta, value, tenant_id FROM input
+		ON CONFLICT (tenant_id, series_key, metric_type_id)
+		DO UPDATE SET
+			delta = CASE
+				WHEN EXCLUDED.delta IS NULL THEN metrics.delta
+				ELSE COALESCE(metrics.delta, 0) + EXCLUDED.delta
+			END,
+			value = COALESCE(EXCLUDED.value, metrics.value),
+			updated_at = NOW()
+		RETURNING id, metric_type_id, labels, series_key, value, delta, tenant_id
+	)
+	INSERT INTO
+	metric_samples
+		(id, metric_type_id, labels, series_key, value, delta, tenant_id)
+	SELECT id, metric_type_id, labels, series_key, value, delta, tenant_id FROM upserted;
+`
+
+type postgresStorage struct {
+	driver        *driver.SQLDriver
+	logger        *zap.Logger
+	timeouts      Timeouts
+	reconciler    *reconciler
+	breaker       *circuitBreaker
+	upsertStmt    *sql.Stmt
+	stopCh        chan struct{}
+	doneCh        chan struct{}
+	breakerDoneCh chan struct{}
+	pruneDoneCh   chan struct{}
+	gaugeTypeID   uint
+	counterTypeID uint
+	metricTypes   map[uint]string
+}
+
+func NewPostgresStorage(d *driver.SQLDriver, timeouts Timeouts, logger *zap.Logger) (*postgresStorage, error) {
+	s := &postgresStorage{
+		driver:        d,
+		logger:        logger,
+		timeouts:      timeouts.withDefaults(),
+		stopCh:        make(chan struct{}),
+		doneCh:        make(chan struct{}),
+		breakerDoneCh: make(chan struct{}),
+		pruneDoneCh:   make(chan struct{}),
+	}
+	if err := s.initDBSchema(migrationsSource); err != nil {
+		return nil, err
+	}
+	if err := s.cacheMetricTypeIDs(); err != nil {
+		return nil, err
+	}
+	stmt, err := d.DB.Prepare(upsertQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare upsert statement: %w", err)
+	}
+	s.upsertStmt = stmt
+	s.reconciler = newReconciler(reconcileInterval, s.Ping, s.upsertBatch, logger)
+	s.breaker = newCircuitBreaker(breakerThreshold, breakerProbeInterval, s.Ping, logger)
+	go s.reconciler.run(s.stopCh, s.doneCh)
+	go s.breaker.run(s.stopCh, s.breakerDoneCh)
+	go s.pruneHistory(s.stopCh, s.pruneDoneCh)
+	return s, nil
+}
+
+// pruneHistory deletes samples older than retention window on schedule
+func (s *postgresStorage) pruneHistory(stopCh chan struct{}, doneCh chan struct{}) {
+	defer close(doneCh)
+	ticker := time.NewTicker(historyPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.deleteSamplesBefore(context.Background(), time.Now().Add(-historyRetention)); err != nil {
+				s.logger.Warn("Failed to prune metric history", zap.Error(err))
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (s *postgresStorage) deleteSamplesBefore(ctx context.Context, before time.Time) error {
+	if !s.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+	result, err := s.driver.DB.ExecContext(queryCtx, "DELETE FROM metric_samples WHERE created_at < $1;", before)
+	s.breaker.record(ctx, err)
+	if err != nil {
+		return wrapPgError(err)
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted > 0 {
+		s.logger.Debug("Pruned metric history", zap.Int64("samples", deleted))
+	}
+	return nil
+}
+
+func (s *postgresStorage) typeID(metricType string) (uint, bool) {
+	switch metricType {
+	case models.Gauge:
+		return s.gaugeTypeID, true
+	case models.Counter:
+		return s.counterTypeID, true
+	}
+	return 0, false
+}
+
+func (s *postgresStorage) SetGauge(ctx context.Context, name string, labels models.Labels, value float64) error {
+	return s.SetMetricBulk(ctx, &[]models.Metrics{{
+		ID:     name,
+		MType:  models.Gauge,
+		Value:  &value,
+		Labels: labels,
+	}})
+}
+
+func (s *postgresStorage) SetCounter(ctx context.Context, name string, labels models.Labels, delta int64) error {
+	return s.SetMetricBulk(ctx, &[]models.Metrics{{
+		ID:     name,
+		MType:  models.Counter,
+		Delta:  &delta,
+		Labels: labels,
+	}})
+}
+
+// PendingUpdates returns number of series buffered while database is unreachable
+func (s *postgresStorage) PendingUpdates() int {
+	return s.reconciler.pending()
+}
+
+func (s *postgresStorage) BreakerStatus() BreakerStatus {
+	return s.breaker.status()
+}
+
+func (s *postgresStorage) GetMetric(ctx context.Context, name string, metricType string, labels models.Labels) (*models.Metrics, bool) {
+	typeID, ok := s.typeID(metricType)
+	if !ok {
+		return nil, false
+	}
+	id := tenant.FromContext(ctx)
+	key := models.StorageKey(id, metricType, models.SeriesKey(name, labels))
+	if !s.breaker.allow() {
+		return s.reconciler.buffered(key)
+	}
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+	query := `
+		SELECT id, metric_type_id, delta, value, labels, tenant_id FROM metrics
+		WHERE tenant_id=$1 AND series_key=$2 AND metric_type_id=$3 LIMIT 1;
+	`
+	row := s.driver.DB.QueryRowContext(queryCtx, query, id, models.SeriesKey(name, labels), typeID)
+	metric, err := s.scanMetric(row)
+	s.breaker.record(ctx, err)
+	if err == sql.ErrNoRows {
+		return s.reconciler.buffered(key)
+	}
+	if err != nil {
+		s.logger.Error("Error reading metric from DB", zap.Error(err))
+		return nil, false
+	}
+	s.reconciler.overlay(metric)
+	return metric, true
+}
+
+func (s *postgresStorage) GetAllMetrics(ctx context.Context) map[string]models.Metrics {
+	result, err := s.loadAllMetrics(ctx)
+	if err != nil && !errors.Is(err, ErrCircuitOpen) {
+		s.logger.Error("Error reading metrics from DB", zap.Error(err))
+	}
+	return result
+}
+
+// loadAllMetrics returns metrics of tenant, on failure result holds
+// buffered and already read metrics only
+func (s *postgresStorage) loadAllMetrics(ctx context.Context) (map[string]models.Metrics, error) {
+	id := tenant.FromContext(ctx)
+	result := make(map[string]models.Metrics)
+	if !s.breaker.allow() {
+		s.reconciler.addMissing(id, result)
+		return result, ErrCircuitOpen
+	}
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+	query := "SELECT id, metric_type_id, delta, value, labels, tenant_id FROM metrics WHERE tenant_id=$1;"
+	rows, err := s.driver.DB.QueryContext(queryCtx, query, id)
+	s.breaker.record(ctx, err)
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		metric, err := s.scanMetric(rows)
+		if err != nil {
+			return result, fmt.Errorf("failed to scan metric: %w", err)
+		}
+		s.reconciler.overlay(metric)
+		result[metric.StorageKey()] = *metric
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+	s.reconciler.addMissing(id, result)
+	return result, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func (s *postgresStorage) scanMetric(row rowScanner) (*models.Metrics, error) {
+	var result models.Metrics
+	var labels []byte
+	if err := row.Scan(&result.ID, &result.MTypeID, &result.Delta, &result.Value, &labels, &result.Tenant); err != nil {
+		return nil, err
+	}
+	if err := decodeLabels(labels, &result); err != nil {
+		return nil, err
+	}
+	result.MType = s.metricTypes[result.MTypeID]
+	return &result, nil
+}
+
+// GetHistory returns samples of series within [from, to] in chronological
+// order, at most historySize latest ones as memory ring does
+func (s *postgresStorage) GetHistory(
+	ctx context.Context,
+	name string,
+	metricType string,
+	labels models.Labels,
+	from time.Time,
+	to time.Time,
+) ([]models.Sample, error) {
+	typeID, ok := s.typeID(metricType)
+	if !ok {
+		return nil, fmt.Errorf("unknown metric type: %s", metricType)
+	}
+	if !s.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+	query := `
+		SELECT
+			created_at, value, delta
+		FROM (
+			SELECT
+				created_at, value, delta
+			FROM
+				metric_samples
+			WHERE
+				tenant_id = $1 AND series_key = $2 AND metric_type_id = $3 AND created_at BETWEEN $4 AND $5
+			ORDER BY
+				created_at DESC
+			LIMIT $6
+		) AS latest
+		ORDER BY
+			created_at;
+	`
+	rows, err := s.driver.DB.QueryContext(queryCtx, query,
+		tenant.FromContext(ctx), models.SeriesKey(name, labels), typeID, from, to, historySize,
+	)
+	s.breaker.record(ctx, err)
+	if err != nil {
+		return nil, wrapPgError(err)
+	}
+	defer rows.Close()
+	samples := make([]models.Sample, 0)
+	for rows.Next() {
+		var sample models.Sample
+		var value sql.NullFloat64
+		var delta sql.NullInt64
+		if err := rows.Scan(&sample.Timestamp, &value, &delta); err != nil {
+			return nil, err
+		}
+		if delta.Valid {
+			sample.Value = float64(delta.Int64)
+		} else {
+			sample.Value = value.Float64
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// SetMetricBulk buffers updates right away while breaker is open
+func (s *postgresStorage) SetMetricBulk(ctx context.Context, m *[]models.Metrics) error {
+	updates := withTenant(ctx, *m)
+	if !s.breaker.allow() {
+		s.reconciler.add(updates...)
+		return nil
+	}
+	return wrapPgError(s.reconciler.submit(ctx, updates, s.upsertBatch))
+}
+
+// upsertBatch collapses updates and writes them with a single statement,
+// updates are written on behalf of tenants they carry
+func (s *postgresStorage) upsertBatch(ctx context.Context, m []models.Metrics) error {
+	batch := collapseBatch(m)
+	if len(batch) == 0 {
+		return nil
+	}
+	size := len(batch)
+	ids := make([]string, size)
+	typeIDs := make([]int64, size)
+	labels := make([]string, size)
+	seriesKeys := make([]string, size)
+	deltas := make([]sql.NullInt64, size)
+	values := make([]sql.NullFloat64, size)
+	tenants := make([]string, size)
+	for i, metric := range batch {
+		typeID, ok := s.typeID(metric.MType)
+		if !ok {
+			return fmt.Errorf("unknown metric type: %s", metric.MType)
+		}
+		encoded, err := encodeLabels(metric.Labels)
+		if err != nil {
+			return err
+		}
+		ids[i] = metric.ID
+		typeIDs[i] = int64(typeID)
+		labels[i] = encoded
+		seriesKeys[i] = metric.SeriesKey()
+		tenants[i] = metric.Tenant
+		if metric.Delta != nil {
+			deltas[i] = sql.NullInt64{Int64: *metric.Delta, Valid: true}
+		}
+		if metric.Value != nil {
+			values[i] = sql.NullFloat64{Float64: *metric.Value, Valid: true}
+		}
+	}
+	s.logger.Debug("Upserting metrics to DB", zap.Int("received", len(m)), zap.Int("series", size))
+	queryCtx, cancel := context.WithTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+	_, err := s.upsertStmt.ExecContext(queryCtx,
+		pq.Array(ids),
+		pq.Array(typeIDs),
+		pq.Array(labels),
+		pq.Array(seriesKeys),
+		pq.Array(deltas),
+		pq.Array(values),
+		pq.Array(tenants),
+	)
+	s.breaker.record(ctx, err)
+	return err
+}
+
+func (s *postgresStorage) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.Ping)
+	defer cancel()
+	return s.driver.DB.PingContext(ctx)
+}
+
+// Close makes last attempt to reconcile buffered updates
+func (s *postgresStorage) Close() error {
+	close(s.stopCh)
+	<-s.doneCh
+	<-s.breakerDoneCh
+	<-s.pruneDoneCh
+	if err := s.reconciler.reconcile(context.Background()); err != nil {
+		s.logger.Error("Buffered updates are lost",
+			zap.Int("series", s.reconciler.pending()),
+			zap.Error(err),
+		)
+	}
+	s.upsertStmt.Close()
+	return s.driver.DB.Close()
+}
+
+// encodeLabels converts labels to JSON accepted by jsonb column
+func encodeLabels(labels models.Labels) (string, error) {
+	if labels == nil {
+		return "{}", nil
+	}
+	encoded, err := json.Marshal(labels)
+	return string(encoded), err
+}
+
+// decodeLabels reads jsonb column into metric, empty labels are kept nil
+func decodeLabels(raw []byte, m *models.Metrics) error {
+	if err := json.Unmarshal(raw, &m.Labels); err != nil {
+		return err
+	}
+	if len(m.Labels) == 0 {
+		m.Labels = nil
+	}
+	return nil
+}
+
+// TODO: move to cmd/migrator/main.go
+func (s *postgresStorage) initDBSchema(source string) error {
+	driver, err := postgres.WithInstance(s.driver.DB, &postgres.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to create migrate postgres instance: %w", err)
+	}
+	m, err := migrate.NewWithDatabaseInstance(source, "postgres", driver)
+	if err != nil {
+		return fmt.Errorf("failed to create golang-migrate instance: %w", err)
+	}
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		return fmt.Errorf("failed to apply migrations: %w", err)
+	}
+	s.logger.Info("Database migrations applied successfully")
+	return nil
+}
+
+func (s *postgresStorage) cacheMetricTypeIDs() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeouts.Read)
+	defer cancel()
+	query := `SELECT id, metric_type FROM metric_types;`
+	rows, err := s.driver.DB.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to query metric types: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint
+		var typeName string
+		if err := rows.Scan(&id, &typeName); err != nil {
+			return err
+		}
+		switch typeName {
+		case models.Gauge:
+			s.gaugeTypeID = id
+		case models.Counter:
+			s.counterTypeID = id
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to scan metric types: %w", err)
+	}
+	s.logger.Info("Cached metric type IDs",
+		zap.Uint("gauge_type_id", s.gaugeTypeID),
+		zap.Uint("counter_type_id", s.counterTypeID),
+	)
+	s.metricTypes = map[uint]string{
+		s.gaugeTypeID:   models.Gauge,
+		s.counterTypeID: models.Counter,
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+)
+
+// QuotaError rejects writes creating more series than tenant may have
+type QuotaError struct {
+	Tenant string
+	Limit  int
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("tenant %q exceeded quota of %d series", e.Tenant, e.Limit)
+}
+
+// quotaStorage limits number of series per tenant on top of any backend,
+// series of tenant are loaded from backend on its first write and tracked
+// in memory afterwards
+type quotaStorage struct {
+	Storage
+	// limit returns max number of series of tenant, zero means no limit
+	limit  func(tenant string) int
+	mu     sync.Mutex
+	series map[string]map[string]struct{}
+}
+
+// allMetricsLoader is implemented by storages which may fail to read
+// metrics, GetAllMetrics of such storage hides the failure
+type allMetricsLoader interface {
+	loadAllMetrics(ctx context.Context) (map[string]models.Metrics, error)
+}
+
+// pendingQuotaStorage keeps pending updates of wrapped storage visible
+type pendingQuotaStorage struct {
+	*quotaStorage
+	pending PendingReporter
+}
+
+func (s *pendingQuotaStorage) PendingUpdates() int {
+	return s.pending.PendingUpdates()
+}
+
+// WithSeriesQuota wraps storage rejecting writes over tenant quota
+func WithSeriesQuota(s Storage, limit func(tenant string) int) Storage {
+	q := &quotaStorage{
+		Storage: s,
+		limit:   limit,
+		series:  make(map[string]map[string]struct{}),
+	}
+	if r, ok := s.(PendingReporter); ok {
+		return &pendingQuotaStorage{quotaStorage: q, pending: r}
+	}
+	return q
+}
+
+func (s *quotaStorage) SetGauge(ctx context.Context, name string, labels models.Labels, value float64) error {
+	return s.SetMetricBulk(ctx, &[]models.Metrics{{
+		ID:     name,
+		MType:  models.Gauge,
+		Value:  &value,
+		Labels: labels,
+	}})
+}
+
+func (s *quotaStorage) SetCounter(ctx context.Context, name string, labels models.Labels, delta int64) error {
+	return s.SetMetricBulk(ctx, &[]models.Metrics{{
+		ID:     name,
+		MType:  models.Counter,
+		Delta:  &delta,
+		Labels: labels,
+	}})
+}
+
+// SetMetricBulk rejects the whole batch when its new series do not fit
+// into quota, series reserved by failed write are released
+func (s *quotaStorage) SetMetricBulk(ctx context.Context, m *[]models.Metrics) error {
+	id := tenant.FromContext(ctx)
+	added, err := s.reserve(ctx, id, *m)
+	if err != nil {
+		return err
+	}
+	if err := s.Storage.SetMetricBulk(ctx, m); err != nil {
+		s.release(id, added)
+		return err
+	}
+	return nil
+}
+
+// reserve accounts new series of updates, loading series of tenant
+// known to backend first, series read by failed load are used for this
+// write only, so the next write loads them again
+func (s *quotaStorage) reserve(ctx context.Context, id string, updates []models.Metrics) ([]string, error) {
+	limit := s.limit(id)
+	if limit <= 0 {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	known, loaded := s.series[id]
+	if !loaded {
+		stored, err := s.loadAllMetrics(ctx)
+		known = make(map[string]struct{}, len(stored))
+		for _, m := range stored {
+			known[m.StorageKey()] = struct{}{}
+		}
+		if err == nil {
+			s.series[id] = known
+		}
+	}
+	var added []string
+	for _, m := range updates {
+		m.Tenant = id
+		key := m.StorageKey()
+		if _, ok := known[key]; ok {
+			continue
+		}
+		known[key] = struct{}{}
+		added = append(added, key)
+	}
+	if len(known) > limit {
+		s.releaseLocked(id, added)
+		return nil, &QuotaError{Tenant: id, Limit: limit}
+	}
+	return added, nil
+}
+
+func (s *quotaStorage) loadAllMetrics(ctx context.Context) (map[string]models.Metrics, error) {
+	if l, ok := s.Storage.(allMetricsLoader); ok {
+		return l.loadAllMetrics(ctx)
+	}
+	return s.Storage.GetAllMetrics(ctx), nil
+}
+
+func (s *quotaStorage) release(id string, keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked(id, keys)
+}
+
+// releaseLocked forgets series, caller must hold lock
+func (s *quotaStorage) releaseLocked(id string, keys []string) {
+	for _, key := range keys {
+		delete(s.series[id], key)
+	}
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"github.com/stretchr/testify/require"
+)
+
+// failingStorage fails writes while err is set
+type failingStorage struct {
+	*memoryStorage
+	err error
+}
+
+func (s *failingStorage) SetMetricBulk(ctx context.Context, m *[]models.Metrics) error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.memoryStorage.SetMetricBulk(ctx, m)
+}
+
+func TestWithSeriesQuota(t *testing.T) {
+	backend := &failingStorage{memoryStorage: NewMemoryStorage()}
+	teamA := tenant.WithID(context.Background(), "team-a")
+	teamB := tenant.WithID(context.Background(), "team-b")
+	// series written before quota was enforced count as well
+	require.NoError(t, backend.SetGauge(teamA, "Alloc", nil, 1))
+	s := WithSeriesQuota(backend, func(id string) int {
+		if id == "team-a" {
+			return 2
+		}
+		return 0
+	})
+
+	require.NoError(t, s.SetGauge(teamA, "Alloc", nil, 2), "existing series should not count twice")
+	require.NoError(t, s.SetCounter(teamA, "PollCount", nil, 1))
+	require.NoError(t, s.SetCounter(teamA, "PollCount", nil, 1))
+
+	var quotaErr *QuotaError
+	err := s.SetGauge(teamA, "HeapAlloc", nil, 1)
+	require.True(t, errors.As(err, &quotaErr))
+	require.Equal(t, &QuotaError{Tenant: "team-a", Limit: 2}, quotaErr)
+	_, found := s.GetMetric(teamA, "HeapAlloc", models.Gauge, nil)
+	require.False(t, found)
+
+	value := 1.0
+	batch := []models.Metrics{
+		{ID: "Alloc", MType: models.Gauge, Value: &value},
+		{ID: "Frees", MType: models.Gauge, Value: &value},
+	}
+	require.ErrorAs(t, s.SetMetricBulk(teamA, &batch), &quotaErr, "batch should be rejected as a whole")
+	m, _ := s.GetMetric(teamA, "Alloc", models.Gauge, nil)
+	require.Equal(t, 2.0, *m.Value)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.SetCounter(teamB, "PollCount", models.Labels{"n": string(rune('a' + i))}, 1),
+			"tenant without quota should not be limited")
+	}
+}
+
+func TestWithSeriesQuota_ReleasesFailedWrites(t *testing.T) {
+	backend := &failingStorage{memoryStorage: NewMemoryStorage(), err: errors.New("disk is full")}
+	s := WithSeriesQuota(backend, func(string) int { return 1 })
+	ctx := context.Background()
+	require.Error(t, s.SetGauge(ctx, "Alloc", nil, 1))
+	backend.err = nil
+	require.NoError(t, s.SetGauge(ctx, "HeapAlloc", nil, 1), "failed write should not hold quota")
+}
+
+// unreachableStorage fails to load metrics while loadErr is set
+type unreachableStorage struct {
+	*memoryStorage
+	loadErr error
+	pending int
+}
+
+func (s *unreachableStorage) loadAllMetrics(ctx context.Context) (map[string]models.Metrics, error) {
+	if s.loadErr != nil {
+		return map[string]models.Metrics{}, s.loadErr
+	}
+	return s.GetAllMetrics(ctx), nil
+}
+
+func (s *unreachableStorage) PendingUpdates() int {
+	return s.pending
+}
+
+func TestWithSeriesQuota_ReloadsAfterFailedLoad(t *testing.T) {
+	backend := &unreachableStorage{memoryStorage: NewMemoryStorage(), loadErr: ErrCircuitOpen, pending: 3}
+	ctx := context.Background()
+	require.NoError(t, backend.SetGauge(ctx, "Alloc", nil, 1))
+	s := WithSeriesQuota(backend, func(string) int { return 2 })
+
+	require.NoError(t, s.SetGauge(ctx, "HeapAlloc", nil, 1))
+	backend.loadErr = nil
+	var quotaErr *QuotaError
+	require.ErrorAs(t, s.SetGauge(ctx, "Frees", nil, 1), &quotaErr, "series should be loaded again")
+
+	r, ok := s.(PendingReporter)
+	require.True(t, ok, "pending updates of backend should stay visible")
+	require.Equal(t, 3, r.PendingUpdates())
+	_, ok = WithSeriesQuota(NewMemoryStorage(), func(string) int { return 1 }).(PendingReporter)
+	require.False(t, ok)
+}
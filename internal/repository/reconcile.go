@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/jackc/pgerrcode"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const reconcileInterval = 5 * time.Second
+
+// PendingReporter is implemented by storages buffering updates
+// while their backend is unavailable
+type PendingReporter interface {
+	PendingUpdates() int
+}
+
+// reconciler buffers updates which failed to reach database and replays
+// them once database is reachable again, gauges keep the last value and
+// counters accumulate deltas. Connection lost after commit but before its
+// acknowledgement looks like a failed write, so counter deltas of such
+// write are replayed and counted twice, gauges are not affected
+type reconciler struct {
+	// held exclusively while buffered updates are replayed so that
+	// newer direct writes can not be overwritten by older ones
+	flushMu  sync.RWMutex
+	mu       sync.Mutex
+	gauges   map[string]models.Metrics
+	counters map[string]models.Metrics
+	interval time.Duration
+	ping     func(ctx context.Context) error
+	apply    func(ctx context.Context, updates []models.Metrics) error
+	logger   *zap.Logger
+}
+
+func newReconciler(
+	interval time.Duration,
+	ping func(ctx context.Context) error,
+	apply func(ctx context.Context, updates []models.Metrics) error,
+	logger *zap.Logger,
+) *reconciler {
+	return &reconciler{
+		gauges:   make(map[string]models.Metrics),
+		counters: make(map[string]models.Metrics),
+		interval: interval,
+		ping:     ping,
+		apply:    apply,
+		logger:   logger,
+	}
+}
+
+func (r *reconciler) run(stopCh chan struct{}, doneCh chan struct{}) {
+	defer close(doneCh)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.reconcile(context.Background()); err != nil {
+				r.logger.Warn("Reconciliation postponed", zap.Error(err))
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// submit writes updates directly unless older updates are still buffered,
+// updates failed due to unreachable database are buffered, updates
+// abandoned by cancelled caller or exceeding its deadline are not as
+// caller is going to resend them
+func (r *reconciler) submit(
+	ctx context.Context,
+	updates []models.Metrics,
+	write func(ctx context.Context, updates []models.Metrics) error,
+) error {
+	r.flushMu.RLock()
+	defer r.flushMu.RUnlock()
+	if r.pending() > 0 {
+		r.add(updates...)
+		return nil
+	}
+	err := write(ctx, updates)
+	if err != nil && ctx.Err() == nil && isConnectionError(err) {
+		r.logger.Warn("Database is unreachable, buffering updates for reconciliation", zap.Error(err))
+		r.add(updates...)
+		return nil
+	}
+	return err
+}
+
+// add buffers updates, caller keeps the order of updates
+func (r *reconciler) add(updates ...models.Metrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range updates {
+		r.merge(m)
+	}
+}
+
+// merge adds single update to buffer, caller must hold lock
+func (r *reconciler) merge(m models.Metrics) {
+	key := m.StorageKey()
+	switch m.MType {
+	case models.Gauge:
+		value := *m.Value
+		m.Value = &value
+		r.gauges[key] = m
+	case models.Counter:
+		delta := *m.Delta
+		if existing, ok := r.counters[key]; ok {
+			delta += *existing.Delta
+		}
+		m.Delta = &delta
+		r.counters[key] = m
+	}
+}
+
+// pending returns number of series waiting for reconciliation
+func (r *reconciler) pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.gauges) + len(r.counters)
+}
+
+// overlay applies buffered updates to metric read from database
+func (r *reconciler) overlay(m *models.Metrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := m.StorageKey()
+	switch m.MType {
+	case models.Gauge:
+		if pending, ok := r.gauges[key]; ok {
+			value := *pending.Value
+			m.Value = &value
+		}
+	case models.Counter:
+		if pending, ok := r.counters[key]; ok && m.Delta != nil {
+			delta := *m.Delta + *pending.Delta
+			m.Delta = &delta
+		}
+	}
+}
+
+// buffered returns series which has not reached database yet
+func (r *reconciler) buffered(key string) (*models.Metrics, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.gauges[key]; ok {
+		return &m, true
+	}
+	if m, ok := r.counters[key]; ok {
+		return &m, true
+	}
+	return nil, false
+}
+
+// addMissing puts buffered series of tenant absent in database into metrics
+func (r *reconciler) addMissing(tenant string, metrics map[string]models.Metrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, buffer := range []map[string]models.Metrics{r.gauges, r.counters} {
+		for key, m := range buffer {
+			if _, ok := metrics[key]; !ok && m.Tenant == tenant {
+				metrics[key] = m
+			}
+		}
+	}
+}
+
+// reconcile replays buffered updates if database is reachable,
+// updates are put back into buffer on failure
+func (r *reconciler) reconcile(ctx context.Context) error {
+	if r.pending() == 0 {
+		return nil
+	}
+	if err := r.ping(ctx); err != nil {
+		return err
+	}
+	r.flushMu.Lock()
+	defer r.flushMu.Unlock()
+	r.mu.Lock()
+	updates := make([]models.Metrics, 0, len(r.gauges)+len(r.counters))
+	for _, m := range r.gauges {
+		updates = append(updates, m)
+	}
+	for _, m := range r.counters {
+		updates = append(updates, m)
+	}
+	r.gauges = make(map[string]models.Metrics)
+	r.counters = make(map[string]models.Metrics)
+	r.mu.Unlock()
+	if err := r.apply(ctx, updates); err != nil {
+		r.restore(updates)
+		return err
+	}
+	r.logger.Info("Buffered updates reconciled", zap.Int("series", len(updates)))
+	return nil
+}
+
+// restore puts back updates taken by failed reconciliation,
+// gauges set meanwhile are newer and win
+func (r *reconciler) restore(updates []models.Metrics) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range updates {
+		if _, ok := r.gauges[m.StorageKey()]; ok && m.MType == models.Gauge {
+			continue
+		}
+		r.merge(m)
+	}
+}
+
+// isConnectionError reports whether error means database is unreachable
+// rather than update was rejected, callers make sure deadline exceeded
+// comes from storage timeout rather than from caller
+func isConnectionError(err error) bool {
+	var pqError *pq.Error
+	if errors.As(err, &pqError) {
+		return pgerrcode.IsConnectionException(string(pqError.Code)) ||
+			pgerrcode.IsOperatorIntervention(string(pqError.Code))
+	}
+	var netError net.Error
+	return errors.As(err, &netError) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, context.DeadlineExceeded)
+}
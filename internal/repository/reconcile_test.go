@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// fakeDB records applied updates and fails while down
+type fakeDB struct {
+	down    bool
+	applied []models.Metrics
+}
+
+func (db *fakeDB) ping(_ context.Context) error {
+	if db.down {
+		return &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+	}
+	return nil
+}
+
+func (db *fakeDB) apply(ctx context.Context, updates []models.Metrics) error {
+	if err := db.ping(ctx); err != nil {
+		return err
+	}
+	db.applied = append(db.applied, updates...)
+	return nil
+}
+
+func gauge(name string, value float64) models.Metrics {
+	return models.Metrics{ID: name, MType: models.Gauge, Value: &value}
+}
+
+func counter(name string, delta int64) models.Metrics {
+	return models.Metrics{ID: name, MType: models.Counter, Delta: &delta}
+}
+
+func byKey(metrics []models.Metrics) map[string]models.Metrics {
+	result := make(map[string]models.Metrics, len(metrics))
+	for _, m := range metrics {
+		result[m.StorageKey()] = m
+	}
+	return result
+}
+
+func Test_reconciler(t *testing.T) {
+	ctx := context.Background()
+	db := &fakeDB{down: true}
+	r := newReconciler(time.Hour, db.ping, db.apply, zap.NewNop())
+
+	require.NoError(t, r.submit(ctx, []models.Metrics{gauge("Alloc", 1), counter("PollCount", 2)}, db.apply))
+	require.NoError(t, r.submit(ctx, []models.Metrics{gauge("Alloc", 3), counter("PollCount", 5)}, db.apply))
+	require.Equal(t, 2, r.pending())
+	require.Error(t, r.reconcile(ctx), "database is still down")
+	require.Equal(t, 2, r.pending())
+
+	// database is back but older updates are buffered, so new ones are queued behind them
+	db.down = false
+	require.NoError(t, r.submit(ctx, []models.Metrics{counter("PollCount", 1)}, db.apply))
+	require.Empty(t, db.applied)
+
+	require.NoError(t, r.reconcile(ctx))
+	require.Zero(t, r.pending())
+	applied := byKey(db.applied)
+	require.Len(t, applied, 2)
+	require.Equal(t, 3.0, *applied["gauge:Alloc"].Value, "gauge should keep last value")
+	require.Equal(t, int64(8), *applied["counter:PollCount"].Delta, "counter deltas should accumulate")
+
+	// once reconciled updates go directly to database
+	require.NoError(t, r.submit(ctx, []models.Metrics{gauge("Alloc", 4)}, db.apply))
+	require.Zero(t, r.pending())
+	require.Len(t, db.applied, 3)
+}
+
+func Test_reconciler_restoreAfterFailedApply(t *testing.T) {
+	r := newReconciler(time.Hour, func(context.Context) error { return nil }, nil, zap.NewNop())
+	r.add(gauge("Alloc", 1), counter("PollCount", 2))
+	r.apply = func(_ context.Context, updates []models.Metrics) error {
+		// updates arriving while reconciliation is in flight
+		r.add(gauge("Alloc", 5), counter("PollCount", 3))
+		return driver.ErrBadConn
+	}
+	require.ErrorIs(t, r.reconcile(context.Background()), driver.ErrBadConn)
+
+	m, ok := r.buffered("gauge:Alloc")
+	require.True(t, ok)
+	require.Equal(t, 5.0, *m.Value, "newer gauge should win")
+	m, ok = r.buffered("counter:PollCount")
+	require.True(t, ok)
+	require.Equal(t, int64(5), *m.Delta, "no counter increment should be lost")
+}
+
+func Test_reconciler_cancelledSubmit(t *testing.T) {
+	r := newReconciler(time.Hour, nil, nil, zap.NewNop())
+	ctx, cancel := context.WithCancel(context.Background())
+	// client disconnects while write is in flight
+	write := func(context.Context, []models.Metrics) error {
+		cancel()
+		return driver.ErrBadConn
+	}
+	require.ErrorIs(t, r.submit(ctx, []models.Metrics{counter("PollCount", 1)}, write), driver.ErrBadConn)
+	require.Zero(t, r.pending(), "abandoned updates should not be buffered")
+
+	// deadline of caller expires while write is in flight
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	write = func(context.Context, []models.Metrics) error {
+		return context.DeadlineExceeded
+	}
+	require.ErrorIs(t, r.submit(ctx, []models.Metrics{counter("PollCount", 1)}, write), context.DeadlineExceeded)
+	require.Zero(t, r.pending(), "updates of expired caller should not be buffered")
+}
+
+func Test_reconciler_overlay(t *testing.T) {
+	r := newReconciler(time.Hour, nil, nil, zap.NewNop())
+	other := gauge("Buffered", 5)
+	other.Tenant = "team-b"
+	r.add(gauge("Alloc", 7), counter("PollCount", 2), gauge("Buffered", 1), other)
+
+	stored := counter("PollCount", 40)
+	r.overlay(&stored)
+	require.Equal(t, int64(42), *stored.Delta)
+	stored = gauge("Alloc", 1)
+	r.overlay(&stored)
+	require.Equal(t, 7.0, *stored.Value)
+
+	all := map[string]models.Metrics{"gauge:Alloc": gauge("Alloc", 7)}
+	r.addMissing("", all)
+	require.Len(t, all, 3, "series of other tenants should not be added")
+	require.Contains(t, all, "gauge:Buffered")
+}
+
+func Test_isConnectionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "dial error", err: fmt.Errorf("upsert: %w", &net.OpError{Op: "dial", Err: errors.New("refused")}), want: true},
+		{name: "bad connection", err: driver.ErrBadConn, want: true},
+		{name: "connection failure", err: &pq.Error{Code: "08006"}, want: true},
+		{name: "admin shutdown", err: &pq.Error{Code: "57P01"}, want: true},
+		{name: "unique violation", err: &pq.Error{Code: "23505"}, want: false},
+		{name: "other error", err: errors.New("invalid input"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isConnectionError(tt.err))
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+)
+
+// sampleRing keeps last N samples of series, oldest samples are overwritten;
+// storage grows with samples so short lived series stay small
+type sampleRing struct {
+	samples  []models.Sample
+	capacity int
+	next     int
+}
+
+func newSampleRing(capacity int) *sampleRing {
+	return &sampleRing{capacity: capacity}
+}
+
+func (r *sampleRing) push(s models.Sample) {
+	if len(r.samples) < r.capacity {
+		r.samples = append(r.samples, s)
+		return
+	}
+	r.samples[r.next] = s
+	r.next = (r.next + 1) % len(r.samples)
+}
+
+// between returns samples within [from, to] in chronological order
+func (r *sampleRing) between(from, to time.Time) []models.Sample {
+	result := make([]models.Sample, 0)
+	// next is zero until ring is full, then it points to the oldest sample
+	for _, part := range [][]models.Sample{r.samples[r.next:], r.samples[:r.next]} {
+		for _, s := range part {
+			if s.Timestamp.Before(from) || s.Timestamp.After(to) {
+				continue
+			}
+			result = append(result, s)
+		}
+	}
+	return result
+}
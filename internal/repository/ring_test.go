@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sampleRing_between(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time {
+		return start.Add(time.Duration(sec) * time.Second)
+	}
+	tests := []struct {
+		name     string
+		capacity int
+		pushed   int
+		from     time.Time
+		to       time.Time
+		want     []float64
+	}{
+		{
+			name:     "should return all samples of not full ring",
+			capacity: 5,
+			pushed:   3,
+			from:     at(0),
+			to:       at(10),
+			want:     []float64{0, 1, 2},
+		},
+		{
+			name:     "should overwrite oldest samples and keep order",
+			capacity: 3,
+			pushed:   5,
+			from:     at(0),
+			to:       at(10),
+			want:     []float64{2, 3, 4},
+		},
+		{
+			name:     "should filter samples by time range",
+			capacity: 10,
+			pushed:   10,
+			from:     at(3),
+			to:       at(5),
+			want:     []float64{3, 4, 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newSampleRing(tt.capacity)
+			require.Zero(t, cap(r.samples), "ring should not preallocate samples")
+			for i := 0; i < tt.pushed; i++ {
+				r.push(models.Sample{Timestamp: at(i), Value: float64(i)})
+			}
+			var actual []float64
+			for _, s := range r.between(tt.from, tt.to) {
+				actual = append(actual, s.Value)
+			}
+			require.Equal(t, tt.want, actual)
+			require.LessOrEqual(t, len(r.samples), tt.capacity)
+		})
+	}
+}
+
+func Test_memoryStorage_historySeriesLimit(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStorage()
+	s.maxHistorySeries = 2
+	for _, name := range []string{"Alloc", "HeapAlloc", "StackInuse"} {
+		require.NoError(t, s.SetGauge(ctx, name, nil, 1))
+	}
+	from, to := time.Now().Add(-time.Minute), time.Now().Add(time.Minute)
+	samples, err := s.GetHistory(ctx, "HeapAlloc", models.Gauge, nil, from, to)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	samples, err = s.GetHistory(ctx, "StackInuse", models.Gauge, nil, from, to)
+	require.NoError(t, err)
+	require.Empty(t, samples, "series over limit should have no history")
+	_, ok := s.GetMetric(ctx, "StackInuse", models.Gauge, nil)
+	require.True(t, ok, "series over limit should still be stored")
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/config/db"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/driver"
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"github.com/jackc/pgerrcode"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// amount of samples kept per series in memory and returned
+// by database, one hour of reports sent every second
+const historySize = 3600
+
+// samples older than it are deleted from database, which keeps
+// every sample unlike memory ring
+const historyRetention = time.Hour
+
+// amount of series with history kept in memory, samples of series
+// over it are not recorded so label churn can not exhaust memory
+const maxHistorySeries = 1000
+
+// Storage is implemented by every metrics backend, all of them
+// must pass the same conformance suite in storage_test.go,
+// cancellation of ctx aborts pending database work, tenant carried
+// by ctx owns written series and limits reads to its own ones
+type Storage interface {
+	SetGauge(ctx context.Context, name string, labels models.Labels, value float64) error
+	SetCounter(ctx context.Context, name string, labels models.Labels, delta int64) error
+	GetMetric(ctx context.Context, name string, metricType string, labels models.Labels) (*models.Metrics, bool)
+	// GetAllMetrics returns copy of stored metrics keyed by storage key
+	GetAllMetrics(ctx context.Context) map[string]models.Metrics
+	GetHistory(ctx context.Context, name string, metricType string, labels models.Labels, from, to time.Time) ([]models.Sample, error)
+	SetMetricBulk(ctx context.Context, m *[]models.Metrics) error
+	Ping(ctx context.Context) error
+	// Close flushes pending data and releases resources
+	Close() error
+}
+
+type Config struct {
+	// postgres is used when DSN is set
+	DatabaseDSN string
+	// file storage is used when path is set, memory storage otherwise
+	FilePath string
+	Restore  bool
+	// snapshot interval of file storage, zero syncs WAL on every update
+	StoreInterval time.Duration
+	// per operation database timeouts, zero values fall back to defaults
+	Timeouts Timeouts
+	Logger   *zap.Logger
+}
+
+// Timeouts bound single database operations on top of caller deadline
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Ping  time.Duration
+}
+
+// withDefaults replaces unset timeouts with defaults
+func (t Timeouts) withDefaults() Timeouts {
+	if t.Read <= 0 {
+		t.Read = time.Second
+	}
+	if t.Write <= 0 {
+		t.Write = 10 * time.Second
+	}
+	if t.Ping <= 0 {
+		t.Ping = time.Second
+	}
+	return t
+}
+
+// NewStorage selects backend by config
+func NewStorage(cfg *Config) (Storage, error) {
+	switch {
+	case cfg.DatabaseDSN != "":
+		d, err := driver.NewSQLDriver(db.NewDBConfig(cfg.DatabaseDSN))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+		cfg.Logger.Info("Using postgres storage")
+		s, err := NewPostgresStorage(d, cfg.Timeouts, cfg.Logger)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case cfg.FilePath != "":
+		cfg.Logger.Info("Using file storage", zap.String("path", cfg.FilePath))
+		s, err := NewFileStorage(cfg.FilePath, cfg.Restore, cfg.StoreInterval, cfg.Logger)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		cfg.Logger.Info("Using memory storage")
+		return NewMemoryStorage(), nil
+	}
+}
+
+// withTenant copies updates stamping them with ctx tenant,
+// tenant sent by client is never trusted
+func withTenant(ctx context.Context, updates []models.Metrics) []models.Metrics {
+	id := tenant.FromContext(ctx)
+	result := make([]models.Metrics, len(updates))
+	for i, m := range updates {
+		m.Tenant = id
+		result[i] = m
+	}
+	return result
+}
+
+type retriablePgError struct {
+	err error
+}
+
+func newRetriablePgError(err error) *retriablePgError {
+	return &retriablePgError{err: err}
+}
+
+func (e *retriablePgError) Unwrap() error {
+	return e.err
+}
+
+func (e *retriablePgError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retriablePgError) IsRetriable() bool {
+	return true
+}
+
+// wrapPgError marks connection errors as retriable
+func wrapPgError(err error) error {
+	var pqError *pq.Error
+	if errors.As(err, &pqError) && pgerrcode.IsConnectionException(string(pqError.Code)) {
+		return newRetriablePgError(err)
+	}
+	return err
+}
@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/config/db"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/driver"
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go.uber.org/zap"
+)
+
+// storageSuite is a conformance suite every Storage implementation must pass
+type storageSuite struct {
+	suite.Suite
+	newStorage func(t *testing.T) Storage
+	storage    Storage
+	ctx        context.Context
+}
+
+func (s *storageSuite) SetupTest() {
+	s.storage = s.newStorage(s.T())
+	s.ctx = context.Background()
+}
+
+func (s *storageSuite) TearDownTest() {
+	s.Require().NoError(s.storage.Close())
+}
+
+func (s *storageSuite) TestGauge() {
+	s.Require().NoError(s.storage.SetGauge(s.ctx, "Alloc", nil, 1.5))
+	s.Require().NoError(s.storage.SetGauge(s.ctx, "Alloc", nil, 2.5))
+	m, found := s.storage.GetMetric(s.ctx, "Alloc", models.Gauge, nil)
+	s.Require().True(found)
+	s.Equal("Alloc", m.ID)
+	s.Equal(models.Gauge, m.MType)
+	s.Equal(2.5, *m.Value)
+	s.Nil(m.Delta)
+}
+
+func (s *storageSuite) TestCounter() {
+	s.Require().NoError(s.storage.SetCounter(s.ctx, "PollCount", nil, 3))
+	s.Require().NoError(s.storage.SetCounter(s.ctx, "PollCount", nil, 4))
+	m, found := s.storage.GetMetric(s.ctx, "PollCount", models.Counter, nil)
+	s.Require().True(found)
+	s.Equal(models.Counter, m.MType)
+	s.Equal(int64(7), *m.Delta)
+}
+
+func (s *storageSuite) TestMissingMetric() {
+	s.Require().NoError(s.storage.SetGauge(s.ctx, "Alloc", nil, 1))
+	_, found := s.storage.GetMetric(s.ctx, "Unknown", models.Gauge, nil)
+	s.False(found)
+	_, found = s.storage.GetMetric(s.ctx, "Alloc", models.Counter, nil)
+	s.False(found, "types should be separate namespaces")
+	_, found = s.storage.GetMetric(s.ctx, "Alloc", "histogram", nil)
+	s.False(found)
+}
+
+func (s *storageSuite) TestLabels() {
+	sda := models.Labels{"device": "sda"}
+	sdb := models.Labels{"device": "sdb"}
+	s.Require().NoError(s.storage.SetCounter(s.ctx, "disk_reads", sda, 1))
+	s.Require().NoError(s.storage.SetCounter(s.ctx, "disk_reads", sdb, 10))
+	s.Require().NoError(s.storage.SetCounter(s.ctx, "disk_reads", sda, 1))
+	m, found := s.storage.GetMetric(s.ctx, "disk_reads", models.Counter, sda)
+	s.Require().True(found)
+	s.Equal(int64(2), *m.Delta)
+	s.Equal(sda, m.Labels)
+	m, found = s.storage.GetMetric(s.ctx, "disk_reads", models.Counter, sdb)
+	s.Require().True(found)
+	s.Equal(int64(10), *m.Delta)
+	_, found = s.storage.GetMetric(s.ctx, "disk_reads", models.Counter, nil)
+	s.False(found)
+}
+
+func (s *storageSuite) TestSetMetricBulk() {
+	value := 42.0
+	delta := int64(5)
+	metrics := []models.Metrics{
+		{ID: "HeapAlloc", MType: models.Gauge, Value: &value},
+		{ID: "PollCount", MType: models.Counter, Delta: &delta},
+		{ID: "PollCount", MType: models.Counter, Delta: &delta},
+		// updates without value are skipped rather than crash storage
+		{ID: "Broken", MType: models.Gauge},
+		{ID: "PollCount", MType: models.Counter},
+	}
+	s.Require().NoError(s.storage.SetMetricBulk(s.ctx, &metrics))
+	_, found := s.storage.GetMetric(s.ctx, "Broken", models.Gauge, nil)
+	s.False(found)
+	m, found := s.storage.GetMetric(s.ctx, "HeapAlloc", models.Gauge, nil)
+	s.Require().True(found)
+	s.Equal(value, *m.Value)
+	m, found = s.storage.GetMetric(s.ctx, "PollCount", models.Counter, nil)
+	s.Require().True(found)
+	s.Equal(int64(10), *m.Delta)
+}
+
+func (s *storageSuite) TestGetAllMetrics() {
+	s.Require().NoError(s.storage.SetGauge(s.ctx, "Alloc", nil, 1))
+	s.Require().NoError(s.storage.SetCounter(s.ctx, "PollCount", nil, 1))
+	s.Require().NoError(s.storage.SetCounter(s.ctx, "PollCount", models.Labels{"host": "a"}, 1))
+	all := s.storage.GetAllMetrics(s.ctx)
+	s.Len(all, 3)
+	s.Contains(all, "gauge:Alloc")
+	s.Contains(all, "counter:PollCount")
+	s.Contains(all, `counter:PollCount{host="a"}`)
+	// result must be a copy
+	delete(all, "gauge:Alloc")
+	s.Len(s.storage.GetAllMetrics(s.ctx), 3)
+}
+
+func (s *storageSuite) TestGetHistory() {
+	from := time.Now().Add(-time.Minute)
+	for _, v := range []float64{1, 2, 3} {
+		s.Require().NoError(s.storage.SetGauge(s.ctx, "Alloc", nil, v))
+	}
+	s.Require().NoError(s.storage.SetCounter(s.ctx, "PollCount", nil, 2))
+	s.Require().NoError(s.storage.SetCounter(s.ctx, "PollCount", nil, 3))
+	to := time.Now().Add(time.Minute)
+
+	samples, err := s.storage.GetHistory(s.ctx, "Alloc", models.Gauge, nil, from, to)
+	s.Require().NoError(err)
+	s.Equal([]float64{1, 2, 3}, sampleValues(samples))
+	samples, err = s.storage.GetHistory(s.ctx, "PollCount", models.Counter, nil, from, to)
+	s.Require().NoError(err)
+	s.Equal([]float64{2, 5}, sampleValues(samples), "counter history should hold running totals")
+	samples, err = s.storage.GetHistory(s.ctx, "Unknown", models.Gauge, nil, from, to)
+	s.Require().NoError(err)
+	s.Empty(samples)
+}
+
+func (s *storageSuite) TestTenants() {
+	teamA := tenant.WithID(s.ctx, "team-a")
+	teamB := tenant.WithID(s.ctx, "team-b")
+	s.Require().NoError(s.storage.SetGauge(teamA, "HeapAlloc", nil, 1))
+	s.Require().NoError(s.storage.SetGauge(teamB, "HeapAlloc", nil, 2))
+	s.Require().NoError(s.storage.SetCounter(teamA, "PollCount", nil, 3))
+	// tenant sent by client must not be trusted
+	delta := int64(5)
+	forged := []models.Metrics{{ID: "PollCount", MType: models.Counter, Delta: &delta, Tenant: "team-a"}}
+	s.Require().NoError(s.storage.SetMetricBulk(teamB, &forged))
+
+	m, found := s.storage.GetMetric(teamA, "HeapAlloc", models.Gauge, nil)
+	s.Require().True(found)
+	s.Equal(1.0, *m.Value)
+	m, found = s.storage.GetMetric(teamB, "HeapAlloc", models.Gauge, nil)
+	s.Require().True(found)
+	s.Equal(2.0, *m.Value)
+	m, found = s.storage.GetMetric(teamA, "PollCount", models.Counter, nil)
+	s.Require().True(found)
+	s.Equal(int64(3), *m.Delta)
+	_, found = s.storage.GetMetric(s.ctx, "HeapAlloc", models.Gauge, nil)
+	s.False(found, "default tenant should not see other tenants")
+
+	all := s.storage.GetAllMetrics(teamB)
+	s.Len(all, 2)
+	s.Contains(all, "team-b/gauge:HeapAlloc")
+	s.Contains(all, "team-b/counter:PollCount")
+	s.Empty(s.storage.GetAllMetrics(s.ctx))
+
+	from := time.Now().Add(-time.Minute)
+	to := time.Now().Add(time.Minute)
+	samples, err := s.storage.GetHistory(teamA, "HeapAlloc", models.Gauge, nil, from, to)
+	s.Require().NoError(err)
+	s.Equal([]float64{1}, sampleValues(samples))
+}
+
+func (s *storageSuite) TestPing() {
+	s.NoError(s.storage.Ping(s.ctx))
+}
+
+func sampleValues(samples []models.Sample) []float64 {
+	values := make([]float64, 0, len(samples))
+	for _, sample := range samples {
+		values = append(values, sample.Value)
+	}
+	return values
+}
+
+func TestMemoryStorage(t *testing.T) {
+	suite.Run(t, &storageSuite{newStorage: func(t *testing.T) Storage {
+		return NewMemoryStorage()
+	}})
+}
+
+func TestFileStorage(t *testing.T) {
+	for name, interval := range map[string]time.Duration{
+		"sync":     0,
+		"periodic": time.Hour,
+	} {
+		t.Run(name, func(t *testing.T) {
+			suite.Run(t, &storageSuite{newStorage: func(t *testing.T) Storage {
+				path := filepath.Join(t.TempDir(), "metrics.json")
+				s, err := NewFileStorage(path, false, interval, zap.NewNop())
+				require.NoError(t, err)
+				return s
+			}})
+		})
+	}
+}
+
+// newTestPostgresStorage connects to empty database from TEST_DATABASE_DSN,
+// test is skipped when it is not set
+func newTestPostgresStorage(tb testing.TB) *postgresStorage {
+	tb.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		tb.Skip("TEST_DATABASE_DSN is not set")
+	}
+	// migrations are resolved relative to repository root
+	wd, err := os.Getwd()
+	require.NoError(tb, err)
+	require.NoError(tb, os.Chdir("../.."))
+	defer os.Chdir(wd)
+	d, err := driver.NewSQLDriver(db.NewDBConfig(dsn))
+	require.NoError(tb, err)
+	s, err := NewPostgresStorage(d, Timeouts{}, zap.NewNop())
+	require.NoError(tb, err)
+	_, err = d.DB.Exec("TRUNCATE metrics, metric_samples;")
+	require.NoError(tb, err)
+	return s
+}
+
+func TestPostgresStorage(t *testing.T) {
+	newTestPostgresStorage(t).Close()
+	suite.Run(t, &storageSuite{newStorage: func(t *testing.T) Storage {
+		return newTestPostgresStorage(t)
+	}})
+}
+
+func TestPostgresStorage_History(t *testing.T) {
+	s := newTestPostgresStorage(t)
+	defer s.Close()
+	ctx := context.Background()
+	_, err := s.driver.DB.Exec(`
+		INSERT INTO metric_samples (id, metric_type_id, series_key, value, created_at)
+		SELECT 'Alloc', $1, 'Alloc', n, NOW() - n * INTERVAL '1 second'
+		FROM generate_series(1, $2::int) AS n;
+	`, s.gaugeTypeID, historySize+100)
+	require.NoError(t, err)
+	samples, err := s.GetHistory(ctx, "Alloc", models.Gauge, nil, time.Now().Add(-2*time.Hour), time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, historySize, "history should be capped as memory ring is")
+	require.Equal(t, 1.0, samples[len(samples)-1].Value, "latest samples should be kept")
+
+	require.NoError(t, s.deleteSamplesBefore(ctx, time.Now().Add(-30*time.Minute)))
+	samples, err = s.GetHistory(ctx, "Alloc", models.Gauge, nil, time.Now().Add(-2*time.Hour), time.Now())
+	require.NoError(t, err)
+	// clocks of test and database may differ slightly
+	require.InDelta(t, 30*60, len(samples), 5, "samples out of retention window should be deleted")
+}
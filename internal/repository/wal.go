@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+)
+
+const (
+	walHeaderSize = 16
+	// limit of record payload, the same on write and replay
+	walMaxRecordLen = 64 << 20
+)
+
+var errTornRecord = errors.New("torn or corrupted WAL record")
+
+// ErrBatchTooLarge is returned for batch which does not fit single
+// WAL record, batch is stored atomically so it is not split
+var ErrBatchTooLarge = errors.New("batch exceeds WAL record size limit")
+
+// writeAheadLog is an append-only log of metric updates, every record is
+// [length uint32][crc32 uint32][seq uint64][JSON encoded updates]
+type writeAheadLog struct {
+	f    *os.File
+	size int64
+	// sequence number of last appended record
+	seq uint64
+	// limit of record payload
+	maxRecordLen int
+}
+
+func openWAL(path string) (*writeAheadLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &writeAheadLog{f: f, maxRecordLen: walMaxRecordLen}, nil
+}
+
+// replay applies records with sequence number above fromSeq in order,
+// torn tail left by crash and records following corrupted one are
+// truncated, returns number of applied records
+func (w *writeAheadLog) replay(fromSeq uint64, apply func([]models.Metrics)) (int, error) {
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(w.f)
+	var offset int64
+	applied := 0
+	for {
+		seq, updates, n, err := readWALRecord(r, w.maxRecordLen)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errTornRecord) {
+			if err := w.f.Truncate(offset); err != nil {
+				return applied, err
+			}
+			break
+		}
+		if err != nil {
+			return applied, err
+		}
+		offset += n
+		if seq > w.seq {
+			w.seq = seq
+		}
+		if seq <= fromSeq {
+			continue
+		}
+		apply(updates)
+		applied++
+	}
+	w.size = offset
+	if fromSeq > w.seq {
+		w.seq = fromSeq
+	}
+	_, err := w.f.Seek(offset, io.SeekStart)
+	return applied, err
+}
+
+func readWALRecord(r io.Reader, maxLen int) (uint64, []models.Metrics, int64, error) {
+	header := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(r, header); err == io.EOF {
+		return 0, nil, 0, io.EOF
+	} else if err != nil {
+		return 0, nil, 0, errTornRecord
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	if int64(length) > int64(maxLen) {
+		return 0, nil, 0, errTornRecord
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, 0, errTornRecord
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header[8:])
+	crc.Write(payload)
+	if crc.Sum32() != binary.BigEndian.Uint32(header[4:8]) {
+		return 0, nil, 0, errTornRecord
+	}
+	var updates []models.Metrics
+	if err := json.Unmarshal(payload, &updates); err != nil {
+		return 0, nil, 0, errTornRecord
+	}
+	return binary.BigEndian.Uint64(header[8:16]), updates, int64(walHeaderSize) + int64(length), nil
+}
+
+// append writes updates as a single record, sync makes it durable
+func (w *writeAheadLog) append(updates []models.Metrics, sync bool) error {
+	payload, err := json.Marshal(updates)
+	if err != nil {
+		return err
+	}
+	if len(payload) > w.maxRecordLen {
+		return fmt.Errorf("%w: %d bytes", ErrBatchTooLarge, len(payload))
+	}
+	record := encodeWALRecord(w.seq+1, payload)
+	if _, err := w.f.Write(record); err != nil {
+		// drop partially written record
+		w.f.Truncate(w.size)
+		w.f.Seek(w.size, io.SeekStart)
+		return err
+	}
+	w.seq++
+	w.size += int64(len(record))
+	if sync {
+		return w.f.Sync()
+	}
+	return nil
+}
+
+func encodeWALRecord(seq uint64, payload []byte) []byte {
+	record := make([]byte, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint64(record[8:16], seq)
+	copy(record[walHeaderSize:], payload)
+	crc := crc32.NewIEEE()
+	crc.Write(record[8:])
+	binary.BigEndian.PutUint32(record[4:8], crc.Sum32())
+	return record
+}
+
+func (w *writeAheadLog) sync() error {
+	return w.f.Sync()
+}
+
+// reset drops records covered by snapshot, sequence keeps growing
+func (w *writeAheadLog) reset() error {
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.size = 0
+	return w.f.Sync()
+}
+
+func (w *writeAheadLog) close() error {
+	return w.f.Close()
+}
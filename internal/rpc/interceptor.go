@@ -0,0 +1,278 @@
+package rpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/middleware"
+	pb "github.com/funkymotions/go-ya-practicum-metrics/internal/proto"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// check inspects call before handler, returned context replaces call one
+type check func(ctx context.Context, method string) (context.Context, error)
+
+func unaryCheck(c check) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := c(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func streamCheck(c check) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := c(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// metadataValue returns the first value of key, keys are case insensitive
+// so HTTP header names are used
+func metadataValue(ctx context.Context, key string) string {
+	values := metadata.ValueFromIncomingContext(ctx, key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// clientIP returns address of call peer, X-Real-IP metadata is used
+// only when peer is one of trusted proxies since clients may set it
+func clientIP(ctx context.Context, trustedProxies []*net.IPNet) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	for _, proxy := range trustedProxies {
+		if proxy.Contains(ip) {
+			if realIP := metadataValue(ctx, access.RealIPHeader); realIP != "" {
+				return realIP
+			}
+			break
+		}
+	}
+	return ip.String()
+}
+
+// accessCheck rejects calls not allowed to use method scope by current
+// policy, methods of unknown scope require admin, nil policy source
+// disables access control
+func accessCheck(policy func() *access.Policy, trustedProxies []*net.IPNet) check {
+	return func(ctx context.Context, method string) (context.Context, error) {
+		if policy == nil {
+			return ctx, nil
+		}
+		scope, ok := scopes[method]
+		if !ok {
+			scope = access.ScopeAdmin
+		}
+		err := policy().Check(
+			clientIP(ctx, trustedProxies),
+			metadataValue(ctx, access.APIKeyHeader),
+			scope,
+		)
+		if err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return ctx, nil
+	}
+}
+
+// tenantCheck puts tenant of call into its context the same way
+// as middleware.TenantHandler does for HTTP requests
+func tenantCheck(policy func() *access.Policy) check {
+	return func(ctx context.Context, _ string) (context.Context, error) {
+		id := metadataValue(ctx, tenant.Header)
+		if id != "" && !tenant.Valid(id) {
+			return nil, status.Error(codes.InvalidArgument, "invalid "+tenant.Header+" metadata")
+		}
+		if policy != nil {
+			bound, ok := policy().KeyTenant(metadataValue(ctx, access.APIKeyHeader))
+			if ok && id != "" && id != bound {
+				return nil, status.Error(codes.PermissionDenied, "API key is bound to another tenant")
+			}
+			if ok {
+				id = bound
+			}
+		}
+		return tenant.WithID(ctx, id), nil
+	}
+}
+
+func logUnary(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		finish := logCall(logger, info.FullMethod)
+		resp, err := handler(ctx, req)
+		finish(err)
+		return resp, err
+	}
+}
+
+func logStream(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		finish := logCall(logger, info.FullMethod)
+		err := handler(srv, ss)
+		finish(err)
+		return err
+	}
+}
+
+// recoverUnary turns panic of handler into Internal status, so one
+// malformed call does not bring server down
+func recoverUnary(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer recoverCall(logger, info.FullMethod, &err)
+		return handler(ctx, req)
+	}
+}
+
+func recoverStream(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer recoverCall(logger, info.FullMethod, &err)
+		return handler(srv, ss)
+	}
+}
+
+// recoverCall must be deferred directly by interceptor
+func recoverCall(logger *zap.Logger, method string, err *error) {
+	if r := recover(); r != nil {
+		logger.Error("gRPC handler panicked",
+			zap.String("method", method),
+			zap.Any("panic", r),
+			zap.Stack("stack"),
+		)
+		*err = status.Error(codes.Internal, "internal error")
+	}
+}
+
+// logCall logs start of call and returns function logging its end
+func logCall(logger *zap.Logger, method string) func(err error) {
+	start := time.Now()
+	logger.Info("New gRPC request",
+		zap.String("method", method),
+	)
+	return func(err error) {
+		logger.Info("gRPC request finished",
+			zap.String("method", method),
+			zap.Duration("elapsedTime", time.Since(start)),
+			zap.String("code", status.Code(err).String()),
+		)
+	}
+}
+
+// signature returns signature of write call, nil when call is not signed
+func signature(ctx context.Context, key []byte, method string) ([]byte, error) {
+	if len(key) == 0 || scopes[method] != access.ScopeWrite {
+		return nil, nil
+	}
+	value := metadataValue(ctx, middleware.HashHeader)
+	if value == "" {
+		return nil, status.Error(codes.Unauthenticated, "missing "+middleware.HashHeader+" metadata")
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid "+middleware.HashHeader+" signature")
+	}
+	return decoded, nil
+}
+
+// hashUnary rejects write calls without valid signature of request,
+// empty key disables verification
+func hashUnary(key []byte) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		expected, err := signature(ctx, key, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		if expected == nil {
+			return handler(ctx, req)
+		}
+		signer := pb.NewSigner(key)
+		if err := signer.Add(req); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		if !hmac.Equal(expected, signer.Sum()) {
+			return nil, status.Error(codes.Unauthenticated, "invalid "+middleware.HashHeader+" signature")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// hashStream verifies signature of streaming write calls once client
+// closes stream, so handler must not store anything before end of stream
+func hashStream(key []byte) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		expected, err := signature(ss.Context(), key, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		if expected == nil {
+			return handler(srv, ss)
+		}
+		return handler(srv, &signedStream{
+			ServerStream: ss,
+			signer:       pb.NewSigner(key),
+			expected:     expected,
+		})
+	}
+}
+
+// signedStream accumulates signature of received messages and replaces
+// end of stream with error when signature does not match
+type signedStream struct {
+	grpc.ServerStream
+	signer   *pb.Signer
+	expected []byte
+}
+
+func (s *signedStream) RecvMsg(m any) error {
+	err := s.ServerStream.RecvMsg(m)
+	if errors.Is(err, io.EOF) {
+		if !hmac.Equal(s.expected, s.signer.Sum()) {
+			return status.Error(codes.Unauthenticated, "invalid "+middleware.HashHeader+" signature")
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.signer.Add(m); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	pb "github.com/funkymotions/go-ya-practicum-metrics/internal/proto"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	// agents gzip large batches
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// scopes of service methods, write calls are signed as well
+var scopes = map[string]access.Scope{
+	pb.MetricsService_UpdateMetrics_FullMethodName: access.ScopeWrite,
+	pb.MetricsService_StreamUpdates_FullMethodName: access.ScopeWrite,
+	pb.MetricsService_GetMetric_FullMethodName:     access.ScopeRead,
+}
+
+// metricService is the part of HTTP handlers service used by gRPC
+type metricService interface {
+	SetMetrics(ctx context.Context, metrics []models.Metrics) error
+	GetMetricByModel(ctx context.Context, m *models.Metrics) (*models.Metrics, error)
+}
+
+type metricServer struct {
+	pb.UnimplementedMetricsServiceServer
+	service        metricService
+	hashKey        []byte
+	policy         func() *access.Policy
+	trustedProxies []*net.IPNet
+	maxBatchSize   int64
+}
+
+func NewMetricServer(s metricService) *metricServer {
+	return &metricServer{
+		service: s,
+	}
+}
+
+// WithHashKey enables signature verification of write calls
+func (s *metricServer) WithHashKey(key []byte) *metricServer {
+	s.hashKey = key
+	return s
+}
+
+// WithAccessPolicy enables access control of methods by scope,
+// policy is requested per call so it may be reloaded
+func (s *metricServer) WithAccessPolicy(policy func() *access.Policy) *metricServer {
+	s.policy = policy
+	return s
+}
+
+// WithTrustedProxies makes access control take client address from
+// X-Real-IP metadata of calls coming from proxies, other calls are
+// checked by their peer address
+func (s *metricServer) WithTrustedProxies(proxies []*net.IPNet) *metricServer {
+	s.trustedProxies = proxies
+	return s
+}
+
+// WithMaxBatchSize limits encoded size of metrics sent in one call,
+// zero means no limit
+func (s *metricServer) WithMaxBatchSize(size int64) *metricServer {
+	s.maxBatchSize = size
+	return s
+}
+
+// NewGRPCServer returns server serving metrics service behind
+// logging, recovery, access, tenant and signature interceptors
+func (s *metricServer) NewGRPCServer(logger *zap.Logger, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(
+			logUnary(logger),
+			recoverUnary(logger),
+			unaryCheck(accessCheck(s.policy, s.trustedProxies)),
+			unaryCheck(tenantCheck(s.policy)),
+			hashUnary(s.hashKey),
+		),
+		grpc.ChainStreamInterceptor(
+			logStream(logger),
+			recoverStream(logger),
+			streamCheck(accessCheck(s.policy, s.trustedProxies)),
+			streamCheck(tenantCheck(s.policy)),
+			hashStream(s.hashKey),
+		),
+	)
+	srv := grpc.NewServer(opts...)
+	pb.RegisterMetricsServiceServer(srv, s)
+	return srv
+}
+
+func (s *metricServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if s.maxBatchSize > 0 && int64(proto.Size(req)) > s.maxBatchSize {
+		return nil, status.Errorf(codes.ResourceExhausted, "batch exceeds %d bytes", s.maxBatchSize)
+	}
+	metrics, err := pb.ToModels(req.GetMetrics())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := s.service.SetMetrics(ctx, metrics); err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.UpdateMetricsResponse{}, nil
+}
+
+// StreamUpdates collects metrics until client closes stream and stores
+// them at once, so broken stream stores nothing
+func (s *metricServer) StreamUpdates(stream grpc.ClientStreamingServer[pb.UpdateMetricsRequest, pb.UpdateMetricsResponse]) error {
+	var metrics []models.Metrics
+	var size int64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		size += int64(proto.Size(req))
+		if s.maxBatchSize > 0 && size > s.maxBatchSize {
+			return status.Errorf(codes.ResourceExhausted, "streamed batch exceeds %d bytes", s.maxBatchSize)
+		}
+		batch, err := pb.ToModels(req.GetMetrics())
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		metrics = append(metrics, batch...)
+	}
+	if err := s.service.SetMetrics(stream.Context(), metrics); err != nil {
+		return statusError(err)
+	}
+	return stream.SendAndClose(&pb.UpdateMetricsResponse{})
+}
+
+func (s *metricServer) GetMetric(ctx context.Context, req *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	m, err := s.service.GetMetricByModel(ctx, &models.Metrics{
+		ID:     req.GetId(),
+		MType:  req.GetType(),
+		Labels: req.GetLabels(),
+	})
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &pb.GetMetricResponse{Metric: pb.FromModel(m)}, nil
+}
+
+// statusError converts service error into status with code matching
+// HTTP status of the same error
+func statusError(err error) error {
+	var metricErr *service.InvalidMetricError
+	if !errors.As(err, &metricErr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	switch metricErr.StatusCode {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, metricErr.Message)
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, metricErr.Message)
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, metricErr.Message)
+	case http.StatusRequestEntityTooLarge:
+		return status.Error(codes.ResourceExhausted, metricErr.Message)
+	default:
+		return status.Error(codes.Internal, metricErr.Message)
+	}
+}
@@ -0,0 +1,369 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/middleware"
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	pb "github.com/funkymotions/go-ya-practicum-metrics/internal/proto"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/repository"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/service"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tenant"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var testKey = []byte("secret")
+
+// startServer serves s on loopback, so calls have peer address checked
+// by access control, and returns client of it
+func startServer(t *testing.T, s *metricServer, logger *zap.Logger) pb.MetricsServiceClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := s.NewGRPCServer(logger)
+	go srv.Serve(listener)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient(listener.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsServiceClient(conn)
+}
+
+func newTestServer() *metricServer {
+	s := service.NewMetricService(repository.NewMemoryStorage()).
+		WithRetryPolicy(utils.RetryPolicy{})
+	return NewMetricServer(s)
+}
+
+func gauge(id string, value float64) *pb.Metric {
+	return &pb.Metric{Id: id, Type: "gauge", Value: &value}
+}
+
+func counter(id string, delta int64) *pb.Metric {
+	return &pb.Metric{Id: id, Type: "counter", Delta: &delta}
+}
+
+// signed returns ctx carrying signature of call sending msgs
+func signed(t *testing.T, ctx context.Context, msgs ...proto.Message) context.Context {
+	signature, err := pb.Sign(testKey, msgs...)
+	require.NoError(t, err)
+	return metadata.AppendToOutgoingContext(ctx, middleware.HashHeader, signature)
+}
+
+func TestMetricServer_UpdateAndGet(t *testing.T) {
+	client := startServer(t, newTestServer(), zap.NewNop())
+	ctx := context.Background()
+	_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{gauge("Alloc", 1.5), counter("PollCount", 2), counter("PollCount", 3)},
+	})
+	require.NoError(t, err)
+	resp, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Type: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), resp.GetMetric().GetDelta())
+	resp, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Alloc", Type: "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, resp.GetMetric().GetValue())
+	// tenant of metadata owns its own series
+	teamCtx := metadata.AppendToOutgoingContext(ctx, tenant.Header, "team-a")
+	_, err = client.GetMetric(teamCtx, &pb.GetMetricRequest{Id: "Alloc", Type: "gauge"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestMetricServer_Errors(t *testing.T) {
+	client := startServer(t, newTestServer(), zap.NewNop())
+	tests := []struct {
+		name     string
+		call     func(ctx context.Context) error
+		wantCode codes.Code
+	}{
+		{
+			name: "should reject counter without delta",
+			call: func(ctx context.Context) error {
+				_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{
+					Metrics: []*pb.Metric{{Id: "PollCount", Type: "counter"}},
+				})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "should reject unknown type",
+			call: func(ctx context.Context) error {
+				_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{
+					Metrics: []*pb.Metric{{Id: "Alloc", Type: "histogram"}},
+				})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "should report missing metric",
+			call: func(ctx context.Context) error {
+				_, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Missing", Type: "gauge"})
+				return err
+			},
+			wantCode: codes.NotFound,
+		},
+		{
+			name: "should reject invalid tenant",
+			call: func(ctx context.Context) error {
+				ctx = metadata.AppendToOutgoingContext(ctx, tenant.Header, "team a")
+				_, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Alloc", Type: "gauge"})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantCode, status.Code(tt.call(context.Background())))
+		})
+	}
+}
+
+func TestMetricServer_StreamUpdates(t *testing.T) {
+	client := startServer(t, newTestServer().WithHashKey(testKey), zap.NewNop())
+	batches := []*pb.UpdateMetricsRequest{
+		{Metrics: []*pb.Metric{counter("PollCount", 2)}},
+		{Metrics: []*pb.Metric{counter("PollCount", 3), gauge("Alloc", 7)}},
+	}
+	send := func(ctx context.Context, batches ...*pb.UpdateMetricsRequest) error {
+		stream, err := client.StreamUpdates(ctx)
+		require.NoError(t, err)
+		for _, batch := range batches {
+			require.NoError(t, stream.Send(batch))
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+	// signature of the first batch only does not cover the whole stream
+	err := send(signed(t, context.Background(), batches[0]), batches...)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "PollCount", Type: "counter"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "rejected stream must store nothing")
+
+	require.NoError(t, send(signed(t, context.Background(), batches[0], batches[1]), batches...))
+	resp, err := client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "PollCount", Type: "counter"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), resp.GetMetric().GetDelta())
+}
+
+func TestMetricServer_StreamUpdatesLimit(t *testing.T) {
+	client := startServer(t, newTestServer().WithMaxBatchSize(64), zap.NewNop())
+	stream, err := client.StreamUpdates(context.Background())
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("Alloc", 1)}}))
+	}
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestMetricServer_UpdateMetricsLimit(t *testing.T) {
+	client := startServer(t, newTestServer().WithMaxBatchSize(64), zap.NewNop())
+	small := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("Alloc", 1)}}
+	_, err := client.UpdateMetrics(context.Background(), small)
+	require.NoError(t, err)
+	large := &pb.UpdateMetricsRequest{}
+	for i := 0; i < 10; i++ {
+		large.Metrics = append(large.Metrics, gauge("Alloc", 1))
+	}
+	_, err = client.UpdateMetrics(context.Background(), large)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestMetricServer_Hash(t *testing.T) {
+	client := startServer(t, newTestServer().WithHashKey(testKey), zap.NewNop())
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("Alloc", 1)}}
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		wantCode codes.Code
+	}{
+		{
+			name:     "should accept signed request",
+			ctx:      signed(t, context.Background(), req),
+			wantCode: codes.OK,
+		},
+		{
+			name:     "should reject unsigned request",
+			ctx:      context.Background(),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name: "should reject request signed by another key",
+			ctx: metadata.AppendToOutgoingContext(context.Background(), middleware.HashHeader, func() string {
+				signature, err := pb.Sign([]byte("guess"), req)
+				require.NoError(t, err)
+				return signature
+			}()),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "should reject signature of other request",
+			ctx:      signed(t, context.Background(), &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("Alloc", 2)}}),
+			wantCode: codes.Unauthenticated,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.UpdateMetrics(tt.ctx, req)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+	// reads are not signed
+	_, err := client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "Alloc", Type: "gauge"})
+	assert.NoError(t, err)
+}
+
+func TestMetricServer_Access(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"trustedSubnets": ["10.0.0.0/8"],
+		"keys": [
+			{"name": "agent", "key": "agent-key", "scopes": ["write"], "tenant": "team-a"},
+			{"name": "viewer", "key": "viewer-key", "scopes": ["read"]}
+		]
+	}`), 0600))
+	policy, err := access.LoadPolicy(path)
+	require.NoError(t, err)
+	_, loopback, err := net.ParseCIDR("127.0.0.0/8")
+	require.NoError(t, err)
+	// test client acts as proxy passing address of agent
+	client := startServer(t, newTestServer().
+		WithAccessPolicy(func() *access.Policy { return policy }).
+		WithTrustedProxies([]*net.IPNet{loopback}), zap.NewNop())
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("Alloc", 1)}}
+	tests := []struct {
+		name     string
+		md       []string
+		wantCode codes.Code
+		wantMsg  string
+	}{
+		{
+			name:     "should accept trusted agent",
+			md:       []string{access.RealIPHeader, "10.0.0.7", access.APIKeyHeader, "agent-key"},
+			wantCode: codes.OK,
+		},
+		{
+			name:     "should reject untrusted address",
+			md:       []string{access.RealIPHeader, "172.16.0.1", access.APIKeyHeader, "agent-key"},
+			wantCode: codes.PermissionDenied,
+			wantMsg:  "access denied: 172.16.0.1 is not in trusted subnets",
+		},
+		{
+			name:     "should reject key without write scope",
+			md:       []string{access.RealIPHeader, "10.0.0.7", access.APIKeyHeader, "viewer-key"},
+			wantCode: codes.PermissionDenied,
+			wantMsg:  "access denied: API key viewer lacks write scope",
+		},
+		{
+			name: "should reject tenant other than bound to key",
+			md: []string{
+				access.RealIPHeader, "10.0.0.7",
+				access.APIKeyHeader, "agent-key",
+				tenant.Header, "team-b",
+			},
+			wantCode: codes.PermissionDenied,
+			wantMsg:  "API key is bound to another tenant",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.AppendToOutgoingContext(context.Background(), tt.md...)
+			_, err := client.UpdateMetrics(ctx, req)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			if tt.wantMsg != "" {
+				assert.Equal(t, tt.wantMsg, status.Convert(err).Message())
+			}
+		})
+	}
+	// write of trusted agent went to tenant bound to its key
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		access.APIKeyHeader, "viewer-key",
+		tenant.Header, "team-a",
+	)
+	resp, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Alloc", Type: "gauge"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, resp.GetMetric().GetValue())
+}
+
+func TestMetricServer_AccessUntrustedPeer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"trustedSubnets": ["10.0.0.0/8"],
+		"keys": [{"name": "agent", "key": "agent-key", "scopes": ["write"]}]
+	}`), 0600))
+	policy, err := access.LoadPolicy(path)
+	require.NoError(t, err)
+	client := startServer(t, newTestServer().WithAccessPolicy(func() *access.Policy { return policy }), zap.NewNop())
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		access.RealIPHeader, "10.0.0.7",
+		access.APIKeyHeader, "agent-key",
+	)
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("Alloc", 1)}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, "access denied: 127.0.0.1 is not in trusted subnets", status.Convert(err).Message())
+}
+
+func TestMetricServer_InvalidName(t *testing.T) {
+	client := startServer(t, newTestServer(), zap.NewNop())
+	for _, id := range []string{"", "bad name"} {
+		_, err := client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+			Metrics: []*pb.Metric{gauge(id, 1)},
+		})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), id)
+	}
+}
+
+type panickingService struct {
+	metricService
+}
+
+func (panickingService) SetMetrics(context.Context, []models.Metrics) error {
+	panic("boom")
+}
+
+func TestMetricServer_Recover(t *testing.T) {
+	core, logs := observer.New(zap.ErrorLevel)
+	client := startServer(t, NewMetricServer(panickingService{}), zap.New(core))
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{gauge("Alloc", 1)}}
+	_, err := client.UpdateMetrics(context.Background(), req)
+	assert.Equal(t, codes.Internal, status.Code(err))
+
+	stream, err := client.StreamUpdates(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(req))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Len(t, logs.FilterMessage("gRPC handler panicked").All(), 2)
+}
+
+func TestMetricServer_Logging(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	client := startServer(t, newTestServer(), zap.New(core))
+	_, err := client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "Missing", Type: "gauge"})
+	require.Error(t, err)
+	finished := logs.FilterMessage("gRPC request finished").All()
+	require.Len(t, finished, 1)
+	fields := finished[0].ContextMap()
+	assert.Equal(t, pb.MetricsService_GetMetric_FullMethodName, fields["method"])
+	assert.Equal(t, codes.NotFound.String(), fields["code"])
+	assert.Len(t, logs.FilterMessage("New gRPC request").All(), 1)
+}
@@ -1,52 +1,84 @@
 package server
 
 import (
+	"crypto/rsa"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/funkymotions/go-ya-practicum-metrics/internal/config/db"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/access"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/alert"
 	appenv "github.com/funkymotions/go-ya-practicum-metrics/internal/config/env"
-	"github.com/funkymotions/go-ya-practicum-metrics/internal/driver"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/encryption"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/handler"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/logger"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/middleware"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/notifier"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/repository"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/rpc"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/service"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/statsd"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/tlsconfig"
 	"github.com/go-chi/chi"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type Server struct {
-	server            *http.Server
-	logger            *zap.Logger
-	stopCh            chan struct{}
-	doneCh            chan struct{}
-	shouldWaitForDone bool
+	server *http.Server
+	// nil when gRPC is disabled
+	grpcServer *grpc.Server
+	grpcAddr   string
+	logger     *zap.Logger
+	storage    repository.Storage
+	stopCh     chan struct{}
+	// background goroutines close their done channels on exit
+	doneChs []chan struct{}
 }
 
 func (s *Server) Run() error {
+	if s.grpcServer != nil {
+		listener, err := net.Listen("tcp", s.grpcAddr)
+		if err != nil {
+			return err
+		}
+		s.logger.Info("Starting gRPC server", zap.String("addr", s.grpcAddr))
+		go func() {
+			if err := s.grpcServer.Serve(listener); err != nil {
+				s.logger.Error("gRPC server stopped", zap.Error(err))
+			}
+		}()
+	}
+	if s.server.TLSConfig != nil {
+		s.logger.Info("Starting server with TLS", zap.String("addr", s.server.Addr))
+		// certificates are served by TLSConfig
+		return s.server.ListenAndServeTLS("", "")
+	}
 	s.logger.Info("Starting server", zap.String("addr", s.server.Addr))
 	return s.server.ListenAndServe()
 }
 
 func (s *Server) Shutdown() {
 	s.logger.Warn("Shutting down server", zap.String("addr", s.server.Addr))
+	if s.grpcServer != nil {
+		// in-flight calls finish before storage is closed
+		s.grpcServer.GracefulStop()
+	}
 	// notify all subscribed goroutines to exit
 	close(s.stopCh)
-	if s.shouldWaitForDone {
-		<-s.doneCh
-		s.logger.Info("All goroutines have exited")
+	for _, doneCh := range s.doneChs {
+		<-doneCh
+	}
+	s.logger.Info("All goroutines have exited")
+	if err := s.storage.Close(); err != nil {
+		s.logger.Error("Failed to close storage", zap.Error(err))
 	}
 }
 
 func NewServer(v *appenv.Variables) *Server {
-	// db
-	if v.DatabaseDSN == nil {
-		log.Fatal("database dsn is not set")
-	}
-	dbConf := db.NewDBConfig(*v.DatabaseDSN)
-	d, _ := driver.NewSQLDriver(dbConf)
 	// logger
 	logger, err := logger.NewLogger(zap.NewAtomicLevelAt(zap.InfoLevel))
 	if err != nil {
@@ -54,34 +86,174 @@ func NewServer(v *appenv.Variables) *Server {
 	}
 	// channels
 	stopCh := make(chan struct{})
-	doneCh := make(chan struct{})
+	var doneChs []chan struct{}
+	// access control
+	var policy func() *access.Policy
+	if *v.AccessPolicyPath != "" {
+		reloader, err := access.NewReloader(*v.AccessPolicyPath, 10*time.Second, logger)
+		if err != nil {
+			log.Fatalf("failed to load access policy: %v", err)
+		}
+		accessDoneCh := make(chan struct{})
+		go reloader.Run(stopCh, accessDoneCh)
+		doneChs = append(doneChs, accessDoneCh)
+		policy = reloader.Policy
+	}
 	// repositories
-	metricRepo := repository.NewMetricRepository(
-		*v.FileStoragePath,
-		*v.Restore,
-		time.Second*time.Duration(*v.StoreInterval),
-		d,
-		stopCh,
-		doneCh,
-	)
+	metricRepo, err := repository.NewStorage(&repository.Config{
+		DatabaseDSN:   *v.DatabaseDSN,
+		FilePath:      *v.FileStoragePath,
+		Restore:       *v.Restore,
+		StoreInterval: time.Second * time.Duration(*v.StoreInterval),
+		Timeouts: repository.Timeouts{
+			Read:  time.Millisecond * time.Duration(*v.DBReadTimeout),
+			Write: time.Millisecond * time.Duration(*v.DBWriteTimeout),
+			Ping:  time.Millisecond * time.Duration(*v.DBPingTimeout),
+		},
+		Logger: logger,
+	})
+	if err != nil {
+		log.Fatalf("failed to initialize storage: %v", err)
+	}
+	// tenants
+	storage := metricRepo
+	if *v.TenantMaxSeries > 0 || policy != nil {
+		storage = repository.WithSeriesQuota(metricRepo, func(id string) int {
+			if policy != nil {
+				if limit, ok := policy().MaxSeries(id); ok {
+					return limit
+				}
+			}
+			return int(*v.TenantMaxSeries)
+		})
+	}
 	// services
-	metricService := service.NewMetricService(metricRepo, []byte(*v.Key))
+	metricService := service.NewMetricService(storage).
+		WithPrometheusPrefix(*v.PromPrefix).
+		WithRetryPolicy(v.RetryPolicy())
 	// handlers
-	metricHandler := handler.NewMetricHandler(metricService)
+	metricHandler := handler.NewMetricHandler(metricService).
+		WithHashKey([]byte(*v.Key)).
+		WithAccessPolicy(policy)
+	if breaker, ok := metricRepo.(repository.BreakerReporter); ok {
+		metricHandler.WithBreaker(breaker)
+	}
+	// alerting
+	if *v.AlertRulesPath != "" {
+		rules, err := alert.LoadRules(*v.AlertRulesPath)
+		if err != nil {
+			log.Fatalf("failed to load alerting rules: %v", err)
+		}
+		// rules read series of their own tenants
+		alertEngine := alert.NewEngine(
+			rules,
+			storage,
+			time.Second*time.Duration(*v.AlertInterval),
+			logger,
+		)
+		if *v.AlertWebhooks != "" {
+			alertEngine.AddNotifier(notifier.NewWebhookNotifier(&notifier.Config{
+				URLs:           strings.Split(*v.AlertWebhooks, ","),
+				Key:            []byte(*v.Key),
+				HeaderName:     middleware.HashHeader,
+				RepeatInterval: time.Second * time.Duration(*v.AlertRepeat),
+				Retry:          v.RetryPolicy(),
+				Client: &http.Client{
+					Timeout: 5 * time.Second,
+				},
+				Logger: logger,
+			}))
+		}
+		alertDoneCh := make(chan struct{})
+		go alertEngine.Run(stopCh, alertDoneCh)
+		doneChs = append(doneChs, alertDoneCh)
+		metricHandler.WithAlerts(alertEngine)
+		logger.Info("Alerting rules loaded", zap.Int("count", len(rules)))
+	}
+	// statsd
+	if *v.StatsDAddress != "" {
+		listener, err := statsd.NewListener(statsd.Config{
+			Address:       *v.StatsDAddress,
+			FlushInterval: time.Second * time.Duration(*v.StatsDFlushInterval),
+			QueueSize:     *v.StatsDQueueSize,
+			Logger:        logger,
+		}, metricService)
+		if err != nil {
+			log.Fatalf("failed to start StatsD listener: %v", err)
+		}
+		statsdDoneCh := make(chan struct{})
+		go listener.Run(stopCh, statsdDoneCh)
+		doneChs = append(doneChs, statsdDoneCh)
+		logger.Info("StatsD listener started", zap.Stringer("addr", listener.Addr()))
+	}
+	// encryption
+	var privateKey *rsa.PrivateKey
+	if *v.CryptoKey != "" {
+		privateKey, err = encryption.LoadPrivateKey(*v.CryptoKey)
+		if err != nil {
+			log.Fatalf("failed to load private key: %v", err)
+		}
+	}
 	// routing
 	r := chi.NewRouter()
 	r.Use(middleware.HTTPLogMiddleware(logger))
+	r.Use(middleware.TenantHandler(policy))
+	r.Use(middleware.DecryptHandler(privateKey, *v.MaxBodySize))
+	r.Use(middleware.RequestTimeout(time.Millisecond * time.Duration(*v.RequestTimeout)))
+	r.Use(middleware.DecompressHandler(*v.MaxBodySize))
 	// register metrics entries
 	metricHandler.Register(r)
 	httpSrv := &http.Server{
 		Addr:    *v.Endpoint,
 		Handler: r,
 	}
+	// tls
+	var tlsReloader *tlsconfig.Reloader
+	if *v.TLSCertFile != "" {
+		tlsConfig := v.TLSConfig()
+		tlsConfig.Logger = logger
+		tlsReloader, err = tlsconfig.NewReloader(tlsConfig)
+		if err != nil {
+			log.Fatalf("failed to load TLS files: %v", err)
+		}
+		httpSrv.TLSConfig = tlsReloader.ServerConfig()
+		tlsDoneCh := make(chan struct{})
+		go tlsReloader.Run(stopCh, tlsDoneCh)
+		doneChs = append(doneChs, tlsDoneCh)
+	} else if *v.TLSKeyFile != "" || *v.TLSCAFile != "" {
+		log.Fatal("TLS key and CA bundle require server certificate")
+	}
+	// grpc
+	var grpcSrv *grpc.Server
+	if *v.GRPCAddress != "" {
+		var opts []grpc.ServerOption
+		if tlsReloader != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsReloader.ServerConfig())))
+		}
+		var trustedProxies []*net.IPNet
+		if *v.GRPCTrustedProxies != "" {
+			for _, cidr := range strings.Split(*v.GRPCTrustedProxies, ",") {
+				_, proxy, err := net.ParseCIDR(strings.TrimSpace(cidr))
+				if err != nil {
+					log.Fatalf("invalid gRPC trusted proxy %q: %v", cidr, err)
+				}
+				trustedProxies = append(trustedProxies, proxy)
+			}
+		}
+		grpcSrv = rpc.NewMetricServer(metricService).
+			WithHashKey([]byte(*v.Key)).
+			WithAccessPolicy(policy).
+			WithTrustedProxies(trustedProxies).
+			WithMaxBatchSize(*v.MaxBodySize).
+			NewGRPCServer(logger, opts...)
+	}
 	return &Server{
-		server:            httpSrv,
-		logger:            logger,
-		stopCh:            stopCh,
-		doneCh:            doneCh,
-		shouldWaitForDone: *v.StoreInterval != 0,
+		server:     httpSrv,
+		grpcServer: grpcSrv,
+		grpcAddr:   *v.GRPCAddress,
+		logger:     logger,
+		storage:    metricRepo,
+		stopCh:     stopCh,
+		doneChs:    doneChs,
 	}
 }
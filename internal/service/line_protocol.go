@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/lineprotocol"
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+)
+
+// WriteLineProtocol stores InfluxDB line protocol batch: every numeric
+// field becomes series named measurement_field labelled by tags, floats
+// are gauges and integers are counter deltas, string and boolean fields
+// are skipped; timestamps, which Telegraf always sends, are validated
+// but samples are recorded at arrival time; accepted lines are stored
+// in one write
+func (s *metricService) WriteLineProtocol(ctx context.Context, input []byte) (*models.WriteResult, error) {
+	result := &models.WriteResult{}
+	var metrics []models.Metrics
+	scanner := bufio.NewScanner(bytes.NewReader(input))
+	// lines are bounded by body size limit only
+	scanner.Buffer(nil, len(input)+1)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lineMetrics, err := s.lineMetrics(line)
+		if err != nil {
+			result.Errors = append(result.Errors, models.LineError{Line: n, Error: err.Error()})
+			continue
+		}
+		metrics = append(metrics, lineMetrics...)
+		result.Accepted++
+	}
+	if len(metrics) == 0 {
+		return result, nil
+	}
+	if err := s.repo.SetMetricBulk(ctx, &metrics); err != nil {
+		return nil, storageError(err)
+	}
+	return result, nil
+}
+
+func (s *metricService) lineMetrics(line string) ([]models.Metrics, error) {
+	point, err := lineprotocol.Parse(line)
+	if err != nil {
+		return nil, err
+	}
+	var labels models.Labels
+	if len(point.Tags) > 0 {
+		labels = make(models.Labels, len(point.Tags))
+		for key, value := range point.Tags {
+			labels[models.SanitizeLabelName(key)] = value
+		}
+	}
+	if !areLabelsValid(labels, s.labelRe) {
+		return nil, fmt.Errorf("invalid tags, at most %d are allowed", maxLabels)
+	}
+	measurement := models.SanitizeName(point.Measurement)
+	var metrics []models.Metrics
+	for _, field := range point.Fields {
+		m := models.Metrics{
+			ID:     measurement + "_" + models.SanitizeName(field.Key),
+			Labels: labels,
+		}
+		switch value := field.Value.(type) {
+		case float64:
+			m.MType = models.Gauge
+			m.Value = &value
+		case int64:
+			m.MType = models.Counter
+			m.Delta = &value
+		case uint64:
+			delta := int64(value)
+			if delta < 0 {
+				return nil, fmt.Errorf("field %s overflows counter", field.Key)
+			}
+			m.MType = models.Counter
+			m.Delta = &delta
+		default:
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+	if len(metrics) == 0 {
+		return nil, fmt.Errorf("no numeric fields")
+	}
+	return metrics, nil
+}
@@ -2,19 +2,22 @@ package service
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
+	"time"
 
 	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/repository"
 	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
 )
 
+const maxLabels = 16
+
 type InvalidMetricError struct {
 	Message    string
 	StatusCode int
@@ -25,31 +28,45 @@ func (e *InvalidMetricError) Error() string {
 }
 
 type metricRepoInterface interface {
-	SetGauge(name string, parameter float64)
-	SetCounter(name string, parameter int64)
-	SetGaugeIntrospect(name string, parameter float64) error
-	SetCounterIntrospect(name string, parameter int64) error
-	GetMetric(name string, metricType string) (*models.Metrics, bool)
-	GetAllMetrics() map[string]models.Metrics
-	SetMetricBulk(m *[]models.Metrics) error
-	Ping() error
+	SetGauge(ctx context.Context, name string, labels models.Labels, parameter float64) error
+	SetCounter(ctx context.Context, name string, labels models.Labels, parameter int64) error
+	GetMetric(ctx context.Context, name string, metricType string, labels models.Labels) (*models.Metrics, bool)
+	GetAllMetrics(ctx context.Context) map[string]models.Metrics
+	GetHistory(ctx context.Context, name string, metricType string, labels models.Labels, from, to time.Time) ([]models.Sample, error)
+	SetMetricBulk(ctx context.Context, m *[]models.Metrics) error
+	Ping(ctx context.Context) error
 }
 
 type metricService struct {
 	repo       metricRepoInterface
 	re         *regexp.Regexp
-	hashSecret []byte
+	labelRe    *regexp.Regexp
+	promPrefix string
+	retry      utils.RetryPolicy
 }
 
-func NewMetricService(repo metricRepoInterface, hashSecret []byte) *metricService {
+func NewMetricService(repo metricRepoInterface) *metricService {
 	return &metricService{
-		repo:       repo,
-		re:         regexp.MustCompile(`^\w+$`),
-		hashSecret: hashSecret,
+		repo:    repo,
+		re:      regexp.MustCompile(`^\w+$`),
+		labelRe: regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`),
+		retry:   utils.DefaultRetryPolicy(),
 	}
 }
 
-func (s *metricService) SetCounter(name string, rawValue string) error {
+// WithRetryPolicy sets retries of storage writes failed with retriable errors
+func (s *metricService) WithRetryPolicy(p utils.RetryPolicy) *metricService {
+	s.retry = p
+	return s
+}
+
+// WithPrometheusPrefix sets prefix prepended to metric names in exposition
+func (s *metricService) WithPrometheusPrefix(prefix string) *metricService {
+	s.promPrefix = prefix
+	return s
+}
+
+func (s *metricService) SetCounter(ctx context.Context, name string, rawValue string) error {
 	if !isMetricNameAlphanumeric(name, s.re) {
 		return &InvalidMetricError{
 			Message:    fmt.Sprintf("invalid metric name: %s", name),
@@ -63,11 +80,13 @@ func (s *metricService) SetCounter(name string, rawValue string) error {
 			StatusCode: http.StatusBadRequest,
 		}
 	}
-	s.repo.SetCounterIntrospect(name, value)
+	if err := s.repo.SetCounter(ctx, name, nil, value); err != nil {
+		return storageError(err)
+	}
 	return nil
 }
 
-func (s *metricService) SetGauge(name string, rawValue string) error {
+func (s *metricService) SetGauge(ctx context.Context, name string, rawValue string) error {
 	if !isMetricNameAlphanumeric(name, s.re) {
 		return &InvalidMetricError{
 			Message:    fmt.Sprintf("invalid metric name: %s", name),
@@ -81,18 +100,20 @@ func (s *metricService) SetGauge(name string, rawValue string) error {
 			StatusCode: http.StatusBadRequest,
 		}
 	}
-	s.repo.SetGaugeIntrospect(name, value)
+	if err := s.repo.SetGauge(ctx, name, nil, value); err != nil {
+		return storageError(err)
+	}
 	return nil
 }
 
-func (s *metricService) GetMetric(name string, metricType string) (*models.Metrics, error) {
+func (s *metricService) GetMetric(ctx context.Context, name string, metricType string) (*models.Metrics, error) {
 	if !isMetricNameAlphanumeric(name, s.re) {
 		return nil, &InvalidMetricError{
 			Message:    fmt.Sprintf("invalid metric name: %s", name),
 			StatusCode: http.StatusBadRequest,
 		}
 	}
-	m, res := s.repo.GetMetric(name, metricType)
+	m, res := s.repo.GetMetric(ctx, name, metricType, nil)
 	if !res {
 		return nil, &InvalidMetricError{
 			Message:    fmt.Sprintf("metric not found: %s", name),
@@ -102,8 +123,8 @@ func (s *metricService) GetMetric(name string, metricType string) (*models.Metri
 	return m, nil
 }
 
-func (s *metricService) GetAllMetricsForHTML() string {
-	metrics := s.repo.GetAllMetrics()
+func (s *metricService) GetAllMetricsForHTML(ctx context.Context) string {
+	metrics := s.repo.GetAllMetrics(ctx)
 	var result string
 	for _, m := range metrics {
 		result += fmt.Sprintf("%s\n", m.String())
@@ -111,7 +132,7 @@ func (s *metricService) GetAllMetricsForHTML() string {
 	return result
 }
 
-func (s *metricService) SetMetricByModel(input []byte) (*models.Metrics, error) {
+func (s *metricService) SetMetricByModel(ctx context.Context, input []byte) (*models.Metrics, error) {
 	var metric models.Metrics
 	if err := json.NewDecoder(bytes.NewReader(input)).Decode(&metric); err != nil {
 		return nil, &InvalidMetricError{
@@ -119,27 +140,18 @@ func (s *metricService) SetMetricByModel(input []byte) (*models.Metrics, error)
 			StatusCode: http.StatusBadRequest,
 		}
 	}
-	if !isMetricNameAlphanumeric(metric.ID, s.re) {
-		return nil, &InvalidMetricError{
-			Message:    fmt.Sprintf("invalid metric name: %s", metric.ID),
-			StatusCode: http.StatusBadRequest,
-		}
-	}
-	if !isMetricDataOK(&metric) {
-		return nil, &InvalidMetricError{
-			Message:    fmt.Sprintf("invalid metric data: %s", metric.ID),
-			StatusCode: http.StatusBadRequest,
-		}
+	if err := s.validateMetric(&metric); err != nil {
+		return nil, err
 	}
-	var retriableFn func() error
+	var retriableFn func(ctx context.Context) error
 	switch metric.MType {
 	case models.Gauge:
-		retriableFn = func() error {
-			return s.repo.SetGaugeIntrospect(metric.ID, *metric.Value)
+		retriableFn = func(ctx context.Context) error {
+			return s.repo.SetGauge(ctx, metric.ID, metric.Labels, *metric.Value)
 		}
 	case models.Counter:
-		retriableFn = func() error {
-			return s.repo.SetCounterIntrospect(metric.ID, *metric.Delta)
+		retriableFn = func(ctx context.Context) error {
+			return s.repo.SetCounter(ctx, metric.ID, metric.Labels, *metric.Delta)
 		}
 	default:
 		return nil, &InvalidMetricError{
@@ -147,24 +159,26 @@ func (s *metricService) SetMetricByModel(input []byte) (*models.Metrics, error)
 			StatusCode: http.StatusBadRequest,
 		}
 	}
-	// TODO: make maxAttempts configurable
-	if err := utils.WithRetry(retriableFn, 0, 3); err != nil {
-		return nil, &InvalidMetricError{
-			Message:    fmt.Sprintf("failed to set metric: %s", err.Error()),
-			StatusCode: http.StatusInternalServerError,
-		}
+	if err := s.retry.Do(ctx, retriableFn); err != nil {
+		return nil, storageError(err)
 	}
 	return &metric, nil
 }
 
-func (s *metricService) GetMetricByModel(metric *models.Metrics) (*models.Metrics, error) {
+func (s *metricService) GetMetricByModel(ctx context.Context, metric *models.Metrics) (*models.Metrics, error) {
 	if !isMetricNameAlphanumeric(metric.ID, s.re) {
 		return nil, &InvalidMetricError{
 			Message:    fmt.Sprintf("invalid metric name: %s", metric.ID),
 			StatusCode: http.StatusBadRequest,
 		}
 	}
-	m, found := s.repo.GetMetric(metric.ID, metric.MType)
+	if !areLabelsValid(metric.Labels, s.labelRe) {
+		return nil, &InvalidMetricError{
+			Message:    fmt.Sprintf("invalid metric labels: %s", metric.SeriesKey()),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	m, found := s.repo.GetMetric(ctx, metric.ID, metric.MType, metric.Labels)
 	if !found {
 		return nil, &InvalidMetricError{
 			Message:    fmt.Sprintf("metric not found: %s", metric.ID),
@@ -174,19 +188,11 @@ func (s *metricService) GetMetricByModel(metric *models.Metrics) (*models.Metric
 	return m, nil
 }
 
-func (s *metricService) Ping() error {
-	return s.repo.Ping()
+func (s *metricService) Ping(ctx context.Context) error {
+	return s.repo.Ping(ctx)
 }
 
-func (s *metricService) SetMetricBulk(input []byte, signature []byte) error {
-	if len(s.hashSecret) > 0 {
-		if ok := isHashValid(signature, input, s.hashSecret); !ok {
-			return &InvalidMetricError{
-				Message:    "invalid hash",
-				StatusCode: http.StatusBadRequest,
-			}
-		}
-	}
+func (s *metricService) SetMetricBulk(ctx context.Context, input []byte) error {
 	var metrics []models.Metrics
 	if err := json.NewDecoder(bytes.NewReader(input)).Decode(&metrics); err != nil {
 		return &InvalidMetricError{
@@ -194,13 +200,86 @@ func (s *metricService) SetMetricBulk(input []byte, signature []byte) error {
 			StatusCode: http.StatusBadRequest,
 		}
 	}
-	return s.repo.SetMetricBulk(&metrics)
+	return s.SetMetrics(ctx, metrics)
+}
+
+// SetMetrics stores decoded batch, transports other than JSON call it directly
+func (s *metricService) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
+	for i := range metrics {
+		if err := s.validateMetric(&metrics[i]); err != nil {
+			return err
+		}
+	}
+	err := s.repo.SetMetricBulk(ctx, &metrics)
+	var quotaErr *repository.QuotaError
+	if errors.As(err, &quotaErr) || errors.Is(err, repository.ErrBatchTooLarge) {
+		return storageError(err)
+	}
+	return err
+}
+
+// validateMetric checks update the same way for single and batch writes
+func (s *metricService) validateMetric(m *models.Metrics) *InvalidMetricError {
+	if !isMetricNameAlphanumeric(m.ID, s.re) {
+		return &InvalidMetricError{
+			Message:    fmt.Sprintf("invalid metric name: %s", m.ID),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	if !isMetricDataOK(m) {
+		return &InvalidMetricError{
+			Message:    fmt.Sprintf("invalid metric data: %s", m.ID),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	if !areLabelsValid(m.Labels, s.labelRe) {
+		return &InvalidMetricError{
+			Message:    fmt.Sprintf("invalid metric labels: %s", m.SeriesKey()),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	return nil
+}
+
+// storageError converts failed write into response error, writes over
+// tenant quota are forbidden and batches storage can not hold at once
+// are too large rather than failed
+func storageError(err error) *InvalidMetricError {
+	var quotaErr *repository.QuotaError
+	if errors.As(err, &quotaErr) {
+		return &InvalidMetricError{
+			Message:    quotaErr.Error(),
+			StatusCode: http.StatusForbidden,
+		}
+	}
+	if errors.Is(err, repository.ErrBatchTooLarge) {
+		return &InvalidMetricError{
+			Message:    err.Error(),
+			StatusCode: http.StatusRequestEntityTooLarge,
+		}
+	}
+	return &InvalidMetricError{
+		Message:    fmt.Sprintf("failed to set metric: %s", err.Error()),
+		StatusCode: http.StatusInternalServerError,
+	}
 }
 
 func isMetricNameAlphanumeric(input string, r *regexp.Regexp) bool {
 	return r.MatchString(input)
 }
 
+func areLabelsValid(labels models.Labels, r *regexp.Regexp) bool {
+	if len(labels) > maxLabels {
+		return false
+	}
+	for name := range labels {
+		if !r.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
+
 func isMetricDataOK(m *models.Metrics) bool {
 	switch m.MType {
 	case models.Gauge:
@@ -211,18 +290,3 @@ func isMetricDataOK(m *models.Metrics) bool {
 		return false
 	}
 }
-
-func isHashValid(signature, payload, secret []byte) bool {
-	if len(signature) == 0 || len(payload) == 0 || len(secret) == 0 {
-		return false
-	}
-	decodedSignature := make([]byte, sha256.Size)
-	_, err := hex.Decode(decodedSignature, signature)
-	if err != nil {
-		return false
-	}
-	h := hmac.New(sha256.New, secret)
-	h.Write(payload)
-	hash := h.Sum(nil)
-	return hmac.Equal(decodedSignature, hash)
-}
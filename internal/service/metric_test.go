@@ -1,13 +1,19 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"net/http"
 	"reflect"
 	"regexp"
 	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/repository"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/utils"
 	"github.com/magiconair/properties/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -17,38 +23,35 @@ type metricRepoStub struct {
 	mock.Mock
 }
 
-func (m *metricRepoStub) SetGauge(name string, value float64) {
-	m.Called(name, value)
+func (m *metricRepoStub) SetGauge(_ context.Context, name string, labels models.Labels, value float64) error {
+	args := m.Called(name, labels, value)
+	return args.Error(0)
 }
-func (m *metricRepoStub) SetCounter(name string, value int64) {
-	m.Called(name, value)
+func (m *metricRepoStub) SetCounter(_ context.Context, name string, labels models.Labels, value int64) error {
+	args := m.Called(name, labels, value)
+	return args.Error(0)
 }
-func (m *metricRepoStub) GetMetric(name string, metricType string) (*models.Metrics, bool) {
-	args := m.Called(name, metricType)
+func (m *metricRepoStub) GetMetric(_ context.Context, name string, metricType string, labels models.Labels) (*models.Metrics, bool) {
+	args := m.Called(name, metricType, labels)
 	return args.Get(0).(*models.Metrics), args.Bool(1)
 }
 
-func (m *metricRepoStub) GetAllMetrics() map[string]models.Metrics {
+func (m *metricRepoStub) GetAllMetrics(_ context.Context) map[string]models.Metrics {
 	args := m.Called()
 	return args.Get(0).(map[string]models.Metrics)
 }
 
-func (m *metricRepoStub) SetGaugeIntrospect(name string, value float64) error {
-	args := m.Called(name, value)
-	return args.Error(0)
+func (m *metricRepoStub) GetHistory(_ context.Context, name string, metricType string, labels models.Labels, from, to time.Time) ([]models.Sample, error) {
+	args := m.Called(name, metricType, labels, from, to)
+	return args.Get(0).([]models.Sample), args.Error(1)
 }
 
-func (m *metricRepoStub) SetCounterIntrospect(name string, value int64) error {
-	args := m.Called(name, value)
-	return args.Error(0)
-}
-
-func (m *metricRepoStub) Ping() error {
+func (m *metricRepoStub) Ping(_ context.Context) error {
 	args := m.Called()
 	return args.Error(0)
 }
 
-func (m *metricRepoStub) SetMetricBulk(metrics *[]models.Metrics) error {
+func (m *metricRepoStub) SetMetricBulk(_ context.Context, metrics *[]models.Metrics) error {
 	args := m.Called(metrics)
 	return args.Error(0)
 }
@@ -68,15 +71,16 @@ func TestNewMetricService(t *testing.T) {
 				repo: &metricRepoStub{},
 			},
 			want: &metricService{
-				re:         regexp.MustCompile(`^\w+$`),
-				repo:       &metricRepoStub{},
-				hashSecret: []byte(""),
+				re:      regexp.MustCompile(`^\w+$`),
+				labelRe: regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`),
+				repo:    &metricRepoStub{},
+				retry:   utils.DefaultRetryPolicy(),
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			actual := NewMetricService(tt.args.repo, []byte(""))
+			actual := NewMetricService(tt.args.repo)
 			require.True(t, reflect.DeepEqual(actual, tt.want))
 		})
 	}
@@ -127,8 +131,8 @@ func Test_metricService_SetCounter(t *testing.T) {
 				repo: tt.fields.repo,
 				re:   re,
 			}
-			s.repo.(*metricRepoStub).On("SetCounterIntrospect", tt.args.name, int64(1)).Return(nil)
-			err := s.SetCounter(tt.args.name, tt.args.rawValue)
+			s.repo.(*metricRepoStub).On("SetCounter", tt.args.name, models.Labels(nil), int64(1)).Return(nil)
+			err := s.SetCounter(context.Background(), tt.args.name, tt.args.rawValue)
 			assert.Equal(t, tt.wantError, err != nil)
 		})
 	}
@@ -179,8 +183,8 @@ func Test_metricService_SetGauge(t *testing.T) {
 				re:   re,
 			}
 
-			s.repo.(*metricRepoStub).On("SetGaugeIntrospect", tt.args.name, float64(1.1)).Return(nil)
-			err := s.SetGauge(tt.args.name, tt.args.rawValue)
+			s.repo.(*metricRepoStub).On("SetGauge", tt.args.name, models.Labels(nil), float64(1.1)).Return(nil)
+			err := s.SetGauge(context.Background(), tt.args.name, tt.args.rawValue)
 			assert.Equal(t, tt.wantErr, err != nil)
 		})
 	}
@@ -232,7 +236,7 @@ func Test_metricService_GetAllMetricsForHTML(t *testing.T) {
 				repo: tt.fields.repo,
 			}
 			s.repo.(*metricRepoStub).On("GetAllMetrics").Return(tt.repoReturnVal)
-			actual := s.GetAllMetricsForHTML()
+			actual := s.GetAllMetricsForHTML(context.Background())
 			actualLines := strings.Split(actual, "\n")
 			expectedLines := strings.Split(tt.want, "\n")
 			sort.Strings(actualLines)
@@ -241,3 +245,326 @@ func Test_metricService_GetAllMetricsForHTML(t *testing.T) {
 		})
 	}
 }
+
+func Test_metricService_QueryRange(t *testing.T) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+	history := []models.Sample{
+		{Timestamp: start.Add(5 * time.Second), Value: 1},
+		{Timestamp: start.Add(10 * time.Second), Value: 3},
+		{Timestamp: start.Add(35 * time.Second), Value: 4},
+		{Timestamp: start.Add(50 * time.Second), Value: 10},
+	}
+	tests := []struct {
+		name       string
+		query      models.RangeQuery
+		wantPoints []float64
+		wantErr    bool
+	}{
+		{
+			name: "should return raw samples without step",
+			query: models.RangeQuery{
+				ID: "HeapAlloc", MType: models.Gauge, Start: start.Format(time.RFC3339), End: end.Format(time.RFC3339),
+			},
+			wantPoints: []float64{1, 3, 4, 10},
+		},
+		{
+			name: "should average samples per step",
+			query: models.RangeQuery{
+				ID: "HeapAlloc", MType: models.Gauge, Start: "1735689600", End: "1735689660", Step: "30s",
+			},
+			wantPoints: []float64{2, 7},
+		},
+		{
+			name: "should take max per step",
+			query: models.RangeQuery{
+				ID: "HeapAlloc", MType: models.Gauge, Start: "1735689600", End: "1735689660", Step: "30s", Agg: "max",
+			},
+			wantPoints: []float64{3, 10},
+		},
+		{
+			name: "should take last value of counter by default",
+			query: models.RangeQuery{
+				ID: "HeapAlloc", MType: models.Counter, Start: "1735689600", End: "1735689660", Step: "1m",
+			},
+			wantPoints: []float64{10},
+		},
+		{
+			name: "should fail on unknown aggregation",
+			query: models.RangeQuery{
+				ID: "HeapAlloc", MType: models.Gauge, Step: "30s", Agg: "median",
+			},
+			wantErr: true,
+		},
+		{
+			name: "should fail on too many points",
+			query: models.RangeQuery{
+				ID: "HeapAlloc", MType: models.Gauge, Start: "0", Step: "1s",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &metricRepoStub{}
+			repo.On("GetHistory", tt.query.ID, tt.query.MType, tt.query.Labels, mock.Anything, mock.Anything).Return(history, nil)
+			s := NewMetricService(repo)
+			actual, err := s.QueryRange(context.Background(), &tt.query)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var points []float64
+			for _, p := range actual.Points {
+				points = append(points, p.Value)
+			}
+			require.Equal(t, tt.wantPoints, points)
+		})
+	}
+}
+
+func Test_metricService_GetAllMetricsForPrometheus(t *testing.T) {
+	var heap = 1.5e+06
+	var polls, requests int64 = 42, 7
+	repo := &metricRepoStub{}
+	var cpu1, cpu2 = 10.0, 20.5
+	repo.On("GetAllMetrics").Return(map[string]models.Metrics{
+		"gauge:HeapAlloc":        {ID: "HeapAlloc", MType: models.Gauge, Value: &heap},
+		"counter:PollCount":      {ID: "PollCount", MType: models.Counter, Delta: &polls},
+		"counter:requests_total": {ID: "requests_total", MType: models.Counter, Delta: &requests},
+		`gauge:cpu_utilization{cpu="2"}`: {
+			ID: "cpu_utilization", MType: models.Gauge, Value: &cpu2, Labels: models.Labels{"cpu": "2"},
+		},
+		`gauge:cpu_utilization{cpu="1"}`: {
+			ID: "cpu_utilization", MType: models.Gauge, Value: &cpu1, Labels: models.Labels{"cpu": "1"},
+		},
+	})
+	s := NewMetricService(repo).WithPrometheusPrefix("agent_")
+	expected := "# HELP agent_HeapAlloc Gauge metric HeapAlloc.\n" +
+		"# TYPE agent_HeapAlloc gauge\n" +
+		"agent_HeapAlloc 1.5e+06\n" +
+		"# HELP agent_PollCount_total Counter metric PollCount.\n" +
+		"# TYPE agent_PollCount_total counter\n" +
+		"agent_PollCount_total 42\n" +
+		"# HELP agent_cpu_utilization Gauge metric cpu_utilization.\n" +
+		"# TYPE agent_cpu_utilization gauge\n" +
+		"agent_cpu_utilization{cpu=\"1\"} 10\n" +
+		"agent_cpu_utilization{cpu=\"2\"} 20.5\n" +
+		"# HELP agent_requests_total Counter metric requests_total.\n" +
+		"# TYPE agent_requests_total counter\n" +
+		"agent_requests_total 7\n"
+	require.Equal(t, expected, s.GetAllMetricsForPrometheus(context.Background()))
+}
+
+func Test_metricService_GetAllMetricsForPrometheus_FamilyCollision(t *testing.T) {
+	var total = 3.0
+	var requests int64 = 7
+	repo := &metricRepoStub{}
+	repo.On("GetAllMetrics").Return(map[string]models.Metrics{
+		"gauge:requests_total": {ID: "requests_total", MType: models.Gauge, Value: &total},
+		"counter:requests":     {ID: "requests", MType: models.Counter, Delta: &requests},
+	})
+	s := NewMetricService(repo)
+	expected := "# HELP requests_total Counter metric requests.\n" +
+		"# TYPE requests_total counter\n" +
+		"requests_total 7\n"
+	require.Equal(t, expected, s.GetAllMetricsForPrometheus(context.Background()))
+}
+
+func Test_metricService_SetMetricByModel_Labels(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantLabels models.Labels
+		wantErr    bool
+	}{
+		{
+			name:       "should pass labels to repository",
+			body:       `{"id":"cpu_utilization","type":"gauge","value":12.5,"labels":{"cpu":"1"}}`,
+			wantLabels: models.Labels{"cpu": "1"},
+		},
+		{
+			name:       "should accept metric without labels",
+			body:       `{"id":"HeapAlloc","type":"gauge","value":12.5}`,
+			wantLabels: nil,
+		},
+		{
+			name:    "should reject invalid label name",
+			body:    `{"id":"cpu_utilization","type":"gauge","value":12.5,"labels":{"1cpu":"1"}}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &metricRepoStub{}
+			repo.On("SetGauge", mock.Anything, tt.wantLabels, 12.5).Return(nil)
+			s := NewMetricService(repo)
+			_, err := s.SetMetricByModel(context.Background(), []byte(tt.body))
+			if tt.wantErr {
+				require.Error(t, err)
+				repo.AssertNotCalled(t, "SetGauge", mock.Anything, mock.Anything, mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+func Test_metricService_SetMetricBulk_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantMsg string
+	}{
+		{
+			name:    "should reject gauge without value",
+			body:    `[{"id":"Alloc","type":"gauge","value":1},{"id":"a","type":"gauge"}]`,
+			wantMsg: "invalid metric data: a",
+		},
+		{
+			name:    "should reject counter without delta",
+			body:    `[{"id":"PollCount","type":"counter","value":1}]`,
+			wantMsg: "invalid metric data: PollCount",
+		},
+		{
+			name:    "should reject unknown type",
+			body:    `[{"id":"Alloc","type":"histogram","value":1}]`,
+			wantMsg: "invalid metric data: Alloc",
+		},
+		{
+			name:    "should reject invalid name",
+			body:    `[{"id":"","type":"gauge","value":1}]`,
+			wantMsg: "invalid metric name: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &metricRepoStub{}
+			s := NewMetricService(repo)
+			err := s.SetMetricBulk(context.Background(), []byte(tt.body))
+			var metricErr *InvalidMetricError
+			require.ErrorAs(t, err, &metricErr)
+			require.Equal(t, http.StatusBadRequest, metricErr.StatusCode)
+			require.Equal(t, tt.wantMsg, metricErr.Message)
+			repo.AssertNotCalled(t, "SetMetricBulk", mock.Anything)
+		})
+	}
+}
+
+func Test_metricService_BatchTooLarge(t *testing.T) {
+	repo := &metricRepoStub{}
+	repo.On("SetMetricBulk", mock.Anything).Return(fmt.Errorf("%w: 100 bytes", repository.ErrBatchTooLarge))
+	s := NewMetricService(repo)
+	err := s.SetMetricBulk(context.Background(), []byte(`[{"id":"Alloc","type":"gauge","value":1}]`))
+	var metricErr *InvalidMetricError
+	require.ErrorAs(t, err, &metricErr)
+	require.Equal(t, http.StatusRequestEntityTooLarge, metricErr.StatusCode)
+}
+
+type pendingRepoStub struct {
+	*metricRepoStub
+	pending int
+}
+
+func (m *pendingRepoStub) PendingUpdates() int {
+	return m.pending
+}
+
+func Test_metricService_GetAllMetricsForPrometheus_PendingUpdates(t *testing.T) {
+	repo := &pendingRepoStub{metricRepoStub: &metricRepoStub{}, pending: 3}
+	repo.On("GetAllMetrics").Return(map[string]models.Metrics{})
+	s := NewMetricService(repo)
+	expected := "# HELP storage_pending_updates Series waiting for reconciliation with storage backend.\n" +
+		"# TYPE storage_pending_updates gauge\n" +
+		"storage_pending_updates 3\n"
+	require.Equal(t, expected, s.GetAllMetricsForPrometheus(context.Background()))
+}
+
+type retriableRepoError struct{}
+
+func (e *retriableRepoError) Error() string {
+	return "connection refused"
+}
+
+func (e *retriableRepoError) IsRetriable() bool {
+	return true
+}
+
+// instantClock skips waiting between attempts
+type instantClock struct{}
+
+func (instantClock) After(time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+func Test_metricService_SetMetricByModel_Retry(t *testing.T) {
+	repo := &metricRepoStub{}
+	repo.On("SetCounter", "PollCount", models.Labels(nil), int64(1)).Return(&retriableRepoError{}).Once()
+	repo.On("SetCounter", "PollCount", models.Labels(nil), int64(1)).Return(nil).Once()
+	s := NewMetricService(repo).WithRetryPolicy(utils.RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Hour,
+		Clock:       instantClock{},
+	})
+	_, err := s.SetMetricByModel(context.Background(), []byte(`{"id":"PollCount","type":"counter","delta":1}`))
+	require.NoError(t, err)
+	repo.AssertNumberOfCalls(t, "SetCounter", 2)
+}
+
+func Test_metricService_QuotaExceeded(t *testing.T) {
+	quotaErr := &repository.QuotaError{Tenant: "team-a", Limit: 10}
+	repo := &metricRepoStub{}
+	repo.On("SetGauge", "Alloc", models.Labels(nil), 1.0).Return(quotaErr)
+	repo.On("SetMetricBulk", mock.Anything).Return(quotaErr)
+	s := NewMetricService(repo)
+
+	var metricErr *InvalidMetricError
+	err := s.SetGauge(context.Background(), "Alloc", "1")
+	require.ErrorAs(t, err, &metricErr)
+	require.Equal(t, http.StatusForbidden, metricErr.StatusCode)
+	_, err = s.SetMetricByModel(context.Background(), []byte(`{"id":"Alloc","type":"gauge","value":1}`))
+	require.ErrorAs(t, err, &metricErr)
+	require.Equal(t, http.StatusForbidden, metricErr.StatusCode)
+	require.Equal(t, `tenant "team-a" exceeded quota of 10 series`, metricErr.Message)
+	err = s.SetMetricBulk(context.Background(), []byte(`[{"id":"Alloc","type":"gauge","value":1}]`))
+	require.ErrorAs(t, err, &metricErr)
+	require.Equal(t, http.StatusForbidden, metricErr.StatusCode)
+}
+
+func Test_metricService_WriteLineProtocol(t *testing.T) {
+	repo := &metricRepoStub{}
+	var stored []models.Metrics
+	repo.On("SetMetricBulk", mock.Anything).Run(func(args mock.Arguments) {
+		stored = *args.Get(0).(*[]models.Metrics)
+	}).Return(nil)
+	s := NewMetricService(repo)
+	input := strings.Join([]string{
+		"# comment",
+		`cpu,host=web\ 1,core=0 usage_idle=97.5,usage_user=1.5 1700000000000000000`,
+		`net,iface=eth0 bytes_recv=1024i,bytes_sent=7u,up=true,name="eth 0" 1700000000000000000`,
+		"",
+		"net,iface=eth0 up=true",
+		"mem free=oops",
+	}, "\n")
+	result, err := s.WriteLineProtocol(context.Background(), []byte(input))
+	require.NoError(t, err)
+	require.Equal(t, &models.WriteResult{
+		Accepted: 2,
+		Errors: []models.LineError{
+			{Line: 5, Error: "no numeric fields"},
+			{Line: 6, Error: `invalid value of field free: strconv.ParseFloat: parsing "oops": invalid syntax`},
+		},
+	}, result)
+	cpuLabels := models.Labels{"host": "web 1", "core": "0"}
+	idle, user, recv, sent := 97.5, 1.5, int64(1024), int64(7)
+	require.Equal(t, []models.Metrics{
+		{ID: "cpu_usage_idle", MType: models.Gauge, Value: &idle, Labels: cpuLabels},
+		{ID: "cpu_usage_user", MType: models.Gauge, Value: &user, Labels: cpuLabels},
+		{ID: "net_bytes_recv", MType: models.Counter, Delta: &recv, Labels: models.Labels{"iface": "eth0"}},
+		{ID: "net_bytes_sent", MType: models.Counter, Delta: &sent, Labels: models.Labels{"iface": "eth0"}},
+	}, stored)
+}
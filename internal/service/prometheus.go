@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/repository"
+)
+
+var promInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// GetAllMetricsForPrometheus renders stored metrics in Prometheus text
+// exposition format; series of another type than the first one rendered
+// under the same family name, e.g. gauge x_total next to counter x,
+// are left out as family must have single type
+func (s *metricService) GetAllMetricsForPrometheus(ctx context.Context) string {
+	stored := s.repo.GetAllMetrics(ctx)
+	series := make([]promSeries, 0, len(stored))
+	for _, m := range stored {
+		if (m.MType == models.Gauge && m.Value == nil) || (m.MType == models.Counter && m.Delta == nil) {
+			continue
+		}
+		name := promMetricName(s.promPrefix + m.ID)
+		// text format 0.0.4 requires samples named as their family
+		if m.MType == models.Counter && !strings.HasSuffix(name, "_total") {
+			name += "_total"
+		}
+		series = append(series, promSeries{name: name, metric: m})
+	}
+	// keep series of the same family together
+	sort.Slice(series, func(i, j int) bool {
+		a, b := series[i], series[j]
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		if a.metric.MType != b.metric.MType {
+			return a.metric.MType < b.metric.MType
+		}
+		return a.metric.Labels.String() < b.metric.Labels.String()
+	})
+	pendingName := promMetricName(s.promPrefix + "storage_pending_updates")
+	r, hasPending := s.repo.(repository.PendingReporter)
+	var sb strings.Builder
+	var family, familyType string
+	for _, ps := range series {
+		m := ps.metric
+		if ps.name != family {
+			if hasPending && ps.name == pendingName {
+				continue
+			}
+			family, familyType = ps.name, m.MType
+			switch m.MType {
+			case models.Gauge:
+				fmt.Fprintf(&sb, "# HELP %s Gauge metric %s.\n", ps.name, m.ID)
+				fmt.Fprintf(&sb, "# TYPE %s gauge\n", ps.name)
+			case models.Counter:
+				fmt.Fprintf(&sb, "# HELP %s Counter metric %s.\n", ps.name, m.ID)
+				fmt.Fprintf(&sb, "# TYPE %s counter\n", ps.name)
+			}
+		} else if m.MType != familyType {
+			continue
+		}
+		switch m.MType {
+		case models.Gauge:
+			fmt.Fprintf(&sb, "%s%s %s\n", ps.name, m.Labels, promValue(*m.Value))
+		case models.Counter:
+			fmt.Fprintf(&sb, "%s%s %d\n", ps.name, m.Labels, *m.Delta)
+		}
+	}
+	if hasPending {
+		fmt.Fprintf(&sb, "# HELP %s Series waiting for reconciliation with storage backend.\n", pendingName)
+		fmt.Fprintf(&sb, "# TYPE %s gauge\n", pendingName)
+		fmt.Fprintf(&sb, "%s %d\n", pendingName, r.PendingUpdates())
+	}
+	return sb.String()
+}
+
+// promSeries is stored series with name of family it is rendered in
+type promSeries struct {
+	name   string
+	metric models.Metrics
+}
+
+func promMetricName(name string) string {
+	name = promInvalidChars.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+func promValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
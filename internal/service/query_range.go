@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+)
+
+const (
+	defaultRangeWindow = time.Hour
+	maxRangePoints     = 11000
+)
+
+func (s *metricService) QueryRange(ctx context.Context, q *models.RangeQuery) (*models.RangeResult, error) {
+	if !isMetricNameAlphanumeric(q.ID, s.re) {
+		return nil, &InvalidMetricError{
+			Message:    fmt.Sprintf("invalid metric name: %s", q.ID),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	if q.MType != models.Gauge && q.MType != models.Counter {
+		return nil, &InvalidMetricError{
+			Message:    fmt.Sprintf("invalid metric type: %s", q.MType),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	end, err := parseTimeParam(q.End, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	start, err := parseTimeParam(q.Start, end.Add(-defaultRangeWindow))
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, &InvalidMetricError{
+			Message:    "end must not be before start",
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	var step time.Duration
+	if q.Step != "" {
+		step, err = time.ParseDuration(q.Step)
+		if err != nil || step <= 0 {
+			return nil, &InvalidMetricError{
+				Message:    fmt.Sprintf("invalid step: %s", q.Step),
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+		if end.Sub(start)/step > maxRangePoints {
+			return nil, &InvalidMetricError{
+				Message:    fmt.Sprintf("exceeded maximum resolution of %d points", maxRangePoints),
+				StatusCode: http.StatusBadRequest,
+			}
+		}
+	}
+	agg := q.Agg
+	if agg == "" {
+		agg = models.AggAvg
+		if q.MType == models.Counter {
+			agg = models.AggLast
+		}
+	}
+	switch agg {
+	case models.AggAvg, models.AggMin, models.AggMax, models.AggLast:
+	default:
+		return nil, &InvalidMetricError{
+			Message:    fmt.Sprintf("invalid aggregation: %s", agg),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	if !areLabelsValid(q.Labels, s.labelRe) {
+		return nil, &InvalidMetricError{
+			Message:    fmt.Sprintf("invalid metric labels: %s", q.Labels),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	samples, err := s.repo.GetHistory(ctx, q.ID, q.MType, q.Labels, start, end)
+	if err != nil {
+		return nil, &InvalidMetricError{
+			Message:    fmt.Sprintf("failed to read history: %s", err.Error()),
+			StatusCode: http.StatusInternalServerError,
+		}
+	}
+	result := &models.RangeResult{
+		ID:     q.ID,
+		MType:  q.MType,
+		Labels: q.Labels,
+		Points: samples,
+	}
+	if step > 0 {
+		result.Step = step.String()
+		result.Agg = agg
+		result.Points = downsample(samples, start, step, agg)
+	}
+	return result, nil
+}
+
+// downsample aggregates chronologically ordered samples into buckets of step
+// aligned to start, every point is stamped with its bucket start
+func downsample(samples []models.Sample, start time.Time, step time.Duration, agg string) []models.Sample {
+	points := make([]models.Sample, 0)
+	var count int
+	for _, s := range samples {
+		bucket := start.Add(s.Timestamp.Sub(start) / step * step)
+		if len(points) == 0 || !points[len(points)-1].Timestamp.Equal(bucket) {
+			points = append(points, models.Sample{Timestamp: bucket, Value: s.Value})
+			count = 1
+			continue
+		}
+		p := &points[len(points)-1]
+		count++
+		switch agg {
+		case models.AggAvg:
+			p.Value += (s.Value - p.Value) / float64(count)
+		case models.AggMin:
+			p.Value = math.Min(p.Value, s.Value)
+		case models.AggMax:
+			p.Value = math.Max(p.Value, s.Value)
+		case models.AggLast:
+			p.Value = s.Value
+		}
+	}
+	return points
+}
+
+// parseTimeParam accepts RFC3339 or unix timestamp in seconds
+func parseTimeParam(raw string, fallback time.Time) (time.Time, error) {
+	if raw == "" {
+		return fallback, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	seconds, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return time.Time{}, &InvalidMetricError{
+			Message:    fmt.Sprintf("invalid time: %s", raw),
+			StatusCode: http.StatusBadRequest,
+		}
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*float64(time.Second))), nil
+}
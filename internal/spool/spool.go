@@ -0,0 +1,423 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	segmentExt   = ".seg"
+	cursorFile   = "cursor"
+	headerSize   = 16
+	maxRecordLen = 64 << 20
+)
+
+var errCorrupted = errors.New("corrupted record")
+
+// ErrRejected marks batch which is not going to be accepted on retry,
+// such batch is dropped instead of blocking the rest of queue
+var ErrRejected = errors.New("batch rejected")
+
+type Config struct {
+	Dir string
+	// spool drops oldest segments when exceeding MaxBytes, zero means no limit
+	MaxBytes int64
+	// batches older than MaxAge are discarded, zero means no limit
+	MaxAge      time.Duration
+	SegmentSize int64
+	Logger      *zap.Logger
+}
+
+type Stats struct {
+	Batches int
+	Bytes   int64
+	Dropped int
+}
+
+type segment struct {
+	seq     uint64
+	size    int64
+	records int
+}
+
+// cursor points to the first not delivered record
+type cursor struct {
+	seq    uint64
+	offset int64
+}
+
+// Spool is a bounded on-disk FIFO queue of batches, each record is stored as
+// [length uint32][crc32 uint32][unix nano timestamp int64][payload]
+type Spool struct {
+	config Config
+	mu     sync.Mutex
+	// serializes replays, so every batch is sent once
+	replayMu sync.Mutex
+	segments []*segment
+	active   *os.File
+	cursor   cursor
+	dropped  int
+	now      func() time.Time
+}
+
+func Open(cfg Config) (*Spool, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = 1 << 20
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = zap.NewNop()
+	}
+	s := &Spool{config: cfg, now: time.Now}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.config.Dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+// load scans existing segments and restores delivery cursor
+func (s *Spool) load() error {
+	entries, err := os.ReadDir(s.config.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, &segment{seq: seq})
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].seq < s.segments[j].seq
+	})
+	if data, err := os.ReadFile(filepath.Join(s.config.Dir, cursorFile)); err == nil {
+		fmt.Sscanf(string(data), "%d %d", &s.cursor.seq, &s.cursor.offset)
+	}
+	for _, seg := range s.segments {
+		offset := int64(0)
+		if seg.seq == s.cursor.seq {
+			offset = s.cursor.offset
+		}
+		records, size, err := s.scan(seg.seq, offset)
+		if err != nil {
+			return err
+		}
+		seg.records = records
+		seg.size = size
+	}
+	return nil
+}
+
+// scan counts valid records starting from offset, torn tail is truncated
+func (s *Spool) scan(seq uint64, offset int64) (int, int64, error) {
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_RDWR, 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	r := bufio.NewReader(f)
+	records := 0
+	end := offset
+	for {
+		_, _, n, err := readRecord(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.config.Logger.Warn("Truncating corrupted spool segment",
+				zap.Uint64("segment", seq),
+				zap.Int64("offset", end),
+				zap.Error(err),
+			)
+			if err := f.Truncate(end); err != nil {
+				return 0, 0, err
+			}
+			break
+		}
+		records++
+		end += n
+	}
+	return records, end, nil
+}
+
+func readRecord(r io.Reader) ([]byte, time.Time, int64, error) {
+	header := make([]byte, headerSize)
+	n, err := io.ReadFull(r, header)
+	if err == io.EOF {
+		return nil, time.Time{}, 0, io.EOF
+	}
+	if err != nil {
+		return nil, time.Time{}, 0, fmt.Errorf("%w: torn header of %d bytes", errCorrupted, n)
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if length > maxRecordLen {
+		return nil, time.Time{}, 0, fmt.Errorf("%w: invalid length %d", errCorrupted, length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, time.Time{}, 0, fmt.Errorf("%w: torn payload", errCorrupted)
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(header[8:16])
+	crc.Write(payload)
+	if crc.Sum32() != checksum {
+		return nil, time.Time{}, 0, fmt.Errorf("%w: checksum mismatch", errCorrupted)
+	}
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(header[8:16])))
+	return payload, ts, int64(headerSize) + int64(length), nil
+}
+
+func encodeRecord(payload []byte, ts time.Time) []byte {
+	record := make([]byte, headerSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint64(record[8:16], uint64(ts.UnixNano()))
+	copy(record[headerSize:], payload)
+	crc := crc32.NewIEEE()
+	crc.Write(record[8:])
+	binary.BigEndian.PutUint32(record[4:8], crc.Sum32())
+	return record
+}
+
+// Append durably stores batch at the end of queue
+func (s *Spool) Append(batch []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.segments) == 0 || s.active == nil || s.last().size >= s.config.SegmentSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	record := encodeRecord(batch, s.now())
+	if _, err := s.active.Write(record); err != nil {
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+	seg := s.last()
+	seg.size += int64(len(record))
+	seg.records++
+	s.enforceLimits()
+	return nil
+}
+
+func (s *Spool) last() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+// rotate starts new active segment
+func (s *Spool) rotate() error {
+	if s.active != nil {
+		if err := s.active.Close(); err != nil {
+			return err
+		}
+		s.active = nil
+	}
+	var seq uint64 = 1
+	if len(s.segments) > 0 {
+		seq = s.last().seq + 1
+	}
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.active = f
+	s.segments = append(s.segments, &segment{seq: seq})
+	return nil
+}
+
+// enforceLimits drops oldest segments while spool exceeds size limit
+func (s *Spool) enforceLimits() {
+	if s.config.MaxBytes <= 0 {
+		return
+	}
+	for len(s.segments) > 1 && s.bytes() > s.config.MaxBytes {
+		seg := s.segments[0]
+		s.config.Logger.Warn("Spool size limit exceeded, dropping oldest batches",
+			zap.Uint64("segment", seg.seq),
+			zap.Int("batches", seg.records),
+		)
+		s.dropped += seg.records
+		s.removeOldest()
+	}
+}
+
+func (s *Spool) removeOldest() {
+	seg := s.segments[0]
+	if err := os.Remove(s.segmentPath(seg.seq)); err != nil {
+		s.config.Logger.Error("Failed to remove spool segment", zap.Error(err))
+	}
+	s.segments = s.segments[1:]
+	s.saveCursor(cursor{})
+}
+
+func (s *Spool) bytes() int64 {
+	var total int64
+	for i, seg := range s.segments {
+		total += seg.size
+		if i == 0 && seg.seq == s.cursor.seq {
+			total -= s.cursor.offset
+		}
+	}
+	return total
+}
+
+func (s *Spool) saveCursor(c cursor) {
+	s.cursor = c
+	path := filepath.Join(s.config.Dir, cursorFile)
+	tmp := path + ".tmp"
+	data := fmt.Sprintf("%d %d", c.seq, c.offset)
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		s.config.Logger.Error("Failed to save spool cursor", zap.Error(err))
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		s.config.Logger.Error("Failed to save spool cursor", zap.Error(err))
+	}
+}
+
+// Replay sends spooled batches in order, stops on the first failed batch
+// leaving it in queue, batches failed with ErrRejected are dropped instead,
+// returns number of delivered batches. Spool is not locked while sending,
+// so batches can be appended meanwhile
+func (s *Spool) Replay(send func(batch []byte) error) (int, error) {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+	delivered := 0
+	for {
+		payload, ts, next, err := s.next()
+		if err == io.EOF {
+			return delivered, nil
+		}
+		if err != nil {
+			return delivered, err
+		}
+		if s.config.MaxAge > 0 && s.now().Sub(ts) > s.config.MaxAge {
+			s.advance(next, true)
+			continue
+		}
+		if err := send(payload); err != nil {
+			if !errors.Is(err, ErrRejected) {
+				return delivered, err
+			}
+			s.config.Logger.Warn("Dropping spooled batch rejected by server", zap.Error(err))
+			s.advance(next, true)
+			continue
+		}
+		delivered++
+		s.advance(next, false)
+	}
+}
+
+// next reads the first not delivered record, fully replayed segments are
+// removed, returns position following the record or io.EOF if spool is empty
+func (s *Spool) next() ([]byte, time.Time, cursor, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.segments) > 0 {
+		seg := s.segments[0]
+		offset := int64(0)
+		if s.cursor.seq == seg.seq {
+			offset = s.cursor.offset
+		}
+		payload, ts, n, err := s.readAt(seg.seq, offset)
+		if err == nil {
+			return payload, ts, cursor{seq: seg.seq, offset: offset + n}, nil
+		}
+		if errors.Is(err, errCorrupted) {
+			s.config.Logger.Warn("Skipping corrupted tail of spool segment",
+				zap.Uint64("segment", seg.seq),
+				zap.Error(err),
+			)
+			s.dropped += seg.records
+			seg.records = 0
+		} else if err != io.EOF {
+			return nil, time.Time{}, cursor{}, err
+		}
+		if seg == s.last() && s.active != nil {
+			s.active.Close()
+			s.active = nil
+		}
+		s.removeOldest()
+	}
+	return nil, time.Time{}, cursor{}, io.EOF
+}
+
+func (s *Spool) readAt(seq uint64, offset int64) ([]byte, time.Time, int64, error) {
+	f, err := os.Open(s.segmentPath(seq))
+	if err != nil {
+		return nil, time.Time{}, 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, time.Time{}, 0, err
+	}
+	return readRecord(bufio.NewReader(f))
+}
+
+// advance moves cursor past replayed record unless its segment has been
+// dropped by size limit while the record was being sent
+func (s *Spool) advance(next cursor, dropped bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.segments) == 0 || s.segments[0].seq != next.seq {
+		return
+	}
+	if dropped {
+		s.dropped++
+	}
+	s.segments[0].records--
+	s.saveCursor(next)
+}
+
+func (s *Spool) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batches := 0
+	for _, seg := range s.segments {
+		batches += seg.records
+	}
+	return Stats{
+		Batches: batches,
+		Bytes:   s.bytes(),
+		Dropped: s.dropped,
+	}
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active != nil {
+		err := s.active.Close()
+		s.active = nil
+		return err
+	}
+	return nil
+}
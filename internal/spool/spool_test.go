@@ -0,0 +1,160 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func appendBatches(t *testing.T, s *Spool, batches ...string) {
+	t.Helper()
+	for _, b := range batches {
+		require.NoError(t, s.Append([]byte(b)))
+	}
+}
+
+func replayAll(t *testing.T, s *Spool) []string {
+	t.Helper()
+	var got []string
+	_, err := s.Replay(func(batch []byte) error {
+		got = append(got, string(batch))
+		return nil
+	})
+	require.NoError(t, err)
+	return got
+}
+
+func TestSpool_ReplayInOrder(t *testing.T) {
+	s, err := Open(Config{Dir: t.TempDir(), SegmentSize: 40})
+	require.NoError(t, err)
+	defer s.Close()
+	appendBatches(t, s, "first", "second", "third", "fourth")
+	require.Greater(t, len(s.segments), 1, "segments should be rotated")
+	require.Equal(t, 4, s.Stats().Batches)
+
+	require.Equal(t, []string{"first", "second", "third", "fourth"}, replayAll(t, s))
+	require.Equal(t, Stats{}, s.Stats())
+
+	appendBatches(t, s, "fifth")
+	require.Equal(t, []string{"fifth"}, replayAll(t, s))
+}
+
+func TestSpool_ReplayStopsOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Config{Dir: dir})
+	require.NoError(t, err)
+	appendBatches(t, s, "first", "second", "third")
+
+	errUnavailable := errors.New("server unavailable")
+	delivered, err := s.Replay(func(batch []byte) error {
+		if string(batch) == "second" {
+			return errUnavailable
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, errUnavailable)
+	require.Equal(t, 1, delivered)
+	require.Equal(t, 2, s.Stats().Batches)
+	require.NoError(t, s.Close())
+
+	// delivery position survives restart
+	s, err = Open(Config{Dir: dir})
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, 2, s.Stats().Batches)
+	require.Equal(t, []string{"second", "third"}, replayAll(t, s))
+}
+
+func TestSpool_ReplayDropsRejected(t *testing.T) {
+	s, err := Open(Config{Dir: t.TempDir()})
+	require.NoError(t, err)
+	defer s.Close()
+	appendBatches(t, s, "first", "invalid", "third")
+
+	var got []string
+	delivered, err := s.Replay(func(batch []byte) error {
+		if string(batch) == "invalid" {
+			return fmt.Errorf("%w: bad request", ErrRejected)
+		}
+		got = append(got, string(batch))
+		// spool is not locked while batch is being sent
+		if string(batch) == "first" {
+			return s.Append([]byte("appended"))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, delivered)
+	require.Equal(t, []string{"first", "third", "appended"}, got)
+	require.Equal(t, Stats{Dropped: 1}, s.Stats())
+}
+
+func TestSpool_TruncatesTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Config{Dir: dir})
+	require.NoError(t, err)
+	appendBatches(t, s, "first", "second")
+	require.NoError(t, s.Close())
+
+	path := s.segmentPath(1)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+
+	s, err = Open(Config{Dir: dir})
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, 1, s.Stats().Batches)
+	require.Equal(t, []string{"first"}, replayAll(t, s))
+}
+
+func TestSpool_SkipsCorruptedRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Config{Dir: dir})
+	require.NoError(t, err)
+	appendBatches(t, s, "first", "second")
+	require.NoError(t, s.Close())
+
+	path := filepath.Join(dir, fmt.Sprintf("%020d%s", 1, segmentExt))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0644))
+
+	s, err = Open(Config{Dir: dir})
+	require.NoError(t, err)
+	defer s.Close()
+	require.Equal(t, []string{"first"}, replayAll(t, s))
+}
+
+func TestSpool_Limits(t *testing.T) {
+	t.Run("should drop oldest segments over size limit", func(t *testing.T) {
+		s, err := Open(Config{Dir: t.TempDir(), SegmentSize: 1, MaxBytes: 64})
+		require.NoError(t, err)
+		defer s.Close()
+		appendBatches(t, s, "batch-1", "batch-2", "batch-3", "batch-4", "batch-5")
+		stats := s.Stats()
+		require.LessOrEqual(t, stats.Bytes, int64(64))
+		require.Equal(t, 5, stats.Batches+stats.Dropped)
+		got := replayAll(t, s)
+		require.Equal(t, "batch-5", got[len(got)-1])
+		require.NotContains(t, got, "batch-1")
+	})
+	t.Run("should discard expired batches", func(t *testing.T) {
+		s, err := Open(Config{Dir: t.TempDir(), MaxAge: time.Hour})
+		require.NoError(t, err)
+		defer s.Close()
+		now := time.Now()
+		s.now = func() time.Time { return now.Add(-2 * time.Hour) }
+		appendBatches(t, s, "expired")
+		s.now = func() time.Time { return now }
+		appendBatches(t, s, "fresh")
+		require.Equal(t, []string{"fresh"}, replayAll(t, s))
+		require.Equal(t, 1, s.Stats().Dropped)
+	})
+}
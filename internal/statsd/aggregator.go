@@ -0,0 +1,211 @@
+package statsd
+
+import (
+	"errors"
+	"math"
+	"strings"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+)
+
+type series struct {
+	name   string
+	labels models.Labels
+}
+
+type counterSum struct {
+	series
+	// sum of increments corrected by sample rate
+	value float64
+}
+
+type timerStats struct {
+	series
+	// number of measurements corrected by sample rate
+	count    float64
+	n        int
+	sum      float64
+	min, max float64
+}
+
+type gaugeState struct {
+	series
+	value float64
+	dirty bool
+	// flushes since the last update
+	idle int
+}
+
+// gauges not updated for that many flushes are forgotten, so series
+// of stopped clients do not pile up
+const gaugeIdleFlushes = 30
+
+// aggregator accumulates samples between flushes, it is not safe
+// for concurrent use
+type aggregator struct {
+	counters  map[string]*counterSum
+	timers    map[string]*timerStats
+	sets      map[string]map[string]struct{}
+	setSeries map[string]series
+	// gauges keep their values across flushes so relative changes
+	// apply to the last value
+	gauges    map[string]*gaugeState
+	malformed int64
+}
+
+func newAggregator() *aggregator {
+	a := &aggregator{gauges: make(map[string]*gaugeState)}
+	a.reset()
+	return a
+}
+
+func (a *aggregator) reset() {
+	a.counters = make(map[string]*counterSum)
+	a.timers = make(map[string]*timerStats)
+	a.sets = make(map[string]map[string]struct{})
+	a.setSeries = make(map[string]series)
+	a.malformed = 0
+}
+
+// addPacket adds every line of packet, malformed lines are counted
+func (a *aggregator) addPacket(packet string) {
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		s, err := parseLine(line)
+		if err != nil {
+			a.malformed++
+			continue
+		}
+		if err := a.add(s); err != nil {
+			a.malformed++
+		}
+	}
+}
+
+func (a *aggregator) add(s sample) error {
+	key := models.SeriesKey(s.name, s.labels)
+	ser := series{name: s.name, labels: s.labels}
+	switch s.kind {
+	case typeCounter:
+		c, ok := a.counters[key]
+		if !ok {
+			c = &counterSum{series: ser}
+			a.counters[key] = c
+		}
+		sum := c.value + s.value/s.rate
+		if !inCounterRange(sum) {
+			return errors.New("counter sum out of range")
+		}
+		c.value = sum
+	case typeGauge:
+		g, ok := a.gauges[key]
+		if !ok {
+			g = &gaugeState{series: ser}
+			a.gauges[key] = g
+		}
+		if s.relative {
+			g.value += s.value
+		} else {
+			g.value = s.value
+		}
+		g.dirty = true
+		g.idle = 0
+	case typeTimer:
+		t, ok := a.timers[key]
+		if !ok {
+			t = &timerStats{series: ser, min: s.value, max: s.value}
+			a.timers[key] = t
+		}
+		t.count += 1 / s.rate
+		t.n++
+		t.sum += s.value
+		t.min = math.Min(t.min, s.value)
+		t.max = math.Max(t.max, s.value)
+	case typeSet:
+		members, ok := a.sets[key]
+		if !ok {
+			members = make(map[string]struct{})
+			a.sets[key] = members
+			a.setSeries[key] = ser
+		}
+		members[s.member] = struct{}{}
+	}
+	return nil
+}
+
+// flush returns metrics aggregated since the previous flush: counter
+// sums, changed gauges, timer count, mean, min and max, and number of
+// unique set members; idle gauges are forgotten
+func (a *aggregator) flush() []models.Metrics {
+	var metrics []models.Metrics
+	for _, c := range a.counters {
+		metrics = append(metrics, counter(c.name, c.labels, int64(math.Round(c.value))))
+	}
+	for key, g := range a.gauges {
+		if g.dirty {
+			metrics = append(metrics, gauge(g.name, g.labels, g.value))
+			g.dirty = false
+			continue
+		}
+		g.idle++
+		if g.idle >= gaugeIdleFlushes {
+			delete(a.gauges, key)
+		}
+	}
+	for _, t := range a.timers {
+		metrics = append(metrics,
+			counter(t.name+"_count", t.labels, int64(math.Round(t.count))),
+			gauge(t.name+"_mean", t.labels, t.sum/float64(t.n)),
+			gauge(t.name+"_min", t.labels, t.min),
+			gauge(t.name+"_max", t.labels, t.max),
+		)
+	}
+	for key, members := range a.sets {
+		ser := a.setSeries[key]
+		metrics = append(metrics, gauge(ser.name, ser.labels, float64(len(members))))
+	}
+	if a.malformed > 0 {
+		metrics = append(metrics, counter("statsd_malformed_lines", nil, a.malformed))
+	}
+	a.reset()
+	return metrics
+}
+
+// requeue takes back counters and gauges of flushed batch which failed
+// to be stored, so they are sent with the next flush; timer statistics
+// and set sizes are not kept, their number is returned
+func (a *aggregator) requeue(metrics []models.Metrics) int {
+	lost := 0
+	for _, m := range metrics {
+		key := m.SeriesKey()
+		switch {
+		case m.MType == models.Counter && m.Delta != nil:
+			c, ok := a.counters[key]
+			if !ok {
+				c = &counterSum{series: series{name: m.ID, labels: m.Labels}}
+				a.counters[key] = c
+			}
+			if sum := c.value + float64(*m.Delta); inCounterRange(sum) {
+				c.value = sum
+			} else {
+				lost++
+			}
+		case m.MType == models.Gauge && a.gauges[key] != nil:
+			a.gauges[key].dirty = true
+		default:
+			lost++
+		}
+	}
+	return lost
+}
+
+func counter(name string, labels models.Labels, delta int64) models.Metrics {
+	return models.Metrics{ID: name, MType: models.Counter, Delta: &delta, Labels: labels}
+}
+
+func gauge(name string, labels models.Labels, value float64) models.Metrics {
+	return models.Metrics{ID: name, MType: models.Gauge, Value: &value, Labels: labels}
+}
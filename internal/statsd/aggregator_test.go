@@ -0,0 +1,34 @@
+package statsd
+
+import (
+	"testing"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_aggregator_forgetsIdleGauges(t *testing.T) {
+	a := newAggregator()
+	a.addPacket("queue.size:3|g\nworkers:2|g")
+	require.Len(t, a.flush(), 2)
+	for i := 0; i < gaugeIdleFlushes-1; i++ {
+		a.addPacket("workers:+1|g")
+		a.flush()
+	}
+	require.Contains(t, a.gauges, models.SeriesKey("workers", nil))
+	require.Contains(t, a.gauges, models.SeriesKey("queue_size", nil))
+
+	a.flush()
+	require.Contains(t, a.gauges, models.SeriesKey("workers", nil), "updated gauge should be kept")
+	require.NotContains(t, a.gauges, models.SeriesKey("queue_size", nil), "idle gauge should be forgotten")
+}
+
+func Test_aggregator_rejectsCounterOverflow(t *testing.T) {
+	a := newAggregator()
+	a.addPacket("api.requests:5e18|c\napi.requests:5e18|c\napi.requests:1024|c")
+	delta, malformed := int64(5e18+1024), int64(1)
+	require.ElementsMatch(t, []models.Metrics{
+		{ID: "api_requests", MType: models.Counter, Delta: &delta},
+		{ID: "statsd_malformed_lines", MType: models.Counter, Delta: &malformed},
+	}, a.flush())
+}
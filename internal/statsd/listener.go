@@ -0,0 +1,152 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/service"
+	"go.uber.org/zap"
+)
+
+// the largest UDP payload, so datagrams are never truncated
+const maxPacketSize = 65535
+
+const (
+	defaultFlushInterval = 10 * time.Second
+	defaultQueueSize     = 1024
+)
+
+type metricService interface {
+	SetMetrics(ctx context.Context, metrics []models.Metrics) error
+}
+
+type Config struct {
+	Address string
+	// interval of aggregation, zero means default one
+	FlushInterval time.Duration
+	// packets received and not parsed yet, packets arriving to full queue
+	// are dropped, zero means default size
+	QueueSize int
+	Logger    *zap.Logger
+}
+
+// Listener receives StatsD packets over UDP and stores metrics aggregated
+// per flush interval, dropped packets and malformed lines are counted by
+// statsd_dropped_packets and statsd_malformed_lines counters
+type Listener struct {
+	conn     net.PacketConn
+	service  metricService
+	interval time.Duration
+	logger   *zap.Logger
+	packets  chan string
+	dropped  atomic.Int64
+	agg      *aggregator
+}
+
+func NewListener(cfg Config, s metricService) (*Listener, error) {
+	conn, err := net.ListenPacket("udp", cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	return &Listener{
+		conn:     conn,
+		service:  s,
+		interval: cfg.FlushInterval,
+		logger:   cfg.Logger,
+		packets:  make(chan string, cfg.QueueSize),
+		agg:      newAggregator(),
+	}, nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Run aggregates received packets until stopCh is closed, metrics
+// aggregated so far are flushed before exit
+func (l *Listener) Run(stopCh chan struct{}, doneCh chan struct{}) {
+	defer close(doneCh)
+	readDoneCh := make(chan struct{})
+	go l.read(readDoneCh)
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case packet := <-l.packets:
+			l.agg.addPacket(packet)
+		case <-ticker.C:
+			l.flush()
+		case <-stopCh:
+			l.conn.Close()
+			<-readDoneCh
+			for len(l.packets) > 0 {
+				l.agg.addPacket(<-l.packets)
+			}
+			l.flush()
+			return
+		}
+	}
+}
+
+// read queues received packets until connection is closed
+func (l *Listener) read(doneCh chan struct{}) {
+	defer close(doneCh)
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			l.logger.Warn("Failed to read StatsD packet", zap.Error(err))
+			continue
+		}
+		select {
+		case l.packets <- string(buf[:n]):
+		default:
+			l.dropped.Add(1)
+		}
+	}
+}
+
+func (l *Listener) flush() {
+	metrics := l.agg.flush()
+	if dropped := l.dropped.Swap(0); dropped > 0 {
+		metrics = append(metrics, counter("statsd_dropped_packets", nil, dropped))
+	}
+	if len(metrics) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), l.interval)
+	defer cancel()
+	err := l.service.SetMetrics(ctx, metrics)
+	if err == nil {
+		return
+	}
+	// rejected batch is going to be rejected again
+	var metricErr *service.InvalidMetricError
+	if errors.As(err, &metricErr) && metricErr.StatusCode < http.StatusInternalServerError {
+		l.logger.Error("StatsD metrics rejected by storage, dropping them",
+			zap.Int("series", len(metrics)),
+			zap.Error(err),
+		)
+		return
+	}
+	lost := l.agg.requeue(metrics)
+	l.logger.Error("Failed to store StatsD metrics, retrying with next flush",
+		zap.Int("series", len(metrics)),
+		zap.Int("dropped", lost),
+		zap.Error(err),
+	)
+}
@@ -0,0 +1,189 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/funkymotions/go-ya-practicum-metrics/internal/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// serviceStub sums counters and keeps last gauges of stored batches
+type serviceStub struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	gauges   map[string]float64
+}
+
+func newServiceStub() *serviceStub {
+	return &serviceStub{
+		counters: make(map[string]int64),
+		gauges:   make(map[string]float64),
+	}
+}
+
+func (s *serviceStub) SetMetrics(_ context.Context, metrics []models.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range metrics {
+		switch m.MType {
+		case models.Counter:
+			s.counters[m.SeriesKey()] += *m.Delta
+		case models.Gauge:
+			s.gauges[m.SeriesKey()] = *m.Value
+		}
+	}
+	return nil
+}
+
+func (s *serviceStub) counter(key string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counters[key]
+}
+
+func (s *serviceStub) snapshot() (map[string]int64, map[string]float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counters := make(map[string]int64, len(s.counters))
+	for k, v := range s.counters {
+		counters[k] = v
+	}
+	gauges := make(map[string]float64, len(s.gauges))
+	for k, v := range s.gauges {
+		gauges[k] = v
+	}
+	return counters, gauges
+}
+
+func send(t *testing.T, addr net.Addr, packets ...string) {
+	conn, err := net.Dial("udp", addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+	for _, packet := range packets {
+		_, err := conn.Write([]byte(packet))
+		require.NoError(t, err)
+	}
+}
+
+func TestListener(t *testing.T) {
+	stub := newServiceStub()
+	l, err := NewListener(Config{
+		Address:       "127.0.0.1:0",
+		FlushInterval: 10 * time.Millisecond,
+		Logger:        zap.NewNop(),
+	}, stub)
+	require.NoError(t, err)
+	stopCh, doneCh := make(chan struct{}), make(chan struct{})
+	go l.Run(stopCh, doneCh)
+	send(t, l.Addr(),
+		"api.requests:1|c\napi.requests:2|c|@0.5\napi.requests:1|c|#code:500",
+		"queue:10|g\nqueue:-3|g",
+		"db.query:10|ms\ndb.query:30|ms|@0.5",
+		"users:alice|s\nusers:bob|s\nusers:alice|s",
+		"garbage\nup:x|g",
+	)
+	// packets are parsed in order, so the last one is stored the last
+	require.Eventually(t, func() bool {
+		return stub.counter("statsd_malformed_lines") == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	close(stopCh)
+	<-doneCh
+	counters, gauges := stub.snapshot()
+	require.Equal(t, map[string]int64{
+		"api_requests":             5,
+		`api_requests{code="500"}`: 1,
+		"db_query_count":           3,
+		"statsd_malformed_lines":   2,
+	}, counters)
+	require.Equal(t, map[string]float64{
+		"queue":         7,
+		"db_query_mean": 20,
+		"db_query_min":  10,
+		"db_query_max":  30,
+		"users":         2,
+	}, gauges)
+}
+
+func TestListener_DropsPacketsOverQueue(t *testing.T) {
+	stub := newServiceStub()
+	l, err := NewListener(Config{
+		Address:   "127.0.0.1:0",
+		QueueSize: 1,
+		Logger:    zap.NewNop(),
+	}, stub)
+	require.NoError(t, err)
+	readDoneCh := make(chan struct{})
+	// packets are read but not parsed, so queue stays full
+	go l.read(readDoneCh)
+	send(t, l.Addr(), "a:1|c", "b:1|c", "c:1|c")
+	require.Eventually(t, func() bool {
+		return l.dropped.Load() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	l.conn.Close()
+	<-readDoneCh
+	l.flush()
+	require.Equal(t, int64(2), stub.counter("statsd_dropped_packets"))
+}
+
+// failingService fails the first calls and then stores into serviceStub
+type failingService struct {
+	*serviceStub
+	failures int
+	err      error
+}
+
+func (s *failingService) SetMetrics(ctx context.Context, metrics []models.Metrics) error {
+	if s.failures > 0 {
+		s.failures--
+		return s.err
+	}
+	return s.serviceStub.SetMetrics(ctx, metrics)
+}
+
+func TestListener_RequeuesFailedFlush(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantCounters map[string]int64
+		wantGauges   map[string]float64
+	}{
+		{
+			name:         "should send counters and gauges with next flush",
+			err:          errors.New("connection refused"),
+			wantCounters: map[string]int64{"api_requests": 5, "db_query_count": 1},
+			wantGauges:   map[string]float64{"queue": 3},
+		},
+		{
+			name: "should drop batch rejected by storage",
+			err: &service.InvalidMetricError{
+				Message:    "tenant exceeded quota",
+				StatusCode: http.StatusForbidden,
+			},
+			wantCounters: map[string]int64{"api_requests": 2},
+			wantGauges:   map[string]float64{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub := &failingService{serviceStub: newServiceStub(), failures: 1, err: tt.err}
+			l, err := NewListener(Config{Address: "127.0.0.1:0", Logger: zap.NewNop()}, stub)
+			require.NoError(t, err)
+			defer l.conn.Close()
+			l.agg.addPacket("api.requests:3|c\nqueue:3|g\ndb.query:10|ms")
+			l.flush()
+			l.agg.addPacket("api.requests:2|c")
+			l.flush()
+			counters, gauges := stub.snapshot()
+			require.Equal(t, tt.wantCounters, counters)
+			require.Equal(t, tt.wantGauges, gauges)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+)
+
+// StatsD metric types, histograms are aggregated as timers
+const (
+	typeCounter   = "c"
+	typeGauge     = "g"
+	typeTimer     = "ms"
+	typeHistogram = "h"
+	typeSet       = "s"
+)
+
+// the same limit of labels as service enforces, so one line can not
+// make whole flushed batch rejected
+const maxTags = 16
+
+// sample is single parsed line, e.g. api.requests:1|c|@0.5|#code:200
+type sample struct {
+	name   string
+	labels models.Labels
+	kind   string
+	value  float64
+	// gauge value is change of the current one
+	relative bool
+	// member of set
+	member string
+	rate   float64
+}
+
+// parseLine parses line of StatsD protocol with optional sample rate
+// and DogStatsD tags, which become labels
+func parseLine(line string) (sample, error) {
+	s := sample{rate: 1}
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return s, errors.New("missing value")
+	}
+	// dots separating StatsD name segments become underscores
+	s.name = models.SanitizeName(name)
+	if s.name == "" {
+		return s, errors.New("empty name")
+	}
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return s, errors.New("missing type")
+	}
+	raw, kind := parts[0], parts[1]
+	switch kind {
+	case typeCounter, typeGauge, typeTimer, typeHistogram:
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return s, fmt.Errorf("invalid value %q", raw)
+		}
+		s.value = value
+		s.relative = kind == typeGauge && (raw[0] == '+' || raw[0] == '-')
+	case typeSet:
+		if raw == "" {
+			return s, errors.New("empty set member")
+		}
+		s.member = raw
+	default:
+		return s, fmt.Errorf("unknown type %q", kind)
+	}
+	if kind == typeHistogram {
+		kind = typeTimer
+	}
+	s.kind = kind
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return s, fmt.Errorf("invalid sample rate %q", part)
+			}
+			s.rate = rate
+		case strings.HasPrefix(part, "#"):
+			labels, err := parseTags(part[1:])
+			if err != nil {
+				return s, err
+			}
+			s.labels = labels
+		default:
+			return s, fmt.Errorf("unknown section %q", part)
+		}
+	}
+	if kind == typeCounter && !inCounterRange(s.value/s.rate) {
+		return s, fmt.Errorf("counter value %q out of range", raw)
+	}
+	return s, nil
+}
+
+// parseTags converts tags into labels, tags without value are set to true
+func parseTags(raw string) (models.Labels, error) {
+	tags := strings.Split(raw, ",")
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("more than %d tags", maxTags)
+	}
+	labels := make(models.Labels, len(tags))
+	for _, tag := range tags {
+		key, value, ok := strings.Cut(tag, ":")
+		if !ok {
+			value = "true"
+		}
+		key = models.SanitizeLabelName(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		labels[key] = value
+	}
+	return labels, nil
+}
+
+// inCounterRange reports whether value converts to int64 delta,
+// float64(math.MaxInt64) itself is rounded up out of range
+func inCounterRange(value float64) bool {
+	return value >= math.MinInt64 && value < math.MaxInt64
+}
@@ -0,0 +1,125 @@
+package statsd
+
+import (
+	"testing"
+
+	models "github.com/funkymotions/go-ya-practicum-metrics/internal/model"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    sample
+		wantErr bool
+	}{
+		{
+			name: "should parse counter",
+			line: "api.requests:1|c",
+			want: sample{name: "api_requests", kind: typeCounter, value: 1, rate: 1},
+		},
+		{
+			name: "should parse sampled counter with tags",
+			line: "api.requests:2|c|@0.5|#code:200,canary",
+			want: sample{
+				name:   "api_requests",
+				kind:   typeCounter,
+				value:  2,
+				rate:   0.5,
+				labels: models.Labels{"code": "200", "canary": "true"},
+			},
+		},
+		{
+			name: "should parse gauge",
+			line: "queue.size:3.2|g",
+			want: sample{name: "queue_size", kind: typeGauge, value: 3.2, rate: 1},
+		},
+		{
+			name: "should parse relative gauge",
+			line: "queue.size:-4|g",
+			want: sample{name: "queue_size", kind: typeGauge, value: -4, relative: true, rate: 1},
+		},
+		{
+			name: "should parse histogram as timer",
+			line: "db.query:12.5|h",
+			want: sample{name: "db_query", kind: typeTimer, value: 12.5, rate: 1},
+		},
+		{
+			name: "should parse set",
+			line: "users:alice|s",
+			want: sample{name: "users", kind: typeSet, member: "alice", rate: 1},
+		},
+		{
+			name: "should prefix label starting with digit",
+			line: "up:1|g|#5xx:0",
+			want: sample{name: "up", kind: typeGauge, value: 1, rate: 1, labels: models.Labels{"_5xx": "0"}},
+		},
+		{
+			name:    "should reject line without value",
+			line:    "api.requests",
+			wantErr: true,
+		},
+		{
+			name:    "should reject line without type",
+			line:    "api.requests:1",
+			wantErr: true,
+		},
+		{
+			name:    "should reject unknown type",
+			line:    "api.requests:1|x",
+			wantErr: true,
+		},
+		{
+			name:    "should reject invalid value",
+			line:    "api.requests:one|c",
+			wantErr: true,
+		},
+		{
+			name:    "should reject NaN value",
+			line:    "queue.size:NaN|g",
+			wantErr: true,
+		},
+		{
+			name:    "should reject infinite value",
+			line:    "db.query:+Inf|ms",
+			wantErr: true,
+		},
+		{
+			name:    "should reject counter over int64",
+			line:    "api.requests:1e19|c",
+			wantErr: true,
+		},
+		{
+			name:    "should reject counter over int64 after sample rate",
+			line:    "api.requests:5e18|c|@0.1",
+			wantErr: true,
+		},
+		{
+			name:    "should reject invalid sample rate",
+			line:    "api.requests:1|c|@2",
+			wantErr: true,
+		},
+		{
+			name:    "should reject empty name",
+			line:    ":1|c",
+			wantErr: true,
+		},
+		{
+			name:    "should reject too many tags",
+			line:    "up:1|g|#a,b,c,d,e,f,g,h,i,j,k,l,m,n,o,p,q",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLine(tt.line)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
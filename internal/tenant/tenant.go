@@ -0,0 +1,33 @@
+package tenant
+
+import (
+	"context"
+	"regexp"
+)
+
+// Header names tenant of request not bound to tenant by API key
+const Header = "X-Tenant-ID"
+
+// Default tenant owns metrics of requests naming no tenant,
+// its storage keys are the same as before tenants were introduced
+const Default = ""
+
+var idRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+type ctxKey struct{}
+
+// Valid reports whether id may name tenant
+func Valid(id string) bool {
+	return idRe.MatchString(id)
+}
+
+// WithID returns ctx carrying tenant id
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns tenant of ctx, Default when ctx carries none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
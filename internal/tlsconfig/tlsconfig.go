@@ -0,0 +1,237 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// how often files are checked for changes by default
+const defaultReloadInterval = 10 * time.Second
+
+type Config struct {
+	// certificate presented to peer, server certificate on server and
+	// client certificate on agent
+	CertFile string
+	KeyFile  string
+	// CA bundle verifying peer certificates, on server it enables mutual TLS,
+	// on agent empty bundle means system roots
+	CAFile string
+	// zero uses default interval
+	ReloadInterval time.Duration
+	Logger         *zap.Logger
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Reloader keeps certificate and CA pool loaded from files and reloads them
+// once files change, so certificates may be rotated without restart
+type Reloader struct {
+	config Config
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	pool   *x509.CertPool
+	stamps map[string]fileStamp
+}
+
+func NewReloader(cfg Config) (*Reloader, error) {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("certificate and key files must be set together")
+	}
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = defaultReloadInterval
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = zap.NewNop()
+	}
+	r := &Reloader{config: cfg}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Reloader) Run(stopCh chan struct{}, doneCh chan struct{}) {
+	defer close(doneCh)
+	ticker := time.NewTicker(r.config.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := r.reload()
+			if err != nil {
+				// previous certificates stay in use until files are fixed
+				r.config.Logger.Error("Failed to reload TLS files", zap.Error(err))
+				continue
+			}
+			if reloaded {
+				r.config.Logger.Info("TLS files reloaded")
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// reload loads files when any of them changed since the last load
+func (r *Reloader) reload() (bool, error) {
+	stamps, err := r.statFiles()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	changed := r.stamps == nil || !sameStamps(r.stamps, stamps)
+	r.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	var cert *tls.Certificate
+	if r.config.CertFile != "" {
+		pair, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to load key pair: %w", err)
+		}
+		cert = &pair
+	}
+	var pool *x509.CertPool
+	if r.config.CAFile != "" {
+		pool, err = loadPool(r.config.CAFile)
+		if err != nil {
+			return false, err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cert = cert
+	r.pool = pool
+	r.stamps = stamps
+	return true, nil
+}
+
+func (r *Reloader) statFiles() (map[string]fileStamp, error) {
+	stamps := make(map[string]fileStamp, 3)
+	for _, path := range []string{r.config.CertFile, r.config.KeyFile, r.config.CAFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		stamps[path] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return stamps, nil
+}
+
+func sameStamps(a, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stamp := range b {
+		if !a[path].modTime.Equal(stamp.modTime) || a[path].size != stamp.size {
+			return false
+		}
+	}
+	return true
+}
+
+func loadPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, r.pool
+}
+
+// ServerConfig serves current certificate and requires client certificates
+// signed by CA bundle when it is configured
+func (r *Reloader) ServerConfig() *tls.Config {
+	getCertificate := func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, _ := r.current()
+		if cert == nil {
+			return nil, errors.New("server certificate is not configured")
+		}
+		return cert, nil
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+		// per handshake config picks up reloaded CA bundle
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: getCertificate,
+			}
+			if _, pool := r.current(); pool != nil {
+				cfg.ClientCAs = pool
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig trusts CA bundle, or system roots without it, presents
+// current client certificate when server asks for it and requires server
+// certificate issued for serverName, which is host name or IP address
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			if cert == nil {
+				// empty certificate lets server decide
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+	}
+	if r.config.CAFile == "" {
+		return cfg
+	}
+	// RootCAs are fixed once config is in use, so server chain is verified
+	// against reloaded bundle in VerifyConnection instead
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("server presented no certificate")
+		}
+		if serverName == "" {
+			return errors.New("server name is not configured")
+		}
+		_, pool := r.current()
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			// no SNI is sent to IP address, so connection state has no
+			// server name and expected one is checked instead
+			DNSName:       serverName,
+			Roots:         pool,
+			Intermediates: intermediates,
+		})
+		return err
+	}
+	return cfg
+}
@@ -0,0 +1,246 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "metrics test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue signs leaf certificate valid for localhost and returns PEM pair
+func (ca *testCA) issue(t *testing.T, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	t.Helper()
+	return ca.issueFor(t, serial, usage, "localhost", net.ParseIP("127.0.0.1"))
+}
+
+// issueFor signs leaf certificate valid for host name and IP address
+func (ca *testCA) issueFor(t *testing.T, serial int64, usage x509.ExtKeyUsage, host string, ip net.IP) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{host},
+		IPAddresses:  []net.IP{ip},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+// files writes pair and CA bundle into dir and returns reloader config
+func files(t *testing.T, dir string, cert, key, ca []byte) Config {
+	t.Helper()
+	var cfg Config
+	if cert != nil {
+		cfg.CertFile = writeFile(t, dir, "cert.pem", cert)
+		cfg.KeyFile = writeFile(t, dir, "key.pem", key)
+	}
+	if ca != nil {
+		cfg.CAFile = writeFile(t, dir, "ca.pem", ca)
+	}
+	return cfg
+}
+
+func newTLSServer(t *testing.T, cfg Config) *httptest.Server {
+	t.Helper()
+	reloader, err := NewReloader(cfg)
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = reloader.ServerConfig()
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newTLSClient(t *testing.T, cfg Config) *http.Client {
+	t.Helper()
+	reloader, err := NewReloader(cfg)
+	require.NoError(t, err)
+	// test servers listen on loopback address
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: reloader.ClientConfig("127.0.0.1")}}
+}
+
+func TestReloader_Handshake(t *testing.T) {
+	ca := newTestCA(t)
+	otherCA := newTestCA(t)
+	serverCert, serverKey := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := ca.issue(t, 3, x509.ExtKeyUsageClientAuth)
+	foreignCert, foreignKey := otherCA.issue(t, 4, x509.ExtKeyUsageClientAuth)
+	otherHostCert, otherHostKey := ca.issueFor(t, 5, x509.ExtKeyUsageServerAuth, "metrics.internal", net.ParseIP("10.0.0.1"))
+	tests := []struct {
+		name    string
+		server  Config
+		client  Config
+		wantErr bool
+	}{
+		{
+			name:   "should serve TLS to client trusting CA",
+			server: files(t, t.TempDir(), serverCert, serverKey, nil),
+			client: files(t, t.TempDir(), nil, nil, ca.pem),
+		},
+		{
+			name:    "should reject server signed by unknown CA",
+			server:  files(t, t.TempDir(), serverCert, serverKey, nil),
+			client:  files(t, t.TempDir(), nil, nil, otherCA.pem),
+			wantErr: true,
+		},
+		{
+			name:    "should reject server certificate issued for another address",
+			server:  files(t, t.TempDir(), otherHostCert, otherHostKey, nil),
+			client:  files(t, t.TempDir(), nil, nil, ca.pem),
+			wantErr: true,
+		},
+		{
+			name:   "should accept client certificate signed by CA",
+			server: files(t, t.TempDir(), serverCert, serverKey, ca.pem),
+			client: files(t, t.TempDir(), clientCert, clientKey, ca.pem),
+		},
+		{
+			name:    "should reject client without certificate",
+			server:  files(t, t.TempDir(), serverCert, serverKey, ca.pem),
+			client:  files(t, t.TempDir(), nil, nil, ca.pem),
+			wantErr: true,
+		},
+		{
+			name:    "should reject client certificate signed by unknown CA",
+			server:  files(t, t.TempDir(), serverCert, serverKey, ca.pem),
+			client:  files(t, t.TempDir(), foreignCert, foreignKey, ca.pem),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTLSServer(t, tt.server)
+			resp, err := newTLSClient(t, tt.client).Get(ts.URL)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestReloader_Reload(t *testing.T) {
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	dir := t.TempDir()
+	cfg := files(t, dir, cert, key, nil)
+	reloader, err := NewReloader(cfg)
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = reloader.ServerConfig()
+	ts.StartTLS()
+	defer ts.Close()
+	serial := func() int64 {
+		conn, err := tls.Dial("tcp", ts.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		require.NoError(t, err)
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	require.Equal(t, int64(2), serial())
+
+	reloaded, err := reloader.reload()
+	require.NoError(t, err)
+	require.False(t, reloaded, "unchanged files should not be reloaded")
+
+	// broken files keep previous certificate in use
+	require.NoError(t, os.WriteFile(cfg.CertFile, []byte("garbage"), 0600))
+	_, err = reloader.reload()
+	require.Error(t, err)
+	require.Equal(t, int64(2), serial())
+
+	cert, key = ca.issue(t, 5, x509.ExtKeyUsageServerAuth)
+	files(t, dir, cert, key, nil)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(cfg.CertFile, later, later))
+	reloaded, err = reloader.reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Equal(t, int64(5), serial())
+}
+
+func TestNewReloader_Errors(t *testing.T) {
+	ca := newTestCA(t)
+	cert, key := ca.issue(t, 2, x509.ExtKeyUsageServerAuth)
+	dir := t.TempDir()
+	valid := files(t, dir, cert, key, ca.pem)
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "should require key with certificate", cfg: Config{CertFile: valid.CertFile}},
+		{name: "should fail on missing file", cfg: Config{CAFile: filepath.Join(dir, "missing.pem")}},
+		{name: "should fail on mismatched pair", cfg: Config{CertFile: valid.CertFile, KeyFile: valid.CAFile}},
+		{name: "should fail on empty CA bundle", cfg: Config{CAFile: writeFile(t, dir, "empty.pem", []byte("none"))}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReloader(tt.cfg)
+			require.Error(t, err)
+		})
+	}
+}
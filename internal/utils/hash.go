@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// HashBody returns hex encoded HMAC-SHA256 of body signed by key
+func HashBody(key, body []byte) string {
+	h := hmac.New(sha256.New, key)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// IsHashValid checks hex encoded HMAC-SHA256 signature of payload
+func IsHashValid(signature, payload, secret []byte) bool {
+	if len(signature) == 0 || len(secret) == 0 {
+		return false
+	}
+	decodedSignature, err := hex.DecodeString(string(signature))
+	if err != nil {
+		return false
+	}
+	h := hmac.New(sha256.New, secret)
+	h.Write(payload)
+	hash := h.Sum(nil)
+	return hmac.Equal(decodedSignature, hash)
+}
@@ -1,7 +1,11 @@
 package utils
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math"
+	"math/rand"
 	"time"
 )
 
@@ -10,21 +14,100 @@ type RetriableError interface {
 	IsRetriable() bool
 }
 
-func WithRetry(fn func() error, attempts int, maxAttempts int) error {
-	if maxAttempts == 0 {
-		return fn()
-	}
-	if attempts >= maxAttempts {
-		return errors.New("max attempts reached")
+// Clock abstracts waiting between attempts, so tests do not sleep
+type Clock interface {
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// RetryPolicy retries failed operation with exponential backoff,
+// zero value runs operation once
+type RetryPolicy struct {
+	// total number of attempts including the first one
+	MaxAttempts int
+	BaseDelay   time.Duration
+	// upper bound of backoff, zero means no bound
+	MaxDelay   time.Duration
+	Multiplier float64
+	// full jitter waits random duration in [0, backoff)
+	Jitter bool
+	// IsRetriable classifies errors, by default errors implementing
+	// RetriableError are retried
+	IsRetriable func(err error) bool
+	Clock       Clock
+	// Rand returns value in [0, 1) used by jitter
+	Rand func() float64
+}
+
+// DefaultRetryPolicy makes three attempts waiting about 1s and 2s in between
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Second,
+		MaxDelay:    5 * time.Second,
+		Multiplier:  2,
+		Jitter:      true,
 	}
-	secondsToSleep := time.Duration(2*(attempts)+1) * time.Second
+}
+
+// IsRetriableError reports whether any error in chain asks to be retried
+func IsRetriableError(err error) bool {
 	var retriableErr RetriableError
-	if err := fn(); err != nil {
-		if errors.As(err, &retriableErr) && retriableErr.IsRetriable() {
-			time.Sleep(secondsToSleep)
-			return WithRetry(fn, attempts+1, maxAttempts)
+	return errors.As(err, &retriableErr) && retriableErr.IsRetriable()
+}
+
+// Backoff returns delay before attempt following the given failed one,
+// attempts are numbered from one
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter {
+		random := p.Rand
+		if random == nil {
+			random = rand.Float64
+		}
+		delay *= random()
+	}
+	return time.Duration(delay)
+}
+
+// Do runs fn until it succeeds, fails with non-retriable error, attempts
+// are exhausted or ctx is done, the last error is returned
+func (p RetryPolicy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	isRetriable := p.IsRetriable
+	if isRetriable == nil {
+		isRetriable = IsRetriableError
+	}
+	clock := p.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil || !isRetriable(err) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			if p.MaxAttempts > 1 {
+				return fmt.Errorf("max attempts reached: %w", err)
+			}
+			return err
+		}
+		select {
+		case <-clock.After(p.Backoff(attempt)):
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
 		}
-		return err
 	}
-	return nil
 }
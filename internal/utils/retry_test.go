@@ -0,0 +1,181 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock fires immediately and records requested waits
+type fakeClock struct {
+	waits []time.Duration
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+// blockingClock never fires
+type blockingClock struct{}
+
+func (blockingClock) After(time.Duration) <-chan time.Time {
+	return nil
+}
+
+type retriableStub struct {
+	retriable bool
+}
+
+func (e *retriableStub) Error() string {
+	return "stub error"
+}
+
+func (e *retriableStub) IsRetriable() bool {
+	return e.retriable
+}
+
+// failing returns fn failing with err n times before success
+func failing(n int, err error, calls *int) func(context.Context) error {
+	return func(context.Context) error {
+		*calls++
+		if *calls <= n {
+			return err
+		}
+		return nil
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy RetryPolicy
+		want   []time.Duration
+	}{
+		{
+			name:   "should grow exponentially up to max delay",
+			policy: RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second, Multiplier: 2},
+			want:   []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second},
+		},
+		{
+			name:   "should keep constant delay without multiplier",
+			policy: RetryPolicy{BaseDelay: time.Second},
+			want:   []time.Duration{time.Second, time.Second, time.Second, time.Second},
+		},
+		{
+			name: "should scale delay by jitter",
+			policy: RetryPolicy{
+				BaseDelay:  time.Second,
+				Multiplier: 3,
+				Jitter:     true,
+				Rand:       func() float64 { return 0.5 },
+			},
+			want: []time.Duration{500 * time.Millisecond, 1500 * time.Millisecond, 4500 * time.Millisecond, 13500 * time.Millisecond},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]time.Duration, 0, len(tt.want))
+			for attempt := 1; attempt <= len(tt.want); attempt++ {
+				got = append(got, tt.policy.Backoff(attempt))
+			}
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	retriable := &retriableStub{retriable: true}
+	permanent := &retriableStub{retriable: false}
+	tests := []struct {
+		name      string
+		policy    RetryPolicy
+		failures  int
+		err       error
+		wantErr   bool
+		wantCalls int
+		wantWaits []time.Duration
+	}{
+		{
+			name:      "should succeed after retries",
+			policy:    RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, Multiplier: 2},
+			failures:  2,
+			err:       retriable,
+			wantCalls: 3,
+			wantWaits: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:      "should give up when attempts are exhausted",
+			policy:    RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, Multiplier: 2},
+			failures:  5,
+			err:       retriable,
+			wantErr:   true,
+			wantCalls: 3,
+			wantWaits: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:      "should not retry permanent error",
+			policy:    RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second},
+			failures:  1,
+			err:       permanent,
+			wantErr:   true,
+			wantCalls: 1,
+			wantWaits: nil,
+		},
+		{
+			name:      "should run once with zero policy",
+			failures:  1,
+			err:       retriable,
+			wantErr:   true,
+			wantCalls: 1,
+			wantWaits: nil,
+		},
+		{
+			name: "should use custom classifier",
+			policy: RetryPolicy{
+				MaxAttempts: 2,
+				BaseDelay:   time.Second,
+				IsRetriable: func(err error) bool { return true },
+			},
+			failures:  1,
+			err:       errors.New("plain error"),
+			wantCalls: 2,
+			wantWaits: []time.Duration{time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{}
+			tt.policy.Clock = clock
+			calls := 0
+			err := tt.policy.Do(context.Background(), failing(tt.failures, tt.err, &calls))
+			if tt.wantErr {
+				require.ErrorIs(t, err, tt.err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.wantCalls, calls)
+			require.Equal(t, tt.wantWaits, clock.waits)
+		})
+	}
+}
+
+func TestRetryPolicy_DoCancelled(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, Clock: blockingClock{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	retriable := &retriableStub{retriable: true}
+	calls := 0
+	err := policy.Do(ctx, func(context.Context) error {
+		calls++
+		cancel()
+		return retriable
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorIs(t, err, retriable)
+	require.Equal(t, 1, calls, "waiting should stop once context is cancelled")
+}
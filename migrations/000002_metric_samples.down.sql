@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS metric_samples_series_idx;
+DROP TABLE IF EXISTS metric_samples;
\ No newline at end of file
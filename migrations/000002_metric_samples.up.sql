@@ -0,0 +1,11 @@
+-- creates table with history of metric values
+CREATE TABLE IF NOT EXISTS metric_samples (
+  id VARCHAR(255) NOT NULL,
+  metric_type_id INT REFERENCES metric_types(id),
+  value DOUBLE PRECISION,
+  delta BIGINT,
+  created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS metric_samples_series_idx
+  ON metric_samples (id, metric_type_id, created_at);
@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS metric_samples_series_idx;
+ALTER TABLE metric_samples DROP COLUMN IF EXISTS series_key;
+ALTER TABLE metric_samples DROP COLUMN IF EXISTS labels;
+CREATE INDEX IF NOT EXISTS metric_samples_series_idx
+  ON metric_samples (id, metric_type_id, created_at);
+
+-- labeled series can not be kept under name-only primary key
+DELETE FROM metrics WHERE labels <> '{}'::jsonb;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics DROP COLUMN IF EXISTS series_key;
+ALTER TABLE metrics DROP COLUMN IF EXISTS labels;
+ALTER TABLE metrics ADD CONSTRAINT metrics_pkey PRIMARY KEY (id, metric_type_id);
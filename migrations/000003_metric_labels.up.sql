@@ -0,0 +1,15 @@
+-- adds labels to metrics, series are identified by name with rendered labels
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS series_key VARCHAR(1024);
+UPDATE metrics SET series_key = id WHERE series_key IS NULL;
+ALTER TABLE metrics ALTER COLUMN series_key SET NOT NULL;
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics ADD CONSTRAINT metrics_pkey PRIMARY KEY (series_key, metric_type_id);
+
+ALTER TABLE metric_samples ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE metric_samples ADD COLUMN IF NOT EXISTS series_key VARCHAR(1024);
+UPDATE metric_samples SET series_key = id WHERE series_key IS NULL;
+ALTER TABLE metric_samples ALTER COLUMN series_key SET NOT NULL;
+DROP INDEX IF EXISTS metric_samples_series_idx;
+CREATE INDEX IF NOT EXISTS metric_samples_series_idx
+  ON metric_samples (series_key, metric_type_id, created_at);
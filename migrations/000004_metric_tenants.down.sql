@@ -0,0 +1,11 @@
+-- series of other tenants would collide with default tenant ones
+DELETE FROM metric_samples WHERE tenant_id <> '';
+DROP INDEX IF EXISTS metric_samples_series_idx;
+ALTER TABLE metric_samples DROP COLUMN IF EXISTS tenant_id;
+CREATE INDEX IF NOT EXISTS metric_samples_series_idx
+  ON metric_samples (series_key, metric_type_id, created_at);
+
+DELETE FROM metrics WHERE tenant_id <> '';
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE metrics ADD CONSTRAINT metrics_pkey PRIMARY KEY (series_key, metric_type_id);
@@ -0,0 +1,9 @@
+-- adds tenants, series written without tenant belong to '' tenant
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics ADD CONSTRAINT metrics_pkey PRIMARY KEY (tenant_id, series_key, metric_type_id);
+
+ALTER TABLE metric_samples ADD COLUMN IF NOT EXISTS tenant_id VARCHAR(64) NOT NULL DEFAULT '';
+DROP INDEX IF EXISTS metric_samples_series_idx;
+CREATE INDEX IF NOT EXISTS metric_samples_series_idx
+  ON metric_samples (tenant_id, series_key, metric_type_id, created_at);
@@ -0,0 +1,3 @@
+-- lets old samples be deleted without scanning whole history
+CREATE INDEX IF NOT EXISTS metric_samples_created_at_idx
+  ON metric_samples (created_at);